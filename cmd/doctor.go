@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esiclient"
+	"github.com/lunemec/eve-quartermaster/pkg/token"
+
+	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// doctorCmd checks the bot configuration without running it.
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check EVE login, character roles, Discord channel access and repository file",
+	Run:   runDoctor,
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringVarP(&authfile, "auth_file", "a", "auth.bin", "path to file where to save authentication data")
+	doctorCmd.Flags().StringVarP(&sessionKey, "session_key", "s", "", "session key, use random string")
+	doctorCmd.Flags().StringVar(&eveClientID, "eve_client_id", "", "EVE APP client id")
+	doctorCmd.Flags().StringVar(&eveSSOSecret, "eve_sso_secret", "", "EVE APP SSO secret")
+	doctorCmd.Flags().StringVar(&discordChannelID, "discord_channel_id", "", "ID of discord channel")
+	doctorCmd.Flags().StringVar(&discordAuthToken, "discord_auth_token", "", "Auth token for discord")
+	doctorCmd.Flags().Int32Var(&corporationID, "corporation_id", 0, "Corporation ID for which to list contracts")
+	doctorCmd.Flags().Int32Var(&allianceID, "alliance_id", 0, "Alliance ID for which to list contracts")
+	doctorCmd.Flags().StringVar(&repositoryFile, "repository_file", "repository.db", "path to bbolt repository file to save doctrine data (default repository.db)")
+
+	must(doctorCmd.MarkFlagRequired("session_key"))
+	must(doctorCmd.MarkFlagRequired("eve_client_id"))
+	must(doctorCmd.MarkFlagRequired("eve_sso_secret"))
+	must(doctorCmd.MarkFlagRequired("discord_channel_id"))
+	must(doctorCmd.MarkFlagRequired("discord_auth_token"))
+	must(doctorCmd.MarkFlagRequired("corporation_id"))
+}
+
+// doctorCheck is one check of the doctor command, it returns what was found
+// or error describing what is wrong.
+type doctorCheck struct {
+	name  string
+	check func() (string, error)
+}
+
+// doctorWarning is a problem found by the check which does not fail it.
+type doctorWarning struct {
+	error
+}
+
+func warningf(format string, args ...interface{}) error {
+	return doctorWarning{errors.Errorf(format, args...)}
+}
+
+// contractRoles are corporation roles of which the character needs at least
+// one to read corporation contracts.
+var contractRoles = []string{"Director", "Contract_Manager"}
+
+// Permissions the bot needs in the Discord channel.
+const discordChannelPermissions = discordgo.PermissionViewChannel |
+	discordgo.PermissionSendMessages |
+	discordgo.PermissionEmbedLinks |
+	discordgo.PermissionAddReactions
+
+func runDoctor(cmd *cobra.Command, args []string) {
+	fastLog, err := zap.NewDevelopment()
+	if err != nil {
+		panic(fmt.Sprintf("error inicializing logger: %s", err))
+	}
+	log := fastLog.Sugar()
+
+	client := httpClient()
+	tokenSource := token.NewSource(
+		log,
+		client,
+		token.NewFileStorage(authfile),
+		[]byte(sessionKey),
+		eveClientID,
+		eveSSOSecret,
+		eveCallbackURL,
+		eveScopes,
+	)
+	esi := esiclient.New(log, client)
+	ctx := context.WithValue(context.Background(), goesi.ContextOAuth2, tokenSource)
+
+	// Checks after the token check need the logged in character.
+	var character *goesi.VerifyResponse
+	checks := []doctorCheck{
+		{
+			name:  "repository file opens",
+			check: checkRepositoryFile,
+		},
+		{
+			name: "EVE login has all scopes",
+			check: func() (string, error) {
+				var err error
+				character, err = tokenSource.Verify()
+				if err != nil {
+					if token.IsRevoked(err) {
+						return "", errors.Wrap(err, "EVE login was revoked or expired, run quartermaster login again")
+					}
+					return "", errors.Wrap(err, "unable to verify EVE login, run quartermaster login")
+				}
+				return checkScopes(character)
+			},
+		},
+		{
+			name: "character is in --corporation_id",
+			check: func() (string, error) {
+				if character == nil {
+					return "", errors.New("skipped, EVE login is not valid")
+				}
+				info, _, err := esi.ESI.CharacterApi.GetCharactersCharacterId(ctx, character.CharacterID, nil)
+				if err != nil {
+					return "", errors.Wrap(err, "error calling ESI API for character")
+				}
+				if info.CorporationId != corporationID {
+					return "", errors.Errorf("%s is in corporation %d, not %d", character.CharacterName, info.CorporationId, corporationID)
+				}
+				return fmt.Sprintf("%s is in corporation %d", character.CharacterName, corporationID), nil
+			},
+		},
+		{
+			name: "character has corporation roles for contracts",
+			check: func() (string, error) {
+				if character == nil {
+					return "", errors.New("skipped, EVE login is not valid")
+				}
+				roles, _, err := esi.ESI.CharacterApi.GetCharactersCharacterIdRoles(ctx, character.CharacterID, nil)
+				if err != nil {
+					return "", errors.Wrap(err, "error calling ESI API for character roles")
+				}
+				return checkRoles(character.CharacterName, roles)
+			},
+		},
+		{
+			name: "character can read corporation contracts",
+			check: func() (string, error) {
+				if character == nil {
+					return "", errors.New("skipped, EVE login is not valid")
+				}
+				contracts, _, err := esi.ESI.ContractsApi.GetCorporationsCorporationIdContracts(ctx, corporationID, nil)
+				if err != nil {
+					return "", errors.Wrapf(err, "%s can't read corporation contracts, log in with a character that has the roles", character.CharacterName)
+				}
+				return fmt.Sprintf("%d contracts on the first page", len(contracts)), nil
+			},
+		},
+		{
+			name: "character can read corporation members",
+			check: func() (string, error) {
+				if character == nil {
+					return "", errors.New("skipped, EVE login is not valid")
+				}
+				members, _, err := esi.ESI.CorporationApi.GetCorporationsCorporationIdMembers(ctx, corporationID, nil)
+				if err != nil {
+					return "", errors.Wrapf(err, "%s can't read corporation members, needed by --issuer_policy members", character.CharacterName)
+				}
+				return fmt.Sprintf("%d members", len(members)), nil
+			},
+		},
+		{
+			name: "--alliance_id is the corporation alliance",
+			check: func() (string, error) {
+				corporation, _, err := esi.ESI.CorporationApi.GetCorporationsCorporationId(ctx, corporationID, nil)
+				if err != nil {
+					return "", errors.Wrap(err, "error calling ESI API for corporation")
+				}
+				return checkAlliance(allianceID, corporation)
+			},
+		},
+		{
+			name:  "Discord bot can post embeds to --discord_channel_id",
+			check: checkDiscordChannel,
+		},
+	}
+
+	var failed, warned int
+	for _, check := range checks {
+		result, err := check.check()
+		var warning doctorWarning
+		switch {
+		case errors.As(err, &warning):
+			warned++
+			fmt.Printf("WARN %s: %s\n", check.name, warning)
+		case err != nil:
+			failed++
+			fmt.Printf("FAIL %s: %s\n", check.name, err)
+		default:
+			fmt.Printf("PASS %s: %s\n", check.name, result)
+		}
+	}
+	if failed != 0 {
+		fmt.Printf("%d of %d checks failed, %d with warnings.\n", failed, len(checks), warned)
+		os.Exit(1)
+	}
+	if warned != 0 {
+		fmt.Printf("All %d checks passed, %d with warnings.\n", len(checks), warned)
+		return
+	}
+	fmt.Printf("All %d checks passed.\n", len(checks))
+}
+
+// checkRepositoryFile opens the repository read-only, it warns when the bot
+// is running as bbolt allows only one process to open the file.
+func checkRepositoryFile() (string, error) {
+	if _, err := os.Stat(repositoryFile); os.IsNotExist(err) {
+		return fmt.Sprintf("%s does not exist yet, it will be created", repositoryFile), nil
+	}
+	db, err := bolt.Open(repositoryFile, 0600, &bolt.Options{ReadOnly: true, Timeout: 5 * time.Second})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return "", warningf("%s is in use by running bot, stop the bot to check it", repositoryFile)
+		}
+		return "", errors.Wrapf(err, "unable to open DB file: %s", repositoryFile)
+	}
+	defer db.Close()
+
+	var buckets []string
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			buckets = append(buckets, string(name))
+			return nil
+		})
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read DB file")
+	}
+	return fmt.Sprintf("%s has buckets: %s", repositoryFile, strings.Join(buckets, ", ")), nil
+}
+
+// checkScopes compares scopes granted to the token with eveScopes.
+func checkScopes(character *goesi.VerifyResponse) (string, error) {
+	var granted = make(map[string]struct{})
+	for _, scope := range strings.Fields(character.Scopes) {
+		granted[scope] = struct{}{}
+	}
+	var missing []string
+	for _, scope := range eveScopes {
+		// publicData is implicit, it is not listed in the token.
+		if scope == "publicData" {
+			continue
+		}
+		if _, ok := granted[scope]; !ok {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) != 0 {
+		return "", errors.Errorf("%s is missing scopes: %s, run quartermaster login again", character.CharacterName, strings.Join(missing, ", "))
+	}
+	return fmt.Sprintf("logged in as %s", character.CharacterName), nil
+}
+
+// checkRoles checks the character has at least one of contractRoles.
+func checkRoles(characterName string, roles esi.GetCharactersCharacterIdRolesOk) (string, error) {
+	for _, role := range roles.Roles {
+		for _, contractRole := range contractRoles {
+			if role == contractRole {
+				return fmt.Sprintf("%s has role %s", characterName, role), nil
+			}
+		}
+	}
+	return "", errors.Errorf("%s needs one of corporation roles: %s, log in with a character that has the roles", characterName, strings.Join(contractRoles, ", "))
+}
+
+// checkAlliance compares allianceID (--alliance_id) with the corporation alliance.
+func checkAlliance(allianceID int32, corporation esi.GetCorporationsCorporationIdOk) (string, error) {
+	switch {
+	case allianceID == 0 && corporation.AllianceId == 0:
+		return "corporation is not in alliance", nil
+	case allianceID == 0:
+		return "", errors.Errorf("corporation is in alliance %d, set --alliance_id to track alliance contracts", corporation.AllianceId)
+	case allianceID != corporation.AllianceId:
+		return "", errors.Errorf("corporation is in alliance %d, not %d", corporation.AllianceId, allianceID)
+	}
+	return fmt.Sprintf("corporation is in alliance %d", allianceID), nil
+}
+
+// checkDiscordChannel checks the bot permissions in the channel without
+// posting anything.
+func checkDiscordChannel() (string, error) {
+	discord, err := discordgo.New("Bot " + discordAuthToken)
+	if err != nil {
+		return "", errors.Wrap(err, "error inicializing discord client")
+	}
+	user, err := discord.User("@me")
+	if err != nil {
+		return "", errors.Wrap(err, "invalid Discord auth token")
+	}
+	channel, err := discord.Channel(discordChannelID)
+	if err != nil {
+		return "", errors.Wrapf(err, "bot %s can't see channel %s", user.Username, discordChannelID)
+	}
+	permissions, err := discord.UserChannelPermissions(user.ID, discordChannelID)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to read bot permissions in #%s", channel.Name)
+	}
+	if permissions&discordChannelPermissions != discordChannelPermissions {
+		return "", errors.Errorf("bot %s needs View Channel, Send Messages, Embed Links and Add Reactions permissions in #%s", user.Username, channel.Name)
+	}
+	return fmt.Sprintf("bot %s can post to #%s", user.Username, channel.Name), nil
+}
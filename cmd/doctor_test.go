@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
+)
+
+func TestCheckScopes(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		scopes  []string
+		missing []string
+	}{
+		{
+			name:   "all granted",
+			scopes: eveScopes[1:],
+		},
+		{
+			name:    "missing",
+			scopes:  []string{"esi-contracts.read_corporation_contracts.v1"},
+			missing: eveScopes[2:],
+		},
+		{
+			name:    "none",
+			missing: eveScopes[1:],
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := checkScopes(&goesi.VerifyResponse{
+				CharacterName: "Test Character",
+				Scopes:        strings.Join(test.scopes, " "),
+			})
+			if len(test.missing) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error for missing scopes %v", test.missing)
+			}
+			for _, scope := range test.missing {
+				if !strings.Contains(err.Error(), scope) {
+					t.Errorf("error %q does not list missing scope %s", err, scope)
+				}
+			}
+			if strings.Contains(err.Error(), "publicData") {
+				t.Errorf("error %q lists implicit publicData scope", err)
+			}
+		})
+	}
+}
+
+func TestCheckRoles(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		roles []string
+		fail  bool
+	}{
+		{name: "director", roles: []string{"Accountant", "Director"}},
+		{name: "contract manager", roles: []string{"Contract_Manager"}},
+		{name: "other roles", roles: []string{"Accountant", "Trader"}, fail: true},
+		{name: "no roles", fail: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := checkRoles("Test Character", esi.GetCharactersCharacterIdRolesOk{Roles: test.roles})
+			if test.fail != (err != nil) {
+				t.Errorf("got error %v, want failure %t", err, test.fail)
+			}
+		})
+	}
+}
+
+func TestCheckAlliance(t *testing.T) {
+	for _, test := range []struct {
+		name                string
+		allianceID          int32
+		corporationAlliance int32
+		fail                bool
+	}{
+		{name: "no alliance"},
+		{name: "same alliance", allianceID: 99000001, corporationAlliance: 99000001},
+		{name: "--alliance_id not set", corporationAlliance: 99000001, fail: true},
+		{name: "corporation not in alliance", allianceID: 99000001, fail: true},
+		{name: "different alliance", allianceID: 99000001, corporationAlliance: 99000002, fail: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := checkAlliance(test.allianceID, esi.GetCorporationsCorporationIdOk{AllianceId: test.corporationAlliance})
+			if test.fail != (err != nil) {
+				t.Errorf("got error %v, want failure %t", err, test.fail)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lunemec/eve-quartermaster/pkg/bot"
+	"github.com/lunemec/eve-quartermaster/pkg/esiclient"
+	"github.com/lunemec/eve-quartermaster/pkg/token"
+
+	"github.com/antihax/goesi"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// doctrineCmd is top level command for doctrine manipulation.
+var doctrineCmd = &cobra.Command{
+	Use:   "doctrine",
+	Short: "Doctrine manipulation functions",
+}
+
+// importFittingsCmd is command to create or update doctrines from in-game fittings.
+var importFittingsCmd = &cobra.Command{
+	Use:   "import-fittings",
+	Short: "Create or update doctrines from personal in-game fittings of the logged in character",
+	Long: "Create or update doctrines from personal in-game fittings of the logged in character.\n" +
+		"Corporation fittings are not read, save the doctrine fits to the character first.",
+	Run: importFittings,
+}
+
+func init() {
+	rootCmd.AddCommand(doctrineCmd)
+	doctrineCmd.AddCommand(importFittingsCmd)
+
+	importFittingsCmd.Flags().StringVarP(&authfile, "auth_file", "a", "auth.bin", "path to file where to save authentication data")
+	importFittingsCmd.Flags().StringVarP(&sessionKey, "session_key", "s", "", "session key, use random string")
+	importFittingsCmd.Flags().StringVar(&eveClientID, "eve_client_id", "", "EVE APP client id")
+	importFittingsCmd.Flags().StringVar(&eveSSOSecret, "eve_sso_secret", "", "EVE APP SSO secret")
+	importFittingsCmd.Flags().StringVar(&bboltRepositoryFile, "repository_file", "repository.db", "path to bbolt repository file to save doctrine data (default repository.db)")
+	importFittingsCmd.Flags().StringVar(&tenantName, "tenant", "", "name of tenant from --tenants_file to import doctrines to, required when the repository has tenants")
+
+	must(importFittingsCmd.MarkFlagRequired("session_key"))
+	must(importFittingsCmd.MarkFlagRequired("eve_client_id"))
+	must(importFittingsCmd.MarkFlagRequired("eve_sso_secret"))
+}
+
+func importFittings(cmd *cobra.Command, args []string) {
+	fastLog, err := zap.NewDevelopment()
+	if err != nil {
+		panic(fmt.Sprintf("error inicializing logger: %s", err))
+	}
+	log := fastLog.Sugar()
+
+	client := httpClient()
+	tokenSource := token.NewSource(
+		log,
+		client,
+		token.NewFileStorage(authfile),
+		[]byte(sessionKey),
+		eveClientID,
+		eveSSOSecret,
+		eveCallbackURL,
+		eveScopes,
+	)
+
+	bboltRepository, err := openRepository(bboltRepositoryFile, tenantName)
+	if err != nil {
+		panic(fmt.Sprintf("error inicializing bbolt repository file: %+v", err))
+	}
+	defer func() {
+		err := bboltRepository.Close()
+		if err != nil {
+			fmt.Printf("ERROR closing DB: %+v\n", err)
+		}
+	}()
+
+	esi := esiclient.New(log, client)
+	ctx := context.WithValue(context.Background(), goesi.ContextOAuth2, tokenSource)
+	source := bot.NewESISource(esi)
+	diff, err := bot.SyncFittings(ctx, source, source, tokenSource, bboltRepository)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, name := range diff.Added {
+		fmt.Printf("ADDED %s\n", name)
+	}
+	for _, name := range diff.Changed {
+		fmt.Printf("CHANGED %s\n", name)
+	}
+	for _, name := range diff.Removed {
+		fmt.Printf("REMOVED IN-GAME %s\n", name)
+	}
+	fmt.Printf("Added %d, changed %d, removed in-game %d doctrines.\n", len(diff.Added), len(diff.Changed), len(diff.Removed))
+}
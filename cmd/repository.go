@@ -2,10 +2,13 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/lunemec/eve-quartermaster/pkg/repository"
 
 	"github.com/k0kubun/pp/v3"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
@@ -42,9 +45,25 @@ var readPriceHistoryCmd = &cobra.Command{
 	Run:   readPriceHistory,
 }
 
+// readContractsCmd is command to print contract ledger.
+var readContractsCmd = &cobra.Command{
+	Use:   "contracts",
+	Short: "Print contract ledger from repository",
+	Run:   readContracts,
+}
+
+// readEventsCmd is command to print contract events.
+var readEventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Print contract events from repository",
+	Run:   readEvents,
+}
+
 var (
 	jsonRepositoryFile  string
 	bboltRepositoryFile string
+
+	tenantName string
 )
 
 func init() {
@@ -53,11 +72,45 @@ func init() {
 	repositoryCmd.AddCommand(readCmd)
 	readCmd.AddCommand(readDoctrinesCmd)
 	readCmd.AddCommand(readPriceHistoryCmd)
+	readCmd.AddCommand(readContractsCmd)
+	readCmd.AddCommand(readEventsCmd)
 
 	migrateCmd.Flags().StringVar(&jsonRepositoryFile, "json_repository_file", "repository.json", "path to JSON repository json to save doctrine data (default repository.json)")
 	migrateCmd.Flags().StringVar(&bboltRepositoryFile, "bbolt_repository_file", "repository.db", "path to bbolt repository json to save doctrine data (default repository.db)")
+	migrateCmd.Flags().StringVar(&tenantName, "tenant", "", "name of tenant from --tenants_file to migrate doctrines to, required when the repository has tenants")
+
+	readCmd.PersistentFlags().StringVar(&bboltRepositoryFile, "repository_file", "repository.db", "path to bbolt repository json to save doctrine data (default repository.db)")
+	readCmd.PersistentFlags().StringVar(&tenantName, "tenant", "", "name of tenant from --tenants_file to read, required when the repository has tenants")
+}
 
-	readCmd.Flags().StringVar(&bboltRepositoryFile, "repository_file", "repository.db", "path to bbolt repository json to save doctrine data (default repository.db)")
+// openRepository opens bbolt repository of the tenant, or top level buckets
+// without tenant. Repository with tenants can't be used without tenant, the
+// bot run with --tenants_file does not read the top level buckets.
+func openRepository(file, tenant string) (repository.BBoltRepository, error) {
+	bboltRepository, err := repository.NewBBoltRepository(file)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := bboltRepository.Namespaces()
+	if err != nil {
+		bboltRepository.Close()
+		return nil, err
+	}
+	if tenant == "" {
+		if len(namespaces) != 0 {
+			bboltRepository.Close()
+			return nil, errors.Errorf("repository has tenants: %s, choose one with --tenant", strings.Join(namespaces, ", "))
+		}
+		return bboltRepository, nil
+	}
+	for _, namespace := range namespaces {
+		if namespace == tenant {
+			// Close of the namespace closes the whole database.
+			return bboltRepository.Namespace(tenant)
+		}
+	}
+	bboltRepository.Close()
+	return nil, errors.Errorf("tenant %s is not in the repository, run the bot with --tenants_file first", tenant)
 }
 
 func migrateRepository(cmd *cobra.Command, args []string) {
@@ -65,7 +118,7 @@ func migrateRepository(cmd *cobra.Command, args []string) {
 	if err != nil {
 		panic(fmt.Sprintf("error inicializing json repository file: %+v", err))
 	}
-	bboltRepository, err := repository.NewBBoltRepository(bboltRepositoryFile)
+	bboltRepository, err := openRepository(bboltRepositoryFile, tenantName)
 	if err != nil {
 		panic(fmt.Sprintf("error inicializing bbolt repository file: %+v", err))
 	}
@@ -80,7 +133,12 @@ func migrateRepository(cmd *cobra.Command, args []string) {
 	if err != nil {
 		panic(err)
 	}
-	err = bboltRepository.WriteAll(doctrines)
+	existing, err := bboltRepository.ReadAll()
+	if err != nil {
+		panic(err)
+	}
+	merged := repository.MergeRequirements(existing, doctrines)
+	err = bboltRepository.WriteAll(merged)
 	if err != nil {
 		panic(err)
 	}
@@ -89,11 +147,11 @@ func migrateRepository(cmd *cobra.Command, args []string) {
 		panic(err)
 	}
 	fmt.Printf("WROTE %d doctrines.\n", len(bboltDoctrines))
-	fmt.Printf("Repositories equal? %t\n", len(doctrines) == len(bboltDoctrines))
+	fmt.Printf("Repositories equal? %t\n", len(merged) == len(bboltDoctrines))
 }
 
 func readDoctrines(cmd *cobra.Command, args []string) {
-	bboltRepository, err := repository.NewBBoltRepository(bboltRepositoryFile)
+	bboltRepository, err := openRepository(bboltRepositoryFile, tenantName)
 	if err != nil {
 		panic(fmt.Sprintf("error inicializing bbolt repository file: %+v", err))
 	}
@@ -115,7 +173,7 @@ func readDoctrines(cmd *cobra.Command, args []string) {
 }
 
 func readPriceHistory(cmd *cobra.Command, args []string) {
-	bboltRepository, err := repository.NewBBoltRepository(bboltRepositoryFile)
+	bboltRepository, err := openRepository(bboltRepositoryFile, tenantName)
 	if err != nil {
 		panic(fmt.Sprintf("error inicializing bbolt repository file: %+v", err))
 	}
@@ -135,3 +193,47 @@ func readPriceHistory(cmd *cobra.Command, args []string) {
 		pp.Println(doctrine)
 	}
 }
+
+func readContracts(cmd *cobra.Command, args []string) {
+	bboltRepository, err := openRepository(bboltRepositoryFile, tenantName)
+	if err != nil {
+		panic(fmt.Sprintf("error inicializing bbolt repository file: %+v", err))
+	}
+	defer func() {
+		err := bboltRepository.Close()
+		if err != nil {
+			fmt.Printf("ERROR closing DB: %+v\n", err)
+		}
+	}()
+
+	contracts, err := bboltRepository.ReadAllContracts()
+	if err != nil {
+		panic(err)
+	}
+
+	for _, contract := range contracts {
+		pp.Println(contract)
+	}
+}
+
+func readEvents(cmd *cobra.Command, args []string) {
+	bboltRepository, err := openRepository(bboltRepositoryFile, tenantName)
+	if err != nil {
+		panic(fmt.Sprintf("error inicializing bbolt repository file: %+v", err))
+	}
+	defer func() {
+		err := bboltRepository.Close()
+		if err != nil {
+			fmt.Printf("ERROR closing DB: %+v\n", err)
+		}
+	}()
+
+	events, err := bboltRepository.SeekEvents(time.Time{}, time.Now())
+	if err != nil {
+		panic(err)
+	}
+
+	for _, event := range events {
+		pp.Println(event)
+	}
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+)
+
+func TestOpenRepository(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "repository.db")
+
+	// Repository without tenants is opened as it is.
+	repo, err := openRepository(file, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	_, err = repo.CopyToNamespace("corp-a")
+	if err != nil {
+		t.Fatalf("unable to create tenant: %+v", err)
+	}
+	tenant, err := repo.Namespace("corp-a")
+	if err != nil {
+		t.Fatalf("unable to open tenant: %+v", err)
+	}
+	err = tenant.Set("Svipul", repository.Doctrine{Name: "Svipul", RequireStock: 1, ContractedOn: repository.Corporation})
+	if err != nil {
+		t.Fatalf("unable to set doctrine: %+v", err)
+	}
+	repo.Close()
+
+	_, err = openRepository(file, "")
+	if err == nil {
+		t.Fatalf("expected error without --tenant when the repository has tenants")
+	}
+	_, err = openRepository(file, "corp-b")
+	if err == nil {
+		t.Fatalf("expected error for unknown tenant")
+	}
+
+	repo, err = openRepository(file, "corp-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer repo.Close()
+	doctrines, err := repo.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(doctrines) != 1 || doctrines[0].Name != "Svipul" {
+		t.Errorf("got doctrines %+v, want Svipul of the tenant", doctrines)
+	}
+}
@@ -34,10 +34,18 @@ var (
 var eveScopes = []string{
 	"publicData",
 	"esi-contracts.read_corporation_contracts.v1",
+	"esi-fittings.read_fittings.v1",
+	"esi-universe.read_structures.v1",
+	"esi-corporations.read_corporation_membership.v1",
+	"esi-characters.read_corporation_roles.v1",
 }
 
 func httpClient() *http.Client {
-	transport := httpcache.NewTransport(httpcache.NewMemoryCache())
+	return httpClientWithCache(httpcache.NewMemoryCache())
+}
+
+func httpClientWithCache(cache httpcache.Cache) *http.Client {
+	transport := httpcache.NewTransport(cache)
 	transport.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
 	client := http.Client{
 		Timeout:   10 * time.Second,
@@ -4,15 +4,20 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/lunemec/eve-quartermaster/pkg/bot"
+	"github.com/lunemec/eve-quartermaster/pkg/diskcache"
+	"github.com/lunemec/eve-quartermaster/pkg/esiclient"
 	"github.com/lunemec/eve-quartermaster/pkg/repository"
 	"github.com/lunemec/eve-quartermaster/pkg/token"
 	"github.com/pkg/errors"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/gregjones/httpcache"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -35,6 +40,34 @@ var (
 	discordAuthToken string
 
 	repositoryFile string
+
+	matchStrategy  string
+	matchThreshold float64
+	matchPattern   string
+
+	stagingLocationIDs []int64
+	suppressInTransit  bool
+
+	issuerPolicy    string
+	issuerAllowList []int32
+
+	eventChannelID string
+
+	pageConcurrency int
+
+	httpCacheType string
+	httpCacheDir  string
+	httpCacheSize int64
+
+	esiURL string
+
+	tenantsFile string
+
+	allianceMemberList []string
+
+	commandRoleList []string
+
+	channelList []string
 )
 
 func init() {
@@ -50,11 +83,28 @@ func init() {
 	runCmd.Flags().DurationVar(&checkInterval, "check_interval", 30*time.Minute, "how often to check EVE ESI API (default 30min)")
 	runCmd.Flags().DurationVar(&notifyInterval, "notify_interval", 24*time.Hour, "how often to spam Discord (default 24H)")
 	runCmd.Flags().StringVar(&repositoryFile, "repository_file", "repository.db", "path to bbolt repository file to save doctrine data (default repository.db)")
+	runCmd.Flags().StringVar(&matchStrategy, "match_strategy", bot.MatchDefault, "how to match contract titles to doctrines: default, exact, tokens, fuzzy or regex")
+	runCmd.Flags().Float64Var(&matchThreshold, "match_threshold", 0.8, "similarity threshold for fuzzy match strategy")
+	runCmd.Flags().Int64SliceVar(&stagingLocationIDs, "staging_location_id", nil, "station or structure IDs where contracts count toward stock, all locations if empty")
+	runCmd.Flags().StringVar(&matchPattern, "match_pattern", "", "pattern for regex match strategy, {doctrine} is replaced by doctrine name")
+	runCmd.Flags().BoolVar(&suppressInTransit, "suppress_in_transit", false, "do not notify about low stock when ships in courier contracts to staging cover the requirement")
+	runCmd.Flags().StringVar(&issuerPolicy, "issuer_policy", bot.IssuerEveryone, "whose contracts count toward stock: everyone, members (corporation members, --issuer_allow_id and contracts issued by corporations of alliance members) or allowlist (only --issuer_allow_id)")
+	runCmd.Flags().Int32SliceVar(&issuerAllowList, "issuer_allow_id", nil, "character IDs whose contracts count toward stock with members or allowlist issuer policy")
+	runCmd.Flags().StringVar(&eventChannelID, "event_channel_id", "", "ID of discord channel to post doctrine contract events to (created, accepted, expired, deleted, price changed), disabled if empty")
+
+	runCmd.Flags().IntVar(&pageConcurrency, "page_concurrency", 4, "how many pages of contracts to fetch from ESI at once")
+	runCmd.Flags().StringVar(&httpCacheType, "http_cache", "memory", "where to cache ESI responses: memory, bbolt (in the repository file) or dir (in --http_cache_dir)")
+	runCmd.Flags().StringVar(&httpCacheDir, "http_cache_dir", "http_cache", "directory for dir HTTP cache")
+	runCmd.Flags().Int64Var(&httpCacheSize, "http_cache_size", 100, "maximum size of bbolt or dir HTTP cache in MB, oldest responses are evicted, 0 for no limit")
+	runCmd.Flags().StringVar(&esiURL, "esi_url", "", "base URL of ESI API, to run against fake ESI server (pkg/esifake) offline without EVE SSO login, real ESI if empty")
+	runCmd.Flags().StringSliceVar(&allianceMemberList, "alliance_member", nil, "other alliance corporation whose alliance contracts count toward alliance doctrines, as corporation_id=auth_file (log in as its character with quartermaster login --auth_file), tenants of --tenants_file in the same alliance are members of each other")
+	runCmd.Flags().StringArrayVar(&commandRoleList, "command_role", nil, "Discord role allowed to run command changing the doctrines, as command=role_id, repeat for more roles, commands: require, parse excel, price, migrate, doctrine, alias, location, perm, channel, commands without roles can be run by everyone (perm by server administrators), !perm set replaces them")
+	runCmd.Flags().StringArrayVar(&channelList, "channel", nil, "Discord channel for messages of the type, as type=channel_id or type/doctrine_group=channel_id, repeat for more, types: low_stock (default --discord_channel_id), problematic, events (default --event_channel_id), leaderboard, errors (default where the command was typed), !channel set replaces them")
+	runCmd.Flags().StringVar(&tenantsFile, "tenants_file", "", "path to YAML file with tenants (corporations) to serve from one bot, replaces --corporation_id, --alliance_id, --auth_file, --discord_channel_id and --event_channel_id, contract flags (--staging_location_id, --suppress_in_transit, --issuer_policy, --issuer_allow_id, --match_*) are defaults for tenants, the first tenant gets a copy of doctrines and other data saved without tenants")
 
 	must(runCmd.MarkFlagRequired("session_key"))
 	must(runCmd.MarkFlagRequired("eve_client_id"))
 	must(runCmd.MarkFlagRequired("eve_sso_secret"))
-	must(runCmd.MarkFlagRequired("discord_channel_id"))
 	must(runCmd.MarkFlagRequired("discord_auth_token"))
 }
 
@@ -69,53 +119,175 @@ func runBot(cmd *cobra.Command, args []string) {
 	}
 	log := fastLog.Sugar()
 
-	client := httpClient()
+	repository, err := repository.NewBBoltRepository(repositoryFile)
+	if err != nil {
+		panic(fmt.Sprintf("error inicializing repository file: %+v", err))
+	}
+	defer func() {
+		err := repository.Close()
+		if err != nil {
+			fmt.Printf("ERROR closing DB: %+v\n", err)
+		}
+	}()
 
-	tokenStorage := token.NewFileStorage(authfile)
-	tokenSource := token.NewSource(
-		log,
-		client,
-		tokenStorage,
-		[]byte(sessionKey),
-		eveClientID,
-		eveSSOSecret,
-		eveCallbackURL,
-		eveScopes,
-	)
+	cache, err := newHTTPCache(log, repository)
+	if err != nil {
+		panic(fmt.Sprintf("error inicializing HTTP cache: %+v", err))
+	}
+	client := httpClientWithCache(cache)
+
+	flagRoles, err := parseCommandRoles(commandRoleList)
+	if err != nil {
+		panic(fmt.Sprintf("error parsing command roles: %+v", err))
+	}
+	flagChannels, err := parseChannels(channelList)
+	if err != nil {
+		panic(fmt.Sprintf("error parsing channels: %+v", err))
+	}
+	tenants := []tenant{flagTenant(flagRoles, flagChannels)}
+	if tenantsFile != "" {
+		if len(flagRoles) != 0 {
+			panic("--command_role can't be used with --tenants_file, set command_roles of each tenant")
+		}
+		if len(flagChannels) != 0 {
+			panic("--channel can't be used with --tenants_file, set channels of each tenant")
+		}
+		tenants, err = loadTenants(tenantsFile)
+		if err != nil {
+			panic(fmt.Sprintf("error loading tenants: %+v", err))
+		}
+	} else if discordChannelID == "" {
+		panic("--discord_channel_id is required without --tenants_file")
+	}
 
 	discord, err := discordgo.New("Bot " + discordAuthToken)
 	if err != nil {
 		panic(fmt.Sprintf("error inicializing discord client: %+v", err))
 	}
 
-	repository, err := repository.NewBBoltRepository(repositoryFile)
+	// All ESI calls are retried and respect ESI error limit.
+	esi := esiclient.New(log, client)
+	// ESI can be replaced by fake server, see pkg/esifake.
+	if esiURL != "" {
+		esi.ESI.ChangeBasePath(esiURL)
+	}
+	source := bot.NewESISource(esi)
+
+	newTokenSource := func(authFile string) token.Source {
+		// Fake ESI server does not check tokens, do not refresh them
+		// against EVE SSO so the bot runs offline.
+		if esiURL != "" {
+			return token.NewStaticSource("esifake", authFile)
+		}
+		return token.NewSource(
+			log,
+			client,
+			token.NewFileStorage(authFile),
+			[]byte(sessionKey),
+			eveClientID,
+			eveSSOSecret,
+			eveCallbackURL,
+			eveScopes,
+		)
+	}
+	var tokenSources []token.Source
+	for _, tenant := range tenants {
+		tokenSources = append(tokenSources, newTokenSource(tenant.AuthFile))
+	}
+	flagMembers, err := parseAllianceMembers(allianceMemberList)
 	if err != nil {
-		panic(fmt.Sprintf("error inicializing repository file: %+v", err))
+		panic(fmt.Sprintf("error parsing alliance members: %+v", err))
 	}
-	defer func() {
-		err := repository.Close()
+	if tenantsFile != "" && len(flagMembers) != 0 {
+		panic("--alliance_member can't be used with --tenants_file, tenants in the same alliance supply each other")
+	}
+
+	// Bot run without tenants stored data in top level buckets, the first
+	// tenant gets a copy of them when the tenants file is used first time.
+	if tenantsFile != "" {
+		copied, err := repository.CopyToNamespace(tenants[0].Name)
 		if err != nil {
-			fmt.Printf("ERROR closing DB: %+v\n", err)
+			panic(fmt.Sprintf("error copying repository to tenant %s: %+v", tenants[0].Name, err))
 		}
-	}()
+		if copied {
+			log.Infow("copied doctrines and other data to the first tenant", "tenant", tenants[0].Name)
+		}
+	}
 
-	bot := bot.NewQuartermasterBot(
-		log,
-		client,
-		tokenSource,
-		discord,
-		discordChannelID,
-		corporationID,
-		allianceID,
-		repository,
-		checkInterval,
-		notifyInterval,
-	)
-
-	go func() {
-		err := bot.Bot()
-		errChan <- err
-	}()
+	for i, tenant := range tenants {
+		tokenSource := tokenSources[i]
+
+		// Tenants in the same alliance supply alliance doctrines of each other.
+		var allianceMembers []bot.AllianceMember
+		for corporationID, authFile := range flagMembers {
+			allianceMembers = append(allianceMembers, bot.AllianceMember{
+				CorporationID: corporationID,
+				TokenSource:   newTokenSource(authFile),
+			})
+		}
+		for j, other := range tenants {
+			if j == i || other.AllianceID == 0 || other.AllianceID != tenant.AllianceID {
+				continue
+			}
+			allianceMembers = append(allianceMembers, bot.AllianceMember{
+				CorporationID: other.CorporationID,
+				TokenSource:   tokenSources[j],
+			})
+		}
+
+		tenantRepository := repository
+		if tenant.Name != "" {
+			tenantRepository, err = repository.Namespace(tenant.Name)
+			if err != nil {
+				panic(fmt.Sprintf("error inicializing repository of tenant %s: %+v", tenant.Name, err))
+			}
+		}
+
+		tenantLog := log
+		if tenant.Name != "" {
+			tenantLog = log.With("tenant", tenant.Name)
+		}
+		channels, err := tenant.channels()
+		if err != nil {
+			panic(fmt.Sprintf("error parsing channels: %+v", err))
+		}
+		matcher, err := tenant.matcher()
+		if err != nil {
+			panic(fmt.Sprintf("error inicializing contract matcher: %+v", err))
+		}
+		issuers, err := tenant.issuers()
+		if err != nil {
+			panic(fmt.Sprintf("error inicializing issuer policy: %+v", err))
+		}
+		bot := bot.NewQuartermasterBot(
+			tenantLog,
+			esi,
+			source,
+			source,
+			tokenSource,
+			discord,
+			tenant.DiscordChannelID,
+			tenant.route(),
+			tenant.CommandRoles,
+			tenant.CorporationID,
+			tenant.AllianceID,
+			allianceMembers,
+			tenantRepository,
+			matcher,
+			tenant.StagingLocationIDs,
+			issuers,
+			*tenant.SuppressInTransit,
+			channels,
+			pageConcurrency,
+			checkInterval,
+			notifyInterval,
+		)
+
+		go func() {
+			err := bot.Bot()
+			errChan <- err
+		}()
+	}
 
 	select {
 	case <-signalChan:
@@ -125,14 +297,80 @@ func runBot(cmd *cobra.Command, args []string) {
 			panic(errors.Wrap(err, "ERROR closing DB"))
 		}
 		// This forces us to refresh token + save to file.
-		_, err = tokenSource.Token()
-		if err != nil {
-			panic(errors.Wrap(err, "error refreshing and saving token"))
+		for _, tokenSource := range tokenSources {
+			_, err = tokenSource.Token()
+			if err != nil {
+				panic(errors.Wrap(err, "error refreshing and saving token"))
+			}
 		}
-	case <-errChan:
+	case err := <-errChan:
 		// systemd handles reload, so we can panic on error.
 		if err != nil {
 			panic(err)
 		}
 	}
 }
+
+// parseAllianceMembers parses --alliance_member values to corporation ID -> auth file.
+func parseAllianceMembers(values []string) (map[int32]string, error) {
+	var out = make(map[int32]string)
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.Errorf("invalid alliance member: %s, use corporation_id=auth_file", value)
+		}
+		corporationID, err := strconv.ParseInt(parts[0], 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid alliance member corporation ID: %s", parts[0])
+		}
+		out[int32(corporationID)] = parts[1]
+	}
+	return out, nil
+}
+
+// parseCommandRoles parses --command_role values to command -> role IDs.
+func parseCommandRoles(values []string) (map[string][]string, error) {
+	var out = make(map[string][]string)
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.Errorf("invalid command role: %s, use command=role_id", value)
+		}
+		if !bot.IsRestrictedCommand(parts[0]) {
+			return nil, errors.Errorf("command %s can't be limited to roles", parts[0])
+		}
+		out[parts[0]] = append(out[parts[0]], parts[1])
+	}
+	return out, nil
+}
+
+// parseChannels parses --channel values to message route -> channel ID.
+func parseChannels(values []string) (map[string]string, error) {
+	var out = make(map[string]string)
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.Errorf("invalid channel: %s, use type=channel_id or type/doctrine_group=channel_id", value)
+		}
+		_, err := bot.ParseChannelRoute(parts[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid channel: %s", value)
+		}
+		out[parts[0]] = parts[1]
+	}
+	return out, nil
+}
+
+// newHTTPCache returns HTTP cache selected by --http_cache.
+func newHTTPCache(log *zap.SugaredLogger, repository repository.BBoltRepository) (httpcache.Cache, error) {
+	maxBytes := httpCacheSize * 1024 * 1024
+	switch httpCacheType {
+	case "memory":
+		return httpcache.NewMemoryCache(), nil
+	case "bbolt":
+		return repository.HTTPCache(log, maxBytes)
+	case "dir":
+		return diskcache.New(log, httpCacheDir, maxBytes)
+	}
+	return nil, errors.Errorf("unknown HTTP cache: %s, use one of: memory, bbolt, dir", httpCacheType)
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"github.com/lunemec/eve-quartermaster/pkg/bot"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// tenant is one corporation served by the bot, each has its own EVE login,
+// Discord channel and repository namespace.
+type tenant struct {
+	Name             string `mapstructure:"name"`
+	CorporationID    int32  `mapstructure:"corporation_id"`
+	AllianceID       int32  `mapstructure:"alliance_id"`
+	AuthFile         string `mapstructure:"auth_file"`
+	DiscordGuildID   string `mapstructure:"discord_guild_id"`
+	DiscordChannelID string `mapstructure:"discord_channel_id"`
+	EventChannelID   string `mapstructure:"event_channel_id"`
+
+	// Command -> Discord role IDs allowed to run it.
+	CommandRoles map[string][]string `mapstructure:"command_roles"`
+	// Message type or type/doctrine group -> Discord channel ID.
+	Channels map[string]string `mapstructure:"channels"`
+
+	// Contract settings, run flags are used for those not set in tenants file.
+	StagingLocationIDs []int64 `mapstructure:"staging_location_ids"`
+	SuppressInTransit  *bool   `mapstructure:"suppress_in_transit"`
+	IssuerPolicy       string  `mapstructure:"issuer_policy"`
+	IssuerAllowIDs     []int32 `mapstructure:"issuer_allow_ids"`
+	MatchStrategy      string  `mapstructure:"match_strategy"`
+	MatchThreshold     float64 `mapstructure:"match_threshold"`
+	MatchPattern       string  `mapstructure:"match_pattern"`
+}
+
+// route returns which Discord messages are for the tenant, by guild if
+// set, otherwise by channel. Single tenant from flags responds to all.
+func (t tenant) route() bot.MessageRoute {
+	if t.Name == "" {
+		return bot.MessageRoute{}
+	}
+	if t.DiscordGuildID != "" {
+		return bot.MessageRoute{GuildID: t.DiscordGuildID}
+	}
+	return bot.MessageRoute{ChannelID: t.DiscordChannelID}
+}
+
+// channels returns where the tenant messages are sent, event_channel_id is
+// the events channel unless it is in channels.
+func (t tenant) channels() (map[bot.ChannelRoute]string, error) {
+	var out = make(map[bot.ChannelRoute]string)
+	if t.EventChannelID != "" {
+		out[bot.ChannelRoute{MessageType: bot.EventMessages}] = t.EventChannelID
+	}
+	for key, channelID := range t.Channels {
+		route, err := bot.ParseChannelRoute(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid channel: %s", key)
+		}
+		out[route] = channelID
+	}
+	return out, nil
+}
+
+// matcher returns contract title matcher of the tenant.
+func (t tenant) matcher() (bot.Matcher, error) {
+	return bot.NewMatcher(t.MatchStrategy, t.MatchThreshold, t.MatchPattern)
+}
+
+// issuers returns whose contracts count toward stock of the tenant.
+func (t tenant) issuers() (bot.IssuerPolicy, error) {
+	return bot.NewIssuerPolicy(t.IssuerPolicy, t.IssuerAllowIDs)
+}
+
+// withFlagDefaults returns the tenant with contract settings not set in
+// tenants file taken from run flags.
+func (t tenant) withFlagDefaults() tenant {
+	if t.StagingLocationIDs == nil {
+		t.StagingLocationIDs = stagingLocationIDs
+	}
+	if t.SuppressInTransit == nil {
+		suppress := suppressInTransit
+		t.SuppressInTransit = &suppress
+	}
+	if t.IssuerPolicy == "" {
+		t.IssuerPolicy = issuerPolicy
+	}
+	if t.IssuerAllowIDs == nil {
+		t.IssuerAllowIDs = issuerAllowList
+	}
+	if t.MatchStrategy == "" {
+		t.MatchStrategy = matchStrategy
+	}
+	if t.MatchThreshold == 0 {
+		t.MatchThreshold = matchThreshold
+	}
+	if t.MatchPattern == "" {
+		t.MatchPattern = matchPattern
+	}
+	return t
+}
+
+// flagTenant returns the single tenant configured by run flags.
+func flagTenant(commandRoles map[string][]string, channels map[string]string) tenant {
+	return tenant{
+		CorporationID:    corporationID,
+		AllianceID:       allianceID,
+		AuthFile:         authfile,
+		DiscordChannelID: discordChannelID,
+		EventChannelID:   eventChannelID,
+		CommandRoles:     commandRoles,
+		Channels:         channels,
+	}.withFlagDefaults()
+}
+
+// loadTenants reads tenants from YAML (or JSON, TOML) file like:
+//
+//	tenants:
+//	  - name: corp-a
+//	    corporation_id: 98000001
+//	    alliance_id: 99000001
+//	    auth_file: corp-a.bin
+//	    discord_guild_id: "123456789012345678"
+//	    discord_channel_id: "123456789012345679"
+//	    command_roles:
+//	      require: ["123456789012345680"]
+//	    channels:
+//	      low_stock/capitals: "123456789012345681"
+//	    staging_location_ids: [60003760]
+//	    suppress_in_transit: true
+//	    issuer_policy: allowlist
+//	    issuer_allow_ids: [2112345678]
+//	    match_strategy: exact
+//
+// Contract settings (staging_location_ids, suppress_in_transit,
+// issuer_policy, issuer_allow_ids, match_strategy, match_threshold and
+// match_pattern) not set in the file are taken from run flags.
+func loadTenants(file string) ([]tenant, error) {
+	config := viper.New()
+	config.SetConfigFile(file)
+	err := config.ReadInConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read tenants file: %s", file)
+	}
+	var tenants []tenant
+	err = config.UnmarshalKey("tenants", &tenants)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse tenants file: %s", file)
+	}
+	if len(tenants) == 0 {
+		return nil, errors.Errorf("no tenants in tenants file: %s", file)
+	}
+
+	var (
+		names  = make(map[string]struct{})
+		routes = make(map[bot.MessageRoute]string)
+	)
+	for i := range tenants {
+		tenants[i] = tenants[i].withFlagDefaults()
+	}
+	for _, tenant := range tenants {
+		switch {
+		case tenant.Name == "":
+			return nil, errors.New("tenant name can't be empty")
+		case tenant.CorporationID == 0:
+			return nil, errors.Errorf("tenant %s has no corporation_id", tenant.Name)
+		case tenant.AuthFile == "":
+			return nil, errors.Errorf("tenant %s has no auth_file", tenant.Name)
+		case tenant.DiscordChannelID == "":
+			return nil, errors.Errorf("tenant %s has no discord_channel_id", tenant.Name)
+		}
+		for command := range tenant.CommandRoles {
+			if !bot.IsRestrictedCommand(command) {
+				return nil, errors.Errorf("tenant %s command %s can't be limited to roles", tenant.Name, command)
+			}
+		}
+		if _, err := tenant.channels(); err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", tenant.Name)
+		}
+		if _, err := tenant.matcher(); err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", tenant.Name)
+		}
+		if _, err := tenant.issuers(); err != nil {
+			return nil, errors.Wrapf(err, "tenant %s", tenant.Name)
+		}
+		if _, ok := names[tenant.Name]; ok {
+			return nil, errors.Errorf("tenant %s is in tenants file twice", tenant.Name)
+		}
+		names[tenant.Name] = struct{}{}
+		if other, ok := routes[tenant.route()]; ok {
+			return nil, errors.Errorf("tenants %s and %s share Discord guild or channel, commands can't be routed", other, tenant.Name)
+		}
+		routes[tenant.route()] = tenant.Name
+	}
+	return tenants, nil
+}
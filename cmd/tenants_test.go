@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadTenantsContractSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tenants.yaml")
+	err := os.WriteFile(file, []byte(`tenants:
+  - name: corp-a
+    corporation_id: 98000001
+    auth_file: corp-a.bin
+    discord_channel_id: "1"
+    staging_location_ids: [60003760]
+    suppress_in_transit: false
+    issuer_policy: allowlist
+    issuer_allow_ids: [2112345678]
+    match_strategy: exact
+  - name: corp-b
+    corporation_id: 98000002
+    auth_file: corp-b.bin
+    discord_channel_id: "2"
+`), 0600)
+	if err != nil {
+		t.Fatalf("unable to write tenants file: %s", err)
+	}
+	stagingLocationIDs = []int64{1022734985679}
+	suppressInTransit = true
+	defer func() {
+		stagingLocationIDs = nil
+		suppressInTransit = false
+	}()
+
+	tenants, err := loadTenants(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(tenants) != 2 {
+		t.Fatalf("got %d tenants, want 2", len(tenants))
+	}
+
+	// Set in tenants file.
+	corpA := tenants[0]
+	if !reflect.DeepEqual(corpA.StagingLocationIDs, []int64{60003760}) {
+		t.Errorf("corp-a: got staging locations %v, want [60003760]", corpA.StagingLocationIDs)
+	}
+	if *corpA.SuppressInTransit {
+		t.Errorf("corp-a: got suppress_in_transit true, want false")
+	}
+	if corpA.IssuerPolicy != "allowlist" || !reflect.DeepEqual(corpA.IssuerAllowIDs, []int32{2112345678}) {
+		t.Errorf("corp-a: got issuer policy %s %v, want allowlist [2112345678]", corpA.IssuerPolicy, corpA.IssuerAllowIDs)
+	}
+	if corpA.MatchStrategy != "exact" {
+		t.Errorf("corp-a: got match strategy %s, want exact", corpA.MatchStrategy)
+	}
+
+	// Taken from run flags.
+	corpB := tenants[1]
+	if !reflect.DeepEqual(corpB.StagingLocationIDs, stagingLocationIDs) {
+		t.Errorf("corp-b: got staging locations %v, want %v", corpB.StagingLocationIDs, stagingLocationIDs)
+	}
+	if !*corpB.SuppressInTransit {
+		t.Errorf("corp-b: got suppress_in_transit false, want true")
+	}
+	if corpB.IssuerPolicy != issuerPolicy || corpB.MatchStrategy != matchStrategy || corpB.MatchThreshold != matchThreshold {
+		t.Errorf("corp-b: got %s, %s, %f, want run flag defaults", corpB.IssuerPolicy, corpB.MatchStrategy, corpB.MatchThreshold)
+	}
+}
+
+func TestLoadTenantsInvalidContractSettings(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tenants.yaml")
+	err := os.WriteFile(file, []byte(`tenants:
+  - name: corp-a
+    corporation_id: 98000001
+    auth_file: corp-a.bin
+    discord_channel_id: "1"
+    issuer_policy: nobody
+`), 0600)
+	if err != nil {
+		t.Fatalf("unable to write tenants file: %s", err)
+	}
+	_, err = loadTenants(file)
+	if err == nil {
+		t.Fatalf("expected error for invalid issuer policy")
+	}
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+// aliasHandler will be called every time a new
+// message is created on any channel that the autenticated bot has access to.
+func (b *quartermasterBot) aliasHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	var (
+		add    = strings.HasPrefix(m.Content, "!alias add ")
+		remove = strings.HasPrefix(m.Content, "!alias remove ")
+	)
+	if !add && !remove {
+		return
+	}
+	b.log.Infow("Responding to !alias command", "channel_id", m.ChannelID, "msg", m.Content)
+
+	// Format is: "!alias add|remove Doctrine name = Contract title", example: "!alias add Shield Heron = Heron (shield)"
+	commandContent := strings.TrimPrefix(strings.TrimPrefix(m.Content, "!alias add "), "!alias remove ")
+	doctrineName, title, ok := splitAssignment(commandContent)
+	if !ok {
+		msg := fmt.Sprintf("unrecognised !alias `%s`, the format is `!alias add|remove Some doctrine = Contract title`", commandContent)
+		_, err := b.discord.ChannelMessageSend(m.ChannelID, msg)
+		if err != nil {
+			b.log.Errorw("error responding to unknown !alias", "error", err)
+		}
+		return
+	}
+
+	doctrine, err := b.repository.Get(doctrineName)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			err = errors.Errorf("doctrine `%s` not found", doctrineName)
+		}
+		b.log.Errorw("error loading doctrine data", "error", err, "doctrine_name", doctrineName)
+
+		b.sendError(err, m.ChannelID)
+		return
+	}
+
+	var aliases []string
+	for _, alias := range doctrine.Aliases {
+		if !strings.EqualFold(alias, title) {
+			aliases = append(aliases, alias)
+		}
+	}
+	if add {
+		aliases = append(aliases, title)
+	}
+	doctrine.Aliases = aliases
+
+	err = b.repository.Set(doctrineName, doctrine)
+	if err != nil {
+		b.log.Errorw("error saving doctrine data", "error", err, "doctrine_name", doctrineName)
+
+		b.sendError(err, m.ChannelID)
+		return
+	}
+
+	err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+	if err != nil {
+		b.log.Errorw("error reacting with :+1:", "error", err)
+		return
+	}
+}
@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+	"github.com/lunemec/eve-quartermaster/pkg/token"
+
+	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
+)
+
+// AllianceMember is another alliance corporation whose contracts assigned to
+// the alliance count toward alliance doctrines, read with its own EVE login.
+type AllianceMember struct {
+	CorporationID int32
+	TokenSource   token.Source
+}
+
+// memberContext returns context authenticated as the alliance member.
+func (b *quartermasterBot) memberContext(member AllianceMember) context.Context {
+	return context.WithValue(b.ctx, goesi.ContextOAuth2, member.TokenSource)
+}
+
+// allianceMember returns alliance member of the corporation, false for our
+// corporation or corporations without EVE login.
+func (b *quartermasterBot) allianceMember(corporationID int32) (AllianceMember, bool) {
+	if corporationID == b.corporationID {
+		return AllianceMember{}, false
+	}
+	for _, member := range b.allianceMembers {
+		if member.CorporationID == corporationID {
+			return member, true
+		}
+	}
+	return AllianceMember{}, false
+}
+
+// fetchAllianceContracts returns contracts assigned to the alliance from
+// alliance members, all statuses so the ledger sees them accepted or deleted.
+// When a member can't be read, its contracts from the ledger are returned
+// unchanged so they are not taken for deleted.
+func (b *quartermasterBot) fetchAllianceContracts(
+	ledgerContracts []repository.Contract,
+) []esi.GetCorporationsCorporationIdContracts200Ok {
+	var out []esi.GetCorporationsCorporationIdContracts200Ok
+	if b.allianceID == 0 {
+		return out
+	}
+	for _, member := range b.allianceMembers {
+		contracts, _, err := b.fetchCorporationContracts(b.memberContext(member), member.CorporationID)
+		if err != nil {
+			b.log.Errorw("Error fetching contracts of alliance member", "error", err, "corporation_id", member.CorporationID)
+			for _, contract := range ledgerContracts {
+				if contract.AssigneeID == b.allianceID && contract.IssuerCorporationID == member.CorporationID {
+					out = append(out, contractToESI(contract))
+				}
+			}
+			continue
+		}
+		for _, contract := range contracts {
+			if contract.AssigneeId == b.allianceID {
+				out = append(out, contract)
+			}
+		}
+	}
+	return out
+}
+
+// mergeContracts appends other contracts to the contracts, contracts seen
+// by more corporations are kept once.
+func mergeContracts(
+	contracts, other []esi.GetCorporationsCorporationIdContracts200Ok,
+) []esi.GetCorporationsCorporationIdContracts200Ok {
+	var seen = make(map[int32]struct{})
+	for _, contract := range contracts {
+		seen[contract.ContractId] = struct{}{}
+	}
+	for _, contract := range other {
+		if _, ok := seen[contract.ContractId]; ok {
+			continue
+		}
+		seen[contract.ContractId] = struct{}{}
+		contracts = append(contracts, contract)
+	}
+	return contracts
+}
+
+// doctrineSuppliers returns doctrine name -> issuer corporation ID -> how
+// many of the contracts count toward the doctrine.
+func (b *quartermasterBot) doctrineSuppliers(
+	requireDoctrines []repository.Doctrine,
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok,
+) map[string]map[int32]int {
+	var (
+		out          = make(map[string]map[int32]int)
+		gotDoctrines = doctrinesAvailable(contracts)
+	)
+	assigned, _ := b.assignContracts(requireDoctrines, gotDoctrines)
+	for _, contract := range contracts {
+		doctrineName, ok := assigned[strings.TrimSpace(contract.Title)]
+		if !ok {
+			continue
+		}
+		if out[doctrineName] == nil {
+			out[doctrineName] = make(map[int32]int)
+		}
+		out[doctrineName][contract.IssuerCorporationId]++
+	}
+	return out
+}
+
+// addSuppliers fills in which corporations supplied the alliance doctrines,
+// only when contracts of alliance members are read.
+func (b *quartermasterBot) addSuppliers(reports []doctrineReport) []doctrineReport {
+	if len(b.allianceMembers) == 0 {
+		return reports
+	}
+	var ids []int64
+	for _, report := range reports {
+		for corporationID := range report.suppliers {
+			ids = append(ids, int64(corporationID))
+		}
+	}
+	names := b.resolveNames(ids)
+
+	for i, report := range reports {
+		var parts []string
+		for corporationID, count := range report.suppliers {
+			parts = append(parts, fmt.Sprintf("%s %d", names[int64(corporationID)], count))
+		}
+		sort.Strings(parts)
+		reports[i].suppliedBy = strings.Join(parts, ", ")
+	}
+	return reports
+}
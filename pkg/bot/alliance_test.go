@@ -0,0 +1,134 @@
+package bot
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esifake"
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+	"github.com/lunemec/eve-quartermaster/pkg/token"
+
+	"github.com/antihax/goesi/esi"
+)
+
+const (
+	testAllianceID               = 99000001
+	testMemberCorporationID      = 98000002
+	testOtherMemberCorporationID = 98000003
+)
+
+// newAllianceBot returns bot reading contracts of the corporation and of
+// alliance member corporations with their own tokens.
+func newAllianceBot(t *testing.T, server *esifake.Server) *quartermasterBot {
+	t.Helper()
+
+	b := newTestBot(t, server, everyone(t))
+	b.allianceID = testAllianceID
+	b.allianceMembers = []AllianceMember{
+		{
+			CorporationID: testMemberCorporationID,
+			TokenSource:   token.NewStaticSource("esifake", "Member Character"),
+		},
+		{
+			CorporationID: testOtherMemberCorporationID,
+			TokenSource:   token.NewStaticSource("esifake", "Other Member Character"),
+		},
+	}
+	return b
+}
+
+func allianceContract(id int32, issuerCorporationID int32) esi.GetCorporationsCorporationIdContracts200Ok {
+	contract := testContract(id, "Svipul")
+	contract.AssigneeId = testAllianceID
+	contract.IssuerCorporationId = issuerCorporationID
+	return contract
+}
+
+func contractIDs(contracts []esi.GetCorporationsCorporationIdContracts200Ok) []int32 {
+	var out []int32
+	for _, contract := range contracts {
+		out = append(out, contract.ContractId)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func TestFetchAllianceContractsMergesDuplicates(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+
+	// Contract 2 is assigned to the alliance and issued by the corporation,
+	// ESI returns it to both the corporation and the member token.
+	server.SetContracts(testCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{
+		testContract(1, "Svipul"),
+		allianceContract(2, testCorporationID),
+	})
+	server.SetContracts(testMemberCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{
+		allianceContract(2, testCorporationID),
+		allianceContract(3, testMemberCorporationID),
+		// Assigned to the member corporation only.
+		testContract(4, "Svipul"),
+	})
+	server.SetContracts(testOtherMemberCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{
+		// Both members see contracts assigned to the alliance.
+		allianceContract(3, testMemberCorporationID),
+		allianceContract(5, testOtherMemberCorporationID),
+	})
+
+	b := newAllianceBot(t, server)
+	contracts, _, err := b.fetchContracts()
+	if err != nil {
+		t.Fatalf("unable to fetch contracts: %+v", err)
+	}
+	allianceContracts := b.fetchAllianceContracts(nil)
+	if got, want := contractIDs(allianceContracts), []int32{2, 3, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got alliance contracts %v, want %v", got, want)
+	}
+	merged := mergeContracts(contracts, allianceContracts)
+	if got, want := contractIDs(merged), []int32{1, 2, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got merged contracts %v, want %v", got, want)
+	}
+
+	// Ledger has each contract once.
+	_, _, err = b.loadContracts()
+	if err != nil {
+		t.Fatalf("unable to load contracts: %+v", err)
+	}
+	ledger, err := b.repository.ReadAllContracts()
+	if err != nil {
+		t.Fatalf("unable to read ledger: %+v", err)
+	}
+	var ledgerIDs []int32
+	for _, contract := range ledger {
+		ledgerIDs = append(ledgerIDs, contract.ContractID)
+	}
+	sort.Slice(ledgerIDs, func(i, j int) bool { return ledgerIDs[i] < ledgerIDs[j] })
+	if want := []int32{1, 2, 3, 5}; !reflect.DeepEqual(ledgerIDs, want) {
+		t.Errorf("got ledger contracts %v, want %v", ledgerIDs, want)
+	}
+}
+
+func TestFetchAllianceContractsFailingMember(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetContracts(testMemberCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{
+		allianceContract(3, testMemberCorporationID),
+	})
+
+	b := newAllianceBot(t, server)
+	server.FailNext(fmt.Sprintf("/v1/corporations/%d/contracts/", testMemberCorporationID), http.StatusServiceUnavailable, 1)
+	ledger := []repository.Contract{
+		contractFromESI(allianceContract(3, testMemberCorporationID)),
+		contractFromESI(allianceContract(5, testOtherMemberCorporationID)),
+		// Not assigned to the alliance.
+		contractFromESI(testContract(6, "Svipul")),
+	}
+	// Contracts of the failing member come from the ledger.
+	got := contractIDs(b.fetchAllianceContracts(ledger))
+	if want := []int32{3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got alliance contracts %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/token"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// checkToken refreshes EVE token, so revoked login is found even when
+// contracts are not loaded from ESI.
+func (b *quartermasterBot) checkToken() {
+	_, err := b.tokenSource.Token()
+	b.setTokenError(err)
+}
+
+// setTokenError updates degraded state by result of EVE token refresh. The
+// errors channel is told once when the token is revoked and once when it
+// works again, other errors (network, EVE SSO outage) do not change the
+// state.
+func (b *quartermasterBot) setTokenError(err error) {
+	var message *discordgo.MessageEmbed
+
+	b.tokenLock.Lock()
+	switch {
+	case err == nil:
+		if b.tokenRevokedErr != nil {
+			b.log.Infow("EVE token works again", "degraded_since", b.tokenRevokedSince)
+			b.tokenRevokedErr = nil
+			b.tokenRevokedSince = time.Time{}
+			message = tokenRestoredMessage()
+		}
+	case token.IsRevoked(err):
+		if b.tokenRevokedErr == nil {
+			b.log.Errorw("EVE token revoked, run quartermaster login again", "error", err)
+			b.tokenRevokedErr = err
+			b.tokenRevokedSince = time.Now()
+			message = tokenRevokedMessage(err, b.tokenRevokedSince)
+		}
+	default:
+		b.log.Errorw("Error refreshing EVE token", "error", err)
+	}
+	b.tokenLock.Unlock()
+
+	if message == nil {
+		return
+	}
+	_, err = b.discord.ChannelMessageSendEmbed(b.tokenChannel(), message)
+	if err != nil {
+		b.log.Errorw("error sending EVE token message", "error", err)
+	}
+}
+
+// tokenChannel returns channel to tell about revoked and restored EVE token,
+// the errors channel if there is one.
+func (b *quartermasterBot) tokenChannel() string {
+	if channelID := b.channelRoutes().channel(ErrorMessages, ""); channelID != "" {
+		return channelID
+	}
+	return b.channelID
+}
+
+// tokenRevoked returns since when the token is revoked, false if it works.
+func (b *quartermasterBot) tokenRevoked() (time.Time, bool) {
+	b.tokenLock.Lock()
+	defer b.tokenLock.Unlock()
+
+	return b.tokenRevokedSince, b.tokenRevokedErr != nil
+}
+
+// degradedText returns short description of degraded state for message
+// footers, empty if the token works.
+func (b *quartermasterBot) degradedText() string {
+	since, revoked := b.tokenRevoked()
+	if !revoked {
+		return ""
+	}
+	return fmt.Sprintf("degraded: EVE login expired %s, run quartermaster login", since.UTC().Format("2006-01-02 15:04 UTC"))
+}
+
+func tokenRevokedMessage(err error, since time.Time) *discordgo.MessageEmbed {
+	reason := "was rejected"
+	if code, _ := token.ErrorCode(err); code != "" {
+		reason = fmt.Sprintf("failed with `%s`", code)
+	}
+	return &discordgo.MessageEmbed{
+		Title: ":warning: EVE login expired",
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: "https://i.imgur.com/ZwUn8DI.jpg",
+		},
+		Color: 0xff0000,
+		Description: fmt.Sprintf(
+			"Refreshing the EVE token %s at %s. The character who logged in left the corporation, "+
+				"lost roles or revoked the application.\n\n"+
+				"Contracts can't be checked and stock shown by the bot is stale until a director runs "+
+				"`quartermaster login` again with the same `--auth_file`, no restart is needed.",
+			reason,
+			since.UTC().Format("2006-01-02 15:04 UTC"),
+		),
+		Timestamp: time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+	}
+}
+
+func tokenRestoredMessage() *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Title: ":white_check_mark: EVE login works again",
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: "https://i.imgur.com/ZwUn8DI.jpg",
+		},
+		Color:       0x00ff00,
+		Description: "Contracts are checked again.",
+		Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+	}
+}
@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esifake"
+
+	"github.com/bwmarrin/discordgo"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenRevokedMessageChannel(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+
+	var (
+		lock     sync.Mutex
+		channels []string
+	)
+	session, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatalf("unable to create session: %+v", err)
+	}
+	session.Client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		// Path is /api/v8/channels/ID/messages.
+		parts := strings.Split(r.URL.Path, "/")
+		lock.Lock()
+		channels = append(channels, parts[len(parts)-2])
+		lock.Unlock()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"id": "1"}`)),
+			Request:    r,
+		}, nil
+	})}
+	revoked := &oauth2.RetrieveError{
+		Response: &http.Response{StatusCode: http.StatusBadRequest},
+		Body:     []byte(`{"error": "invalid_grant"}`),
+	}
+
+	tests := []struct {
+		name           string
+		configChannels map[ChannelRoute]string
+		want           string
+	}{
+		{
+			name: "main channel",
+			want: "10",
+		},
+		{
+			name:           "errors channel",
+			configChannels: map[ChannelRoute]string{{MessageType: ErrorMessages}: "20"},
+			want:           "20",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lock.Lock()
+			channels = nil
+			lock.Unlock()
+			b := newTestBot(t, server, everyone(t))
+			b.discord = session
+			b.channelID = "10"
+			b.configChannels = test.configChannels
+
+			b.setTokenError(revoked)
+			b.setTokenError(revoked)
+			b.setTokenError(nil)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if len(channels) != 2 || channels[0] != test.want || channels[1] != test.want {
+				t.Errorf("revoked and restored messages sent to %v, want 2 messages to %s", channels, test.want)
+			}
+		})
+	}
+}
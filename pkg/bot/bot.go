@@ -3,23 +3,17 @@ package bot
 import (
 	"context"
 	"fmt"
-	"io"
 	"math"
-	"net/http"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/adrg/strutil"
-	"github.com/adrg/strutil/metrics"
 	"github.com/lunemec/eve-quartermaster/pkg/repository"
 	"github.com/lunemec/eve-quartermaster/pkg/token"
 
 	"github.com/antihax/goesi"
 	"github.com/antihax/goesi/esi"
-	"github.com/antihax/goesi/optional"
 	"github.com/bwmarrin/discordgo"
 	"github.com/pkg/errors"
 )
@@ -34,6 +28,12 @@ type Bot interface {
 type botRepository interface {
 	repository.Repository
 	repository.PriceHistory
+	repository.Locations
+	repository.Permissions
+	repository.Channels
+	repository.Contracts
+	repository.Events
+	repository.Names
 }
 
 type quartermasterBot struct {
@@ -43,21 +43,90 @@ type quartermasterBot struct {
 	esi         *goesi.APIClient
 	discord     *discordgo.Session
 	channelID   string
+	route       MessageRoute
+
+	// command -> Discord role IDs allowed to run it from the configuration,
+	// roles set by !perm replace them.
+	configRoles map[string][]string
+
+	// where the bot sends messages of each type from the configuration,
+	// channels set by !channel replace them.
+	configChannels map[ChannelRoute]string
+
+	// where contracts and names are read from, ESI unless testing.
+	contractSource ContractSource
+	universe       UniverseSource
 
 	corporationID int32
 	allianceID    int32
 
+	// other alliance corporations whose alliance contracts count
+	// toward alliance doctrines.
+	allianceMembers []AllianceMember
+
 	checkInterval  time.Duration
 	notifyInterval time.Duration
 
 	repository botRepository
 
+	// global contract title matcher, doctrines may have their own.
+	matcher Matcher
+
+	// global staging station or structure IDs, doctrines may have their own.
+	stagingLocations []int64
+
+	// whose contracts count toward stock.
+	issuerPolicy IssuerPolicy
+
+	// trusted contract issuers, corporation members are read once per
+	// issuersTTL, see trustedIssuers().
+	issuersLock    sync.Mutex
+	issuers        *issuerSet
+	issuersFetched time.Time
+
+	// do not notify about doctrines whose stock with ships in transit
+	// meets the requirement.
+	suppressInTransit bool
+
 	// mapping of "requireed" doctrine name last notify time
 	notified map[string]time.Time
 
-	// ID -> names map
+	// ID -> names map, backed by names saved in the repository.
 	names *sync.Map
 
+	// contract ID -> contract items map
+	contractItemsCache *sync.Map
+
+	// doctrine matching strategy -> its matcher
+	matchers *sync.Map
+
+	// contract ledger is updated by one check at a time so each
+	// contract event is found only once.
+	ledgerLock sync.Mutex
+
+	// contract events are published by one goroutine at a time.
+	publishLock sync.Mutex
+
+	// how many contract pages are fetched from ESI at once.
+	pageConcurrency int
+
+	// contracts shared by all handlers, see contracts().
+	snapshotLock sync.Mutex
+	snapshot     contractSnapshot
+
+	// when the snapshot was loaded, readable while it is refreshed.
+	snapshotTimeLock sync.RWMutex
+	snapshotFetched  time.Time
+
+	// where to send contract lifecycle events.
+	eventSinks []EventSink
+
+	// EVE token refresh error while the login is revoked, the bot
+	// is degraded until it works again.
+	tokenLock         sync.Mutex
+	tokenRevokedErr   error
+	tokenRevokedSince time.Time
+
 	// Map of migrations to apply by reacting to message.
 	pendingMigrations *sync.Map
 }
@@ -70,12 +139,23 @@ type logger interface {
 // NewQuartermasterBot returns new bot instance.
 func NewQuartermasterBot(
 	log logger,
-	client *http.Client,
+	esi *goesi.APIClient,
+	contractSource ContractSource,
+	universe UniverseSource,
 	tokenSource token.Source,
 	discord *discordgo.Session,
 	channelID string,
+	route MessageRoute,
+	commandRoles map[string][]string,
 	corporationID, allianceID int32,
+	allianceMembers []AllianceMember,
 	repository botRepository,
+	matcher Matcher,
+	stagingLocations []int64,
+	issuerPolicy IssuerPolicy,
+	suppressInTransit bool,
+	channels map[ChannelRoute]string,
+	pageConcurrency int,
 	checkInterval, notifyInterval time.Duration,
 ) Bot {
 	log.Infow("EVE Quartermaster starting",
@@ -83,52 +163,97 @@ func NewQuartermasterBot(
 		"notify_interval", notifyInterval,
 	)
 
-	esi := goesi.NewAPIClient(client, "EVE Quartermaster (lu.nemec@gmail.com)")
-	return &quartermasterBot{
-		ctx:               context.WithValue(context.Background(), goesi.ContextOAuth2, tokenSource),
-		tokenSource:       tokenSource,
-		log:               log,
-		esi:               esi,
-		discord:           discord,
-		channelID:         channelID,
-		corporationID:     corporationID,
-		allianceID:        allianceID,
-		checkInterval:     checkInterval,
-		notifyInterval:    notifyInterval,
-		repository:        repository,
-		notified:          make(map[string]time.Time),
-		names:             new(sync.Map),
-		pendingMigrations: new(sync.Map),
-	}
+	if pageConcurrency < 1 {
+		pageConcurrency = 1
+	}
+	bot := &quartermasterBot{
+		ctx:                context.WithValue(context.Background(), goesi.ContextOAuth2, tokenSource),
+		tokenSource:        tokenSource,
+		log:                log,
+		esi:                esi,
+		contractSource:     contractSource,
+		universe:           universe,
+		discord:            discord,
+		channelID:          channelID,
+		route:              route,
+		configRoles:        commandRoles,
+		configChannels:     channels,
+		corporationID:      corporationID,
+		allianceID:         allianceID,
+		allianceMembers:    allianceMembers,
+		checkInterval:      checkInterval,
+		notifyInterval:     notifyInterval,
+		repository:         repository,
+		matcher:            matcher,
+		stagingLocations:   stagingLocations,
+		issuerPolicy:       issuerPolicy,
+		suppressInTransit:  suppressInTransit,
+		notified:           make(map[string]time.Time),
+		names:              new(sync.Map),
+		contractItemsCache: new(sync.Map),
+		matchers:           new(sync.Map),
+		pendingMigrations:  new(sync.Map),
+		pageConcurrency:    pageConcurrency,
+	}
+	bot.eventSinks = append(bot.eventSinks, channelEventSink{bot: bot})
+	return bot
 }
 
 // Bot - you know, do what a bot does.
 func (b *quartermasterBot) Bot() error {
+	// Bots of several corporations share the session, only the first opens it.
 	err := b.discord.Open()
-	if err != nil {
+	if err != nil && !errors.Is(err, discordgo.ErrWSAlreadyOpen) {
 		return errors.Wrap(err, "unable to connect to discord")
 	}
-	// Add handler to listen for "!help" messages as help message.
-	b.discord.AddHandler(IgnoreSelfMessages(IgnorePrivateMessages(b.helpHandler)))
-	// Add handler to listen for "!parse excel" messages for bulk insert from excel (or google) sheet.
-	b.discord.AddHandler(IgnoreSelfMessages(IgnorePrivateMessages(b.parseExcelHandler)))
-	// Add handler to listen for "!qm" messages to show missing doctrines on contract.
-	b.discord.AddHandler(IgnoreSelfMessages(IgnorePrivateMessages(b.reportHandler)))
-	// Add handler to listen for "!stock" messages to list currently available doctrines in stock.
-	b.discord.AddHandler(IgnoreSelfMessages(IgnorePrivateMessages(b.stockHandler)))
-	// Add handler to listen for "!require" messages to manage target doctrine numbers to be stocked.
-	b.discord.AddHandler(IgnoreSelfMessages(IgnorePrivateMessages(b.requireHandler)))
-	// Add handler to listen for "!price" messages to record doctrine price history.
-	b.discord.AddHandler(IgnoreSelfMessages(IgnorePrivateMessages(b.recordPrice)))
-	// Add handler to listen for "!leaderboard" messages to show hauling leaderboard.
-	b.discord.AddHandler(IgnoreSelfMessages(IgnorePrivateMessages(b.leaderboard)))
-	// Add handler to listen for "!migrate" messages to migrate doctrines.
-	b.discord.AddHandler(IgnoreSelfMessages(IgnorePrivateMessages(b.migrate)))
+	// Command handlers are called only when the author can run the command.
+	b.discord.AddHandler(IgnoreSelfMessages(IgnorePrivateMessages(IgnoreOtherRoutes(b.route, b.checkPermissions(b.commandHandlers())))))
+	// Add handler to listen for slash commands, they are passed to the command handlers.
+	b.discord.AddHandler(b.interactionHandler)
+	err = b.registerCommands()
+	if err != nil {
+		b.log.Errorw("error registering slash commands, only ! commands will work", "error", err)
+	}
 	b.discord.AddHandler(b.migrateReact)
 
 	return b.runForever()
 }
 
+// commandHandlers returns handlers of ! commands, each is called with every
+// message and responds to its commands.
+func (b *quartermasterBot) commandHandlers() []func(*discordgo.Session, *discordgo.MessageCreate) {
+	return []func(*discordgo.Session, *discordgo.MessageCreate){
+		// Handler to listen for "!help" messages as help message.
+		b.helpHandler,
+		// Handler to listen for "!parse excel" messages for bulk insert from excel (or google) sheet.
+		b.parseExcelHandler,
+		// Handler to listen for "!qm" messages to show missing doctrines on contract.
+		b.reportHandler,
+		// Handler to listen for "!stock" messages to list currently available doctrines in stock.
+		b.stockHandler,
+		// Handler to listen for "!require" messages to manage target doctrine numbers to be stocked.
+		b.requireHandler,
+		// Handler to listen for "!price" messages to record doctrine price history.
+		b.recordPrice,
+		// Handler to listen for "!leaderboard" messages to show hauling leaderboard.
+		b.leaderboard,
+		// Handler to listen for "!doctrine" messages to manage doctrine fits.
+		b.doctrineHandler,
+		// Handler to listen for "!alias" messages to manage accepted contract titles.
+		b.aliasHandler,
+		// Handler to listen for "!match" messages to explain contract title matching.
+		b.matchHandler,
+		// Handler to listen for "!location" messages to manage named locations.
+		b.locationHandler,
+		// Handler to listen for "!migrate" messages to migrate doctrines.
+		b.migrate,
+		// Handler to listen for "!perm" messages to manage roles allowed to run commands.
+		b.permHandler,
+		// Handler to listen for "!channel" messages to manage where messages are sent.
+		b.channelHandler,
+	}
+}
+
 func IgnoreSelfMessages(
 	handler func(*discordgo.Session, *discordgo.MessageCreate),
 ) func(*discordgo.Session, *discordgo.MessageCreate) {
@@ -156,6 +281,20 @@ func IgnorePrivateMessages(
 type doctrineReport struct {
 	doctrine    repository.Doctrine
 	haveInStock int
+	inTransit   int    // Doctrine ships in courier contracts to the location.
+	location    string // Named requirement location, empty for staging locations.
+
+	suppliers  map[int32]int // Issuer corporation ID -> how many contracts it has in stock.
+	suppliedBy string        // Suppliers with names, only when alliance members are read.
+}
+
+// notifyKey returns key under which the doctrine report notification is
+// remembered, so each location is notified separately.
+func (r doctrineReport) notifyKey() string {
+	if r.location == defaultLocation {
+		return r.doctrine.Name
+	}
+	return fmt.Sprintf("%s@%s", r.doctrine.Name, r.location)
 }
 
 func (b *quartermasterBot) runForever() error {
@@ -166,64 +305,71 @@ func (b *quartermasterBot) runForever() error {
 			notifyDoctrines      = make(map[string]struct{})
 		)
 
+		b.checkToken()
 		missingCorpDoctrines, missingAllianceDoctrines, _, err := b.reportMissing()
 		if err != nil {
-			b.log.Errorw("Error checking for missing doctrines",
-				"error", err,
-			)
+			// Revoked token was already reported by checkToken.
+			if !token.IsRevoked(err) {
+				b.log.Errorw("Error checking for missing doctrines",
+					"error", err,
+				)
+				b.notifyError(err)
+			}
 			goto SLEEP
 		}
 
+		if b.suppressInTransit {
+			missingCorpDoctrines = filterInTransit(missingCorpDoctrines)
+			missingAllianceDoctrines = filterInTransit(missingAllianceDoctrines)
+		}
 		allDoctrines = append(missingCorpDoctrines, missingAllianceDoctrines...)
 
 		// If just one of the missing doctrines should be notified about, notify about all.
 		for _, missingDoctrine := range allDoctrines {
-			shouldNotifyDoctrine = b.shouldNotify(missingDoctrine.doctrine.Name)
+			shouldNotifyDoctrine = b.shouldNotify(missingDoctrine.notifyKey())
 			if shouldNotifyDoctrine {
-				notifyDoctrines[missingDoctrine.doctrine.Name] = struct{}{}
+				notifyDoctrines[missingDoctrine.notifyKey()] = struct{}{}
 			}
 		}
 
 		if len(notifyDoctrines) != 0 {
-			messages := b.notifyMessage(
-				filterNotifyDoctrines(notifyDoctrines, missingCorpDoctrines),
-				filterNotifyDoctrines(notifyDoctrines, missingAllianceDoctrines),
-			)
-			if len(messages) == 0 {
-				b.log.Infow("No doctrines added yet, sleeping.")
-				goto SLEEP
-			}
-			for _, message := range messages {
-				_, err = b.discord.ChannelMessageSendEmbed(
-					b.channelID,
-					message,
-				)
-				switch {
-				case err != nil:
-					b.log.Errorw("Error sending discord message",
-						"error", err,
+			// Doctrine groups can have their own channel.
+			routes := b.channelRoutes()
+			corporationByChannel := b.reportsByChannel(routes, LowStockMessages, filterNotifyDoctrines(notifyDoctrines, missingCorpDoctrines), b.channelID)
+			allianceByChannel := b.reportsByChannel(routes, LowStockMessages, filterNotifyDoctrines(notifyDoctrines, missingAllianceDoctrines), b.channelID)
+			for _, channelID := range reportChannels(corporationByChannel, allianceByChannel) {
+				for _, message := range b.notifyMessage(corporationByChannel[channelID], allianceByChannel[channelID]) {
+					_, err = b.discord.ChannelMessageSendEmbed(
+						channelID,
+						message,
 					)
-					// In case of error, we fall through to the time.Sleep
-					// block. We also do not set the structure as notified
-					// and it get picked up on next iteration.
-					goto SLEEP
-				case err == nil:
-					for notifiedDoctrine := range notifyDoctrines {
-						b.setWasNotified(notifiedDoctrine)
+					if err != nil {
+						b.log.Errorw("Error sending discord message",
+							"error", err,
+						)
+						// In case of error, we fall through to the time.Sleep
+						// block. We also do not set the structure as notified
+						// and it get picked up on next iteration.
+						goto SLEEP
 					}
 				}
+				for _, notifiedDoctrine := range append(corporationByChannel[channelID], allianceByChannel[channelID]...) {
+					b.setWasNotified(notifiedDoctrine.notifyKey())
+				}
 			}
 		}
+		b.notifyProblematic()
 	SLEEP:
 		time.Sleep(b.checkInterval)
 	}
 }
 
 func (b *quartermasterBot) reportMissing() ([]doctrineReport, []doctrineReport, bool, error) {
-	allContracts, err := b.loadContracts()
+	snapshot, err := b.contracts()
 	if err != nil {
 		return nil, nil, false, errors.Wrap(err, "unable to load contracts")
 	}
+	allContracts := snapshot.contracts
 
 	corporationContracts, allianceContracts := b.filterAndGroupContracts(
 		allContracts,
@@ -237,18 +383,30 @@ func (b *quartermasterBot) reportMissing() ([]doctrineReport, []doctrineReport,
 		b.log.Errorw("error tracking and saving price history", "error", err)
 	}
 
-	gotCorporationDoctrines := doctrinesAvailable(corporationContracts)
-	gotAllianceDoctrines := doctrinesAvailable(allianceContracts)
 	requireAllDoctrines, err := b.repository.ReadAll()
 	if err != nil {
 		return nil, nil, false, errors.Wrap(err, "error reading required doctrines")
 	}
+	requireAllDoctrines = filterRequired(requireAllDoctrines)
+	namedLocations, err := b.namedLocations()
+	if err != nil {
+		return nil, nil, false, err
+	}
+	trustedIssuers, err := b.trustedIssuers()
+	if err != nil {
+		return nil, nil, false, errors.Wrap(err, "unable to load trusted contract issuers")
+	}
+	corporationContracts = b.filterStockContracts(requireAllDoctrines, trustedIssuers, corporationContracts)
+	allianceContracts = b.filterStockContracts(requireAllDoctrines, trustedIssuers, allianceContracts)
 
 	requireCorporationDoctrines := filterDoctrines(requireAllDoctrines, repository.Corporation)
 	requireAllianceDoctrines := filterDoctrines(requireAllDoctrines, repository.Alliance)
 
-	missingCorporationDoctrines := b.missingDoctrines(requireCorporationDoctrines, gotCorporationDoctrines)
-	missingAllianceDoctrines := b.missingDoctrines(requireAllianceDoctrines, gotAllianceDoctrines)
+	inTransit := b.inTransitDoctrines(requireAllDoctrines, allContracts, namedLocations)
+
+	// Contracts count toward the stock of the location they are at.
+	missingCorporationDoctrines := b.doctrinesByLocation(requireCorporationDoctrines, corporationContracts, namedLocations, inTransit, b.missingDoctrines)
+	missingAllianceDoctrines := b.doctrinesByLocation(requireAllianceDoctrines, allianceContracts, namedLocations, inTransit, b.missingDoctrines)
 
 	// If there is no missing contracts and contracts that are required, it means we
 	// have everything up on contract and nothing missing.
@@ -258,6 +416,20 @@ func (b *quartermasterBot) reportMissing() ([]doctrineReport, []doctrineReport,
 	return missingCorporationDoctrines, missingAllianceDoctrines, allIsOnContract, nil
 }
 
+// filterStockContracts removes contracts that should not count toward
+// the doctrine stock, they are reported by filterAlertContracts instead.
+func (b *quartermasterBot) filterStockContracts(
+	requiredDoctrines []repository.Doctrine,
+	trustedIssuers *issuerSet,
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok,
+) []esi.GetCorporationsCorporationIdContracts200Ok {
+	// Contracts from untrusted issuers do not count as stock.
+	contracts = filterUntrustedIssuers(trustedIssuers, contracts)
+	// Contracts that do not contain the doctrine fit do not count as stock,
+	// contracts at wrong locations are left out when grouped by location.
+	return b.filterFitMismatches(requiredDoctrines, contracts)
+}
+
 func (b *quartermasterBot) trackAndSavePrices(allContracts []esi.GetCorporationsCorporationIdContracts200Ok) error {
 	// Check if contract title starts with *, that is used to track price.
 	// Example: "* v1 Shield Svipul"
@@ -321,9 +493,12 @@ func (b *quartermasterBot) trackAndSavePrices(allContracts []esi.GetCorporations
 		return errors.Wrap(err, "error reading required doctrines")
 	}
 
+	// Only prices are saved, doctrines may be changed by commands meanwhile.
+	var doctrinePrices = make(map[string]repository.DoctrinePrice)
 	for _, requiredDoctrine := range requireAllDoctrines {
-		// Update price to max(price) last 2x doctrine.RequireStock contracts.
-		n := requiredDoctrine.RequireStock * 2
+		// Update price to max(price) last 2x doctrine required stock contracts,
+		// at the staging and named locations.
+		n := requiredDoctrine.TotalRequired() * 2
 		prices, err := b.repository.NPricesForDoctrine(requiredDoctrine.Name, n)
 		if err != nil {
 			return errors.Wrap(err, "error listing last N prices for doctrine")
@@ -333,15 +508,16 @@ func (b *quartermasterBot) trackAndSavePrices(allContracts []esi.GetCorporations
 
 		// Update only if the max price is non-zero.
 		if historicalMaxPrice.Price != 0 {
-			requiredDoctrine.Price.Buy = historicalMaxPrice.Price
-			requiredDoctrine.Price.Timestamp = historicalMaxPrice.Timestamp
-
-			err = b.repository.Set(requiredDoctrine.Name, requiredDoctrine)
-			if err != nil {
-				return errors.Wrap(err, "error saving doctrine")
+			doctrinePrices[requiredDoctrine.Name] = repository.DoctrinePrice{
+				Buy:       historicalMaxPrice.Price,
+				Timestamp: historicalMaxPrice.Timestamp,
 			}
 		}
 	}
+	err = b.repository.SetPrices(doctrinePrices)
+	if err != nil {
+		return errors.Wrap(err, "error saving doctrine prices")
+	}
 	return nil
 }
 
@@ -357,6 +533,18 @@ func maxPrice(prices []repository.PriceData) repository.PriceData {
 	return seen
 }
 
+// filterRequired returns doctrines that have anything required to be on
+// contract, doctrines are kept with nothing required for their fit.
+func filterRequired(doctrines []repository.Doctrine) []repository.Doctrine {
+	var out []repository.Doctrine
+	for _, doctrine := range doctrines {
+		if doctrine.Required() {
+			out = append(out, doctrine)
+		}
+	}
+	return out
+}
+
 func filterDoctrines(doctrines []repository.Doctrine, contractedOn repository.ContractedOn) []repository.Doctrine {
 	var out []repository.Doctrine
 	for _, doctrine := range doctrines {
@@ -375,7 +563,7 @@ func (b *quartermasterBot) missingDoctrines(
 		report []doctrineReport
 	)
 
-	doctrinesDiff := diffDoctrines(requireDoctrines, gotContracts)
+	doctrinesDiff := b.diffDoctrines(requireDoctrines, gotContracts)
 	for _, doctrineReport := range doctrinesDiff {
 		if doctrineReport.haveInStock < doctrineReport.doctrine.RequireStock {
 			report = append(report, doctrineReport)
@@ -388,7 +576,7 @@ func (b *quartermasterBot) missingDoctrines(
 	return report
 }
 
-func diffDoctrines(
+func (b *quartermasterBot) diffDoctrines(
 	requireDoctrines []repository.Doctrine,
 	gotContracts map[string]int,
 ) map[string]doctrineReport {
@@ -396,28 +584,19 @@ func diffDoctrines(
 		missing = make(map[string]doctrineReport)
 	)
 
-	// Iterate over contracts, and decrement from missing map for
-	// each found doctrine ship.
 	for _, requireDoctrine := range requireDoctrines {
-		found := false
-		for contractName, haveInStock := range gotContracts {
-			namesEqual := compareDoctrineNames(requireDoctrine.Name, contractName)
-			if namesEqual {
-				found = true
-
-				report := missing[requireDoctrine.Name]
-				report.doctrine = requireDoctrine
-				report.haveInStock += haveInStock
-				missing[requireDoctrine.Name] = report
-			}
-		}
-		if !found {
-			missing[requireDoctrine.Name] = doctrineReport{
-				doctrine:    requireDoctrine,
-				haveInStock: 0,
-			}
+		missing[requireDoctrine.Name] = doctrineReport{
+			doctrine:    requireDoctrine,
+			haveInStock: 0,
 		}
 	}
+	// Each contract title counts toward one doctrine only, the best matching one.
+	assigned, _ := b.assignContracts(requireDoctrines, gotContracts)
+	for contractName, doctrineName := range assigned {
+		report := missing[doctrineName]
+		report.haveInStock += gotContracts[contractName]
+		missing[doctrineName] = report
+	}
 
 	return missing
 }
@@ -425,7 +604,7 @@ func diffDoctrines(
 func filterNotifyDoctrines(notifyDoctrines map[string]struct{}, doctrines []doctrineReport) []doctrineReport {
 	var out []doctrineReport
 	for _, doctrine := range doctrines {
-		_, ok := notifyDoctrines[doctrine.doctrine.Name]
+		_, ok := notifyDoctrines[doctrine.notifyKey()]
 		if ok {
 			out = append(out, doctrine)
 		}
@@ -433,40 +612,83 @@ func filterNotifyDoctrines(notifyDoctrines map[string]struct{}, doctrines []doct
 	return out
 }
 
-// loadContracts returns contracts from EVE ESI which are assigned to specified
-// assigneeID.
-func (b *quartermasterBot) loadContracts() (
+// filterInTransit removes doctrines whose stock with ships in transit
+// meets the requirement.
+func filterInTransit(doctrines []doctrineReport) []doctrineReport {
+	var out []doctrineReport
+	for _, doctrine := range doctrines {
+		if doctrine.haveInStock+doctrine.inTransit < doctrine.doctrine.RequireStock {
+			out = append(out, doctrine)
+		}
+	}
+	return out
+}
+
+// fetchContracts returns contracts from EVE ESI which are assigned to specified
+// assigneeID, and when ESI data expires (zero if unknown).
+func (b *quartermasterBot) fetchContracts() (
+	[]esi.GetCorporationsCorporationIdContracts200Ok,
+	time.Time,
+	error,
+) {
+	return b.fetchCorporationContracts(b.ctx, b.corporationID)
+}
+
+// fetchCorporationContracts returns all pages of the corporation contracts,
+// ctx is authenticated as character of the corporation.
+func (b *quartermasterBot) fetchCorporationContracts(ctx context.Context, corporationID int32) (
 	[]esi.GetCorporationsCorporationIdContracts200Ok,
+	time.Time,
 	error,
 ) {
 	var allContracts []esi.GetCorporationsCorporationIdContracts200Ok
 
-	contractsPage, resp, err := b.esi.ESI.ContractsApi.GetCorporationsCorporationIdContracts(b.ctx, b.corporationID, nil)
+	contractsPage, pageInfo, err := b.contractSource.ContractsPage(ctx, corporationID, 1)
 	if err != nil {
-		return nil, errors.Wrap(err, "error calling ESI API")
+		return nil, time.Time{}, err
 	}
 	allContracts = append(allContracts, contractsPage...)
+	// Unknown Expires is zero time, snapshot is then refreshed on checkInterval.
+	expires := pageInfo.Expires
+	pages := pageInfo.Pages
 
-	pages, err := strconv.Atoi(resp.Header.Get("X-Pages"))
-	if err != nil {
-		return nil, errors.Wrap(err, "error converting X-Pages to integer")
+	// Fetch additional pages if any (starting page above is 1), at most
+	// b.pageConcurrency at once. Each page is saved to its slot so they
+	// are merged in page order.
+	var (
+		wg        sync.WaitGroup
+		semaphore = make(chan struct{}, b.pageConcurrency)
+		pageCount = 0
+	)
+	if pages > 1 {
+		pageCount = pages - 1
 	}
-	// Fetch additional pages if any (starting page above is 1).
+	var (
+		results = make([][]esi.GetCorporationsCorporationIdContracts200Ok, pageCount)
+		errs    = make([]error, pageCount)
+	)
 	for i := 2; i <= pages; i++ {
-		contractsPage, _, err := b.esi.ESI.ContractsApi.GetCorporationsCorporationIdContracts(
-			b.ctx,
-			b.corporationID,
-			&esi.GetCorporationsCorporationIdContractsOpts{
-				Page: optional.NewInt32(int32(i)),
-			},
-		)
-		if err != nil {
-			return nil, errors.Wrap(err, "error calling ESI API")
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(page int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			contractsPage, _, err := b.contractSource.ContractsPage(ctx, corporationID, int32(page))
+			results[page-2] = contractsPage
+			errs[page-2] = err
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil {
+			return nil, time.Time{}, errs[i]
 		}
-		allContracts = append(allContracts, contractsPage...)
+		allContracts = append(allContracts, results[i]...)
 	}
 
-	return allContracts, nil
+	return allContracts, expires, nil
 }
 
 type contractStatus string
@@ -532,52 +754,98 @@ func (b *quartermasterBot) filterAndGroupContracts(
 type alertContract struct {
 	Contract esi.GetCorporationsCorporationIdContracts200Ok
 	Reason   string
+	Group    string // Group of the doctrine the contract counts toward.
+}
+
+// notifyKey is key of the alert in notified map.
+func (a alertContract) notifyKey() string {
+	return fmt.Sprintf("problematic:%d:%s", a.Contract.ContractId, a.Reason)
 }
 
 func (b *quartermasterBot) filterAlertContracts(
 	requiredDoctrines []repository.Doctrine,
+	trustedIssuers *issuerSet,
 	contracts []esi.GetCorporationsCorporationIdContracts200Ok,
 ) []alertContract {
 	var (
 		alertContracts []alertContract
 	)
-	for _, requiredDoctrine := range requiredDoctrines {
-		for _, contract := range contracts {
-			if contract.AssigneeId != b.corporationID && contract.AssigneeId != b.allianceID {
-				continue
-			}
-			// Ignore price-tracking contracts in alerts.
-			if strings.HasPrefix(contract.Title, "*") {
-				continue
-			}
-			// Skip contracts that are not required doctrines.
-			if !compareDoctrineNames(requiredDoctrine.Name, contract.Title) {
-				continue
-			}
+	namedLocations, err := b.namedLocations()
+	if err != nil {
+		b.log.Errorw("error loading named locations", "error", err)
+	}
+	for _, contract := range contracts {
+		if contract.AssigneeId != b.corporationID && contract.AssigneeId != b.allianceID {
+			continue
+		}
+		// Ignore price-tracking contracts in alerts.
+		if strings.HasPrefix(contract.Title, "*") {
+			continue
+		}
+		// Skip contracts that are not required doctrines.
+		requiredDoctrine, _, ok := b.bestDoctrine(requiredDoctrines, contract.Title)
+		if !ok {
+			continue
+		}
 
-			switch contract.Status {
-			case string(statusCancelled), string(statusDeleted), string(statusFinished), string(statusFinishedContractor), string(statusFinishedIssuer):
+		switch contract.Status {
+		case string(statusCancelled), string(statusDeleted), string(statusFinished), string(statusFinishedContractor), string(statusFinishedIssuer):
+			continue
+		}
+		// Alert if we bought this doctrine for more than we are selling it.
+		if requiredDoctrine.Price.Buy > uint64(contract.Price) {
+			alertContracts = append(alertContracts, alertContract{
+				Contract: contract,
+				Group:    requiredDoctrine.Group,
+				Reason:   "Price",
+			})
+		}
+		// Alert expired contract.
+		if contract.DateExpired.Before(time.Now()) {
+			alertContracts = append(alertContracts, alertContract{
+				Contract: contract,
+				Group:    requiredDoctrine.Group,
+				Reason:   "Expired",
+			})
+		}
+		// Alert on contracts that are not at the staging or required location.
+		if _, ok := b.contractLocation(requiredDoctrine, contract, namedLocations); !ok {
+			alertContracts = append(alertContracts, alertContract{
+				Contract: contract,
+				Group:    requiredDoctrine.Group,
+				Reason:   fmt.Sprintf("Wrong location: %s", b.locationName(contract.StartLocationId)),
+			})
+		}
+		// Alert on contracts issued by someone we don't trust, like scammers
+		// or pilots who already left the corporation.
+		if isUntrustedIssuer(trustedIssuers, contract) {
+			alertContracts = append(alertContracts, alertContract{
+				Contract: contract,
+				Group:    requiredDoctrine.Group,
+				Reason:   "Untrusted issuer",
+			})
+		}
+		// Alert on wrong type of contract.
+		if contract.Type_ != string(typeItemExchange) {
+			alertContracts = append(alertContracts, alertContract{
+				Contract: contract,
+				Group:    requiredDoctrine.Group,
+				Reason:   "Wrong contract type",
+			})
+			continue
+		}
+		// Alert on contract contents not matching the doctrine fit.
+		if contract.Status == string(statusOutstanding) {
+			reason, err := b.fitMismatchReason(requiredDoctrine, contract)
+			if err != nil {
+				b.log.Errorw("error verifying contract fit", "error", err, "contract_id", contract.ContractId)
 				continue
 			}
-			// Alert if we bought this doctrine for more than we are selling it.
-			if requiredDoctrine.Price.Buy > uint64(contract.Price) {
-				alertContracts = append(alertContracts, alertContract{
-					Contract: contract,
-					Reason:   "Price",
-				})
-			}
-			// Alert expired contract.
-			if contract.DateExpired.Before(time.Now()) {
-				alertContracts = append(alertContracts, alertContract{
-					Contract: contract,
-					Reason:   "Expired",
-				})
-			}
-			// Alert on wrong type of contract.
-			if contract.Type_ != string(typeItemExchange) {
+			if reason != "" {
 				alertContracts = append(alertContracts, alertContract{
 					Contract: contract,
-					Reason:   "Wrong contract type",
+					Group:    requiredDoctrine.Group,
+					Reason:   reason,
 				})
 			}
 		}
@@ -586,31 +854,8 @@ func (b *quartermasterBot) filterAlertContracts(
 	return alertContracts
 }
 
-func (b *quartermasterBot) idToName(id int32) string {
-	nameInterface, ok := b.names.Load(id)
-	if !ok {
-		names, resp, err := b.esi.ESI.UniverseApi.PostUniverseNames(b.ctx, []int32{id}, nil)
-		if err != nil {
-			// Do not cache on error, so it will retry next time.
-			// Return back ID.
-			body, _ := io.ReadAll(resp.Body)
-			defer resp.Body.Close()
-			b.log.Errorw("Error translating ID to name", "error", err, "body", body)
-			return fmt.Sprint(id)
-		}
-		// If we do not have names, then return the ID back.
-		if len(names) != 1 {
-			b.log.Errorw("Error translating ID to name, returned no names", "len", len(names))
-			return fmt.Sprint(id)
-		}
-		name := names[0].Name
-		b.names.Store(id, name)
-		return name
-	}
-	return nameInterface.(string)
-}
-
-// doctrinesAvailable returns map of doctrine name -> count of contracts
+// doctrinesAvailable returns map of contract title -> count of contracts,
+// titles are matched to doctrines later by doctrine matching strategy.
 func doctrinesAvailable(contracts []esi.GetCorporationsCorporationIdContracts200Ok) map[string]int {
 	var out = make(map[string]int)
 	for _, contract := range contracts {
@@ -618,109 +863,57 @@ func doctrinesAvailable(contracts []esi.GetCorporationsCorporationIdContracts200
 		if strings.HasPrefix(contract.Title, "*") {
 			continue
 		}
-		var isInAvailable bool
-		for doctrineAvailable := range out {
-			namesEqual := compareDoctrineNames(doctrineAvailable, contract.Title)
-			if namesEqual {
-				isInAvailable = true
-				out[doctrineAvailable]++
-			}
-		}
-		if !isInAvailable {
-			out[contract.Title]++
-		}
+		out[strings.TrimSpace(contract.Title)]++
 	}
 
 	return out
 }
 
-func compareDoctrineNames(require, have string) bool {
-	requireParts := strings.Split(require, " ")
-	haveParts := strings.Split(have, " ")
-
-	var requirePartsEqual int
-	for _, requirePart := range requireParts {
-		requirePartLower := strings.ToLower(requirePart)
-
-		for _, havePart := range haveParts {
-			havePartLower := strings.ToLower(havePart)
-			if requirePartLower == havePartLower {
-				requirePartsEqual++
-			}
-		}
-	}
-
-	// Simple check went OK, all requireParts are found in haveParts.
-	if requirePartsEqual >= len(requireParts) {
-		return true
-	}
-	// Otherwise try similarity check.
-	// This matches more complicated names containing () and so on.
-	metric := metrics.NewJaccard()
-	metric.CaseSensitive = false
-	similarity := strutil.Similarity(require, have, metric)
-	return similarity >= 0.8
-}
-
 func (b *quartermasterBot) notifyMessage(
 	missingCorporationDoctrines, missingAllianceDoctrines []doctrineReport,
 ) []*discordgo.MessageEmbed {
-	var partsAlliance, partsCorporation []string
-
-	// Add "Alliance" block only if there is something to show there.
-	if len(missingAllianceDoctrines) != 0 {
-		for _, missingDoctrine := range missingAllianceDoctrines {
-			partsAlliance = append(partsAlliance, fmt.Sprintf("**%s** is low in stock, have %d but require %d",
-				missingDoctrine.doctrine.Name,
-				missingDoctrine.haveInStock,
-				missingDoctrine.doctrine.RequireStock,
-			))
-		}
-	}
-
-	// Add "Corporation" block only if there is something to show there.
-	if len(missingCorporationDoctrines) != 0 {
-		for _, missingDoctrine := range missingCorporationDoctrines {
-			partsCorporation = append(partsCorporation, fmt.Sprintf("**%s** is low in stock, have %d but require %d",
-				missingDoctrine.doctrine.Name,
-				missingDoctrine.haveInStock,
-				missingDoctrine.doctrine.RequireStock,
-			))
-		}
-	}
-
 	var (
 		messages []*discordgo.MessageEmbed
 		color    = 0xff0000
 	)
-	if len(partsAlliance) > 0 {
-		reportMessages := splitMessageParts(partsAlliance, discordMaxDescriptionLength)
-		for _, reportMessage := range reportMessages {
-			messages = append(messages, &discordgo.MessageEmbed{
-				Thumbnail: &discordgo.MessageEmbedThumbnail{
-					URL: "https://i.imgur.com/ZwUn8DI.jpg",
-				},
-				Color:       color,
-				Description: reportMessage,
-				Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
-				Title:       "Doctrine ship contracts low [Alliance]",
-			},
-			)
-		}
-	}
-	if len(partsCorporation) > 0 {
-		reportMessages := splitMessageParts(partsCorporation, discordMaxDescriptionLength)
-		for _, reportMessage := range reportMessages {
-			messages = append(messages, &discordgo.MessageEmbed{
-				Thumbnail: &discordgo.MessageEmbedThumbnail{
-					URL: "https://i.imgur.com/ZwUn8DI.jpg",
+	// Add "Alliance" and "Corporation" blocks for each location only if there
+	// is something to show there.
+	for _, side := range []struct {
+		contractedOn    string
+		missingDoctrine []doctrineReport
+	}{
+		{"Alliance", missingAllianceDoctrines},
+		{"Corporation", missingCorporationDoctrines},
+	} {
+		locations, grouped := groupReportsByLocation(side.missingDoctrine)
+		for _, location := range locations {
+			var parts []string
+			for _, missingDoctrine := range grouped[location] {
+				var inTransit string
+				if missingDoctrine.inTransit != 0 {
+					inTransit = fmt.Sprintf(" (+%d in transit)", missingDoctrine.inTransit)
+				}
+				parts = append(parts, fmt.Sprintf("**%s** is low in stock, have %d%s but require %d",
+					missingDoctrine.doctrine.Name,
+					missingDoctrine.haveInStock,
+					inTransit,
+					missingDoctrine.doctrine.RequireStock,
+				))
+			}
+
+			reportMessages := splitMessageParts(parts, discordMaxDescriptionLength)
+			for _, reportMessage := range reportMessages {
+				messages = append(messages, &discordgo.MessageEmbed{
+					Thumbnail: &discordgo.MessageEmbedThumbnail{
+						URL: "https://i.imgur.com/ZwUn8DI.jpg",
+					},
+					Color:       color,
+					Description: reportMessage,
+					Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+					Title:       locationTitle("Doctrine ship contracts low", side.contractedOn, location),
 				},
-				Color:       color,
-				Description: reportMessage,
-				Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
-				Title:       "Doctrine ship contracts low [Corporation]",
-			},
-			)
+				)
+			}
 		}
 	}
 
@@ -753,7 +946,31 @@ func (b *quartermasterBot) wasNotified(doctrineName string) bool {
 }
 
 func (b *quartermasterBot) sendError(errIn error, channelID string) {
+	if token.IsRevoked(errIn) {
+		_, wasRevoked := b.tokenRevoked()
+		b.setTokenError(errIn)
+		// The channel was just told by setTokenError.
+		if !wasRevoked && channelID == b.tokenChannel() {
+			return
+		}
+		since, _ := b.tokenRevoked()
+		_, err := b.discord.ChannelMessageSendEmbed(channelID, tokenRevokedMessage(errIn, since))
+		if err != nil {
+			b.log.Errorw("error responding with error", "error", err, "original_error", errIn)
+		}
+		return
+	}
 	msg := fmt.Sprintf("Sorry, some error happened: %s", errIn.Error())
+	// Details go to the errors channel if there is one.
+	errorsChannelID := b.channelRoutes().channel(ErrorMessages, "")
+	if errorsChannelID != "" && errorsChannelID != channelID {
+		_, err := b.discord.ChannelMessageSend(errorsChannelID, fmt.Sprintf("Error in <#%s>: %s", channelID, errIn.Error()))
+		if err != nil {
+			b.log.Errorw("error sending error to errors channel", "error", err, "original_error", errIn)
+		} else {
+			msg = fmt.Sprintf("Sorry, some error happened, see <#%s> for details.", errorsChannelID)
+		}
+	}
 	_, err := b.discord.ChannelMessageSend(channelID, msg)
 	if err != nil {
 		b.log.Errorw("error responding with error", "error", err, "original_error", errIn)
@@ -773,6 +990,7 @@ func (b *quartermasterBot) sendAllOnContractMessage(m *discordgo.MessageCreate)
 		Timestamp: time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
 		Title:     "Doctrine ship stock :ok_hand:",
 	}
+	b.addDataAge([]*discordgo.MessageEmbed{msg})
 	_, err := b.discord.ChannelMessageSendEmbed(
 		m.ChannelID,
 		msg,
@@ -0,0 +1,182 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esifake"
+	"github.com/lunemec/eve-quartermaster/pkg/token"
+
+	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+const testCorporationID = 98000001
+
+// newFakeESIBot returns bot reading contracts from the fake ESI server.
+func newFakeESIBot(server *esifake.Server, pageConcurrency int) *quartermasterBot {
+	client := goesi.NewAPIClient(http.DefaultClient, "quartermaster-test")
+	client.ESI.ChangeBasePath(server.URL)
+	source := NewESISource(client)
+	return &quartermasterBot{
+		ctx:                context.Background(),
+		log:                zap.NewNop().Sugar(),
+		contractSource:     source,
+		universe:           source,
+		corporationID:      testCorporationID,
+		pageConcurrency:    pageConcurrency,
+		notified:           make(map[string]time.Time),
+		names:              new(sync.Map),
+		contractItemsCache: new(sync.Map),
+		matchers:           new(sync.Map),
+		pendingMigrations:  new(sync.Map),
+	}
+}
+
+// testContracts returns n outstanding item exchange contracts with IDs from 1.
+func testContracts(n int) []esi.GetCorporationsCorporationIdContracts200Ok {
+	var out []esi.GetCorporationsCorporationIdContracts200Ok
+	for i := 1; i <= n; i++ {
+		out = append(out, esi.GetCorporationsCorporationIdContracts200Ok{
+			ContractId:  int32(i),
+			Title:       fmt.Sprintf("Contract %d", i),
+			Type_:       string(typeItemExchange),
+			Status:      string(statusOutstanding),
+			AssigneeId:  testCorporationID,
+			DateExpired: time.Now().Add(24 * time.Hour),
+		})
+	}
+	return out
+}
+
+func TestFetchContractsMergesPagesInOrder(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetPageSize(10)
+	server.SetContracts(testCorporationID, testContracts(95))
+
+	for _, pageConcurrency := range []int{1, 4} {
+		b := newFakeESIBot(server, pageConcurrency)
+		contracts, _, err := b.fetchCorporationContracts(b.ctx, testCorporationID)
+		if err != nil {
+			t.Fatalf("page_concurrency %d: unexpected error: %+v", pageConcurrency, err)
+		}
+		if len(contracts) != 95 {
+			t.Fatalf("page_concurrency %d: got %d contracts, want 95", pageConcurrency, len(contracts))
+		}
+		for i, contract := range contracts {
+			if contract.ContractId != int32(i+1) {
+				t.Fatalf("page_concurrency %d: contract %d has ID %d, pages are not merged in order", pageConcurrency, i, contract.ContractId)
+			}
+		}
+	}
+}
+
+func TestFetchContractsFailingPageFailsFetch(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetPageSize(10)
+	server.SetContracts(testCorporationID, testContracts(50))
+
+	// All pages share the path, so the first page fails here.
+	b := newFakeESIBot(server, 4)
+	server.FailNext(fmt.Sprintf("/v1/corporations/%d/contracts/", testCorporationID), http.StatusNotFound, 1)
+	contracts, _, err := b.fetchCorporationContracts(b.ctx, testCorporationID)
+	if err == nil {
+		t.Fatalf("expected error when page 1 fails, got %d contracts", len(contracts))
+	}
+
+	// Failure of a later page, all the other pages succeed.
+	b = newFakeESIBot(server, 4)
+	failLaterPage := &failingSource{ContractSource: b.contractSource, failPage: 3}
+	b.contractSource = failLaterPage
+	contracts, _, err = b.fetchCorporationContracts(b.ctx, testCorporationID)
+	if err == nil {
+		t.Fatalf("expected error when page 3 fails, got %d contracts", len(contracts))
+	}
+}
+
+// failingSource fails one page of contracts.
+type failingSource struct {
+	ContractSource
+	failPage int32
+}
+
+func (s *failingSource) ContractsPage(
+	ctx context.Context,
+	corporationID, page int32,
+) ([]esi.GetCorporationsCorporationIdContracts200Ok, PageInfo, error) {
+	if page == s.failPage {
+		return nil, PageInfo{}, fmt.Errorf("page %d failed", page)
+	}
+	return s.ContractSource.ContractsPage(ctx, corporationID, page)
+}
+
+func BenchmarkFetchContracts(b *testing.B) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetPageSize(100)
+	server.SetContracts(testCorporationID, testContracts(2000))
+	// Local server answers in microseconds, ESI takes tens of milliseconds.
+	server.SetLatency(5 * time.Millisecond)
+
+	for _, pageConcurrency := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("page_concurrency=%d", pageConcurrency), func(b *testing.B) {
+			bot := newFakeESIBot(server, pageConcurrency)
+			for i := 0; i < b.N; i++ {
+				_, _, err := bot.fetchCorporationContracts(bot.ctx, testCorporationID)
+				if err != nil {
+					b.Fatalf("unexpected error: %+v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestFetchContractsConcurrentTokenFile(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetPageSize(2)
+	server.SetLatency(5 * time.Millisecond)
+	server.SetContracts(testCorporationID, testContracts(40))
+
+	// Every ESI request reads the token, pages fetched at once share it.
+	storage := token.NewFileStorage(filepath.Join(t.TempDir(), "auth.bin"))
+	err := storage.Write(oauth2.Token{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("unable to write token: %+v", err)
+	}
+	tokenSource := token.NewSource(zap.NewNop().Sugar(), http.DefaultClient, storage, nil, "client", "secret", "http://localhost/callback", nil)
+
+	b := newFakeESIBot(server, 8)
+	b.ctx = context.WithValue(context.Background(), goesi.ContextOAuth2, tokenSource)
+	for i := 0; i < 3; i++ {
+		contracts, _, err := b.fetchCorporationContracts(b.ctx, testCorporationID)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		if len(contracts) != 40 {
+			t.Fatalf("got %d contracts, want 40", len(contracts))
+		}
+	}
+
+	saved, err := storage.Read()
+	if err != nil {
+		t.Fatalf("auth file is corrupt: %+v", err)
+	}
+	if saved.RefreshToken != "refresh" {
+		t.Errorf("got refresh token %s, want refresh", saved.RefreshToken)
+	}
+}
@@ -0,0 +1,390 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+// MessageType is kind of message the bot sends on its own, each type can
+// go to its own channel.
+type MessageType string
+
+const (
+	LowStockMessages    MessageType = "low_stock"
+	ProblematicMessages MessageType = "problematic"
+	EventMessages       MessageType = "events"
+	LeaderboardMessages MessageType = "leaderboard"
+	ErrorMessages       MessageType = "errors"
+)
+
+var messageTypes = []MessageType{
+	LowStockMessages,
+	ProblematicMessages,
+	EventMessages,
+	LeaderboardMessages,
+	ErrorMessages,
+}
+
+// perGroup reports whether messages of the type are about doctrines, so
+// doctrine groups can have their own channel.
+func (t MessageType) perGroup() bool {
+	return t == LowStockMessages || t == ProblematicMessages || t == EventMessages
+}
+
+// ChannelRoute is message type for doctrines of the group, empty group for
+// all doctrines.
+type ChannelRoute struct {
+	MessageType MessageType
+	Group       string
+}
+
+func (r ChannelRoute) String() string {
+	if r.Group == "" {
+		return string(r.MessageType)
+	}
+	return string(r.MessageType) + "/" + r.Group
+}
+
+// ParseChannelRoute parses "type" or "type/group", for example "low_stock"
+// or "low_stock/capitals".
+func ParseChannelRoute(value string) (ChannelRoute, error) {
+	parts := strings.SplitN(value, "/", 2)
+	route := ChannelRoute{MessageType: MessageType(parts[0])}
+	if len(parts) == 2 {
+		route.Group = strings.ToLower(strings.TrimSpace(parts[1]))
+	}
+	return route, route.validate()
+}
+
+func (r ChannelRoute) validate() error {
+	for _, messageType := range messageTypes {
+		if r.MessageType != messageType {
+			continue
+		}
+		if r.Group != "" && !messageType.perGroup() {
+			return errors.Errorf("%s messages can't have channel per doctrine group", messageType)
+		}
+		return nil
+	}
+	return errors.Errorf("unknown message type: %s, use one of: %s", r.MessageType, messageTypeNames())
+}
+
+func messageTypeNames() string {
+	var names []string
+	for _, messageType := range messageTypes {
+		names = append(names, string(messageType))
+	}
+	return strings.Join(names, ", ")
+}
+
+// channelRoutes is message route -> Discord channel ID.
+type channelRoutes map[ChannelRoute]string
+
+// channel returns channel of the message type for doctrine group, channel
+// of the whole type when the group has none. Empty if not set.
+func (r channelRoutes) channel(messageType MessageType, group string) string {
+	group = strings.ToLower(group)
+	if group != "" {
+		if channelID, ok := r[ChannelRoute{MessageType: messageType, Group: group}]; ok {
+			return channelID
+		}
+	}
+	return r[ChannelRoute{MessageType: messageType}]
+}
+
+// configured reports whether messages of the type have any channel, for
+// all doctrines or for a doctrine group.
+func (r channelRoutes) configured(messageType MessageType) bool {
+	for route := range r {
+		if route.MessageType == messageType {
+			return true
+		}
+	}
+	return false
+}
+
+// channelRoutes returns channels from the configuration replaced by
+// channels set by !channel.
+func (b *quartermasterBot) channelRoutes() channelRoutes {
+	var routes = make(channelRoutes)
+	for route, channelID := range b.configChannels {
+		routes[route] = channelID
+	}
+	channels, err := b.repository.ReadAllChannels()
+	if err != nil {
+		b.log.Errorw("error reading channels, using configured ones", "error", err)
+		return routes
+	}
+	for _, channel := range channels {
+		routes[ChannelRoute{MessageType: MessageType(channel.MessageType), Group: channel.Group}] = channel.ChannelID
+	}
+	return routes
+}
+
+// reportsByChannel splits doctrine reports by channel of their doctrine
+// group, reports without channel go to defaultChannelID.
+func (b *quartermasterBot) reportsByChannel(
+	routes channelRoutes,
+	messageType MessageType,
+	reports []doctrineReport,
+	defaultChannelID string,
+) map[string][]doctrineReport {
+	var out = make(map[string][]doctrineReport)
+	for _, report := range reports {
+		channelID := routes.channel(messageType, report.doctrine.Group)
+		if channelID == "" {
+			channelID = defaultChannelID
+		}
+		out[channelID] = append(out[channelID], report)
+	}
+	return out
+}
+
+// reportChannels returns sorted channel IDs of the reports split by channel.
+func reportChannels(byChannel ...map[string][]doctrineReport) []string {
+	var (
+		out  []string
+		seen = make(map[string]struct{})
+	)
+	for _, reports := range byChannel {
+		for channelID := range reports {
+			if _, ok := seen[channelID]; ok {
+				continue
+			}
+			seen[channelID] = struct{}{}
+			out = append(out, channelID)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// notifyProblematic posts problematic contracts to their channel, each
+// contract once per notify interval. Nothing is posted without the channel.
+func (b *quartermasterBot) notifyProblematic() {
+	routes := b.channelRoutes()
+	if !routes.configured(ProblematicMessages) {
+		return
+	}
+
+	snapshot, err := b.contracts()
+	if err != nil {
+		b.log.Errorw("Error loading contracts for problematic contracts", "error", err)
+		return
+	}
+	requireAllDoctrines, err := b.repository.ReadAll()
+	if err != nil {
+		b.log.Errorw("Error reading required doctrines for problematic contracts", "error", err)
+		return
+	}
+	requireAllDoctrines = filterRequired(requireAllDoctrines)
+	trustedIssuers, err := b.trustedIssuers()
+	if err != nil {
+		b.log.Errorw("Error loading trusted contract issuers for problematic contracts", "error", err)
+		return
+	}
+
+	var byChannel = make(map[string][]alertContract)
+	for _, alert := range b.filterAlertContracts(requireAllDoctrines, trustedIssuers, snapshot.contracts) {
+		channelID := routes.channel(ProblematicMessages, alert.Group)
+		if channelID == "" || !b.shouldNotify(alert.notifyKey()) {
+			continue
+		}
+		byChannel[channelID] = append(byChannel[channelID], alert)
+	}
+
+	for channelID, alerts := range byChannel {
+		var ids []int64
+		for _, alert := range alerts {
+			ids = append(ids, int64(alert.Contract.IssuerId))
+		}
+		b.resolveNames(ids)
+
+		var parts []string
+		for _, alert := range alerts {
+			parts = append(parts, fmt.Sprintf("**%s**: Reason: **%s** By: **%s**",
+				alert.Contract.Title,
+				alert.Reason,
+				b.idToName(alert.Contract.IssuerId),
+			))
+		}
+		for _, message := range splitMessageParts(parts, discordMaxDescriptionLength) {
+			_, err = b.discord.ChannelMessageSendEmbed(channelID, &discordgo.MessageEmbed{
+				Title: ":x: Problematic contracts",
+				Thumbnail: &discordgo.MessageEmbedThumbnail{
+					URL: "https://i.imgur.com/ZwUn8DI.jpg",
+				},
+				Color:       0xff0000,
+				Description: message,
+				Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+			})
+			if err != nil {
+				b.log.Errorw("Error sending problematic contracts message", "error", err, "channel_id", channelID)
+				break
+			}
+		}
+		if err != nil {
+			continue
+		}
+		for _, alert := range alerts {
+			b.setWasNotified(alert.notifyKey())
+		}
+	}
+}
+
+// notifyError posts error of periodic check to the errors channel, once
+// per notify interval.
+func (b *quartermasterBot) notifyError(errIn error) {
+	channelID := b.channelRoutes().channel(ErrorMessages, "")
+	if channelID == "" || !b.shouldNotify("errors") {
+		return
+	}
+	_, err := b.discord.ChannelMessageSend(channelID, fmt.Sprintf("Error checking contracts: %s", errIn))
+	if err != nil {
+		b.log.Errorw("error sending error message", "error", err, "original_error", errIn)
+		return
+	}
+	b.setWasNotified("errors")
+}
+
+var channelRegex = regexp.MustCompile(`^(?:<#([0-9]+)>|([0-9]+))$`)
+
+// channelHandler will be called every time a new
+// message is created on any channel that the autenticated bot has access to.
+func (b *quartermasterBot) channelHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Content == "!channel list" {
+		b.log.Infow("Responding to !channel list command", "channel_id", m.ChannelID)
+		_, err := b.discord.ChannelMessageSendEmbed(m.ChannelID, b.channelListMessage())
+		if err != nil {
+			b.log.Errorw("error sending message for !channel list", "error", err)
+		}
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!channel set ") {
+		b.log.Infow("Responding to !channel set command", "channel_id", m.ChannelID)
+		// Format is: "!channel set type [group] #channel", example: "!channel set low_stock capitals #capital-alerts"
+		fields := strings.Fields(strings.TrimPrefix(m.Content, "!channel set "))
+		if len(fields) < 2 {
+			msg := fmt.Sprintf("unrecognised !channel set, the format is `!channel set type [doctrine group] #channel`, types: %s", messageTypeNames())
+			_, err := b.discord.ChannelMessageSend(m.ChannelID, msg)
+			if err != nil {
+				b.log.Errorw("error responding to unknown !channel set", "error", err)
+			}
+			return
+		}
+		match := channelRegex.FindStringSubmatch(fields[len(fields)-1])
+		if match == nil {
+			b.sendError(errors.Errorf("`%s` is not a channel, mention the channel or use its ID", fields[len(fields)-1]), m.ChannelID)
+			return
+		}
+		route, err := channelRouteFields(fields[:len(fields)-1])
+		if err != nil {
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		err = b.repository.SetChannel(repository.Channel{
+			MessageType: string(route.MessageType),
+			Group:       route.Group,
+			ChannelID:   match[1] + match[2],
+		})
+		if err != nil {
+			b.log.Errorw("error saving channel", "error", err, "route", route.String())
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+		if err != nil {
+			b.log.Errorw("error reacting with :+1:", "error", err)
+			return
+		}
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!channel reset ") {
+		b.log.Infow("Responding to !channel reset command", "channel_id", m.ChannelID)
+		route, err := channelRouteFields(strings.Fields(strings.TrimPrefix(m.Content, "!channel reset ")))
+		if err != nil {
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		err = b.repository.DeleteChannel(string(route.MessageType), route.Group)
+		if err != nil {
+			b.log.Errorw("error removing channel", "error", err, "route", route.String())
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+		if err != nil {
+			b.log.Errorw("error reacting with :+1:", "error", err)
+			return
+		}
+		return
+	}
+}
+
+// channelRouteFields parses "type [doctrine group]" command fields.
+func channelRouteFields(fields []string) (ChannelRoute, error) {
+	if len(fields) == 0 {
+		return ChannelRoute{}, errors.Errorf("no message type given, use one of: %s", messageTypeNames())
+	}
+	route := ChannelRoute{
+		MessageType: MessageType(fields[0]),
+		Group:       strings.ToLower(strings.Join(fields[1:], " ")),
+	}
+	return route, route.validate()
+}
+
+func (b *quartermasterBot) channelListMessage() *discordgo.MessageEmbed {
+	routes := b.channelRoutes()
+
+	var parts []string
+	for _, messageType := range messageTypes {
+		channel := "not set, " + defaultChannelText(messageType)
+		if channelID, ok := routes[ChannelRoute{MessageType: messageType}]; ok {
+			channel = fmt.Sprintf("<#%s>", channelID)
+		}
+		parts = append(parts, fmt.Sprintf("**%s**: %s", messageType, channel))
+	}
+	var groups []string
+	for route, channelID := range routes {
+		if route.Group != "" {
+			groups = append(groups, fmt.Sprintf("**%s**: <#%s>", route.String(), channelID))
+		}
+	}
+	sort.Strings(groups)
+	parts = append(parts, groups...)
+
+	return &discordgo.MessageEmbed{
+		Title: ":mailbox: Channels",
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: "https://i.imgur.com/ZwUn8DI.jpg",
+		},
+		Color:       0x00ff00,
+		Description: strings.Join(parts, "\n"),
+		Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+	}
+}
+
+// defaultChannelText describes where messages of the type go without channel.
+func defaultChannelText(messageType MessageType) string {
+	switch messageType {
+	case LowStockMessages:
+		return "sent to the bot channel"
+	case LeaderboardMessages, ErrorMessages:
+		return "sent where the command was typed"
+	}
+	return "not sent"
+}
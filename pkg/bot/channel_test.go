@@ -0,0 +1,109 @@
+package bot
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esifake"
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+)
+
+func TestParseChannelRoute(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  ChannelRoute
+		fail  bool
+	}{
+		{value: "low_stock", want: ChannelRoute{MessageType: LowStockMessages}},
+		{value: "low_stock/Capitals ", want: ChannelRoute{MessageType: LowStockMessages, Group: "capitals"}},
+		{value: "events/heavy tackle", want: ChannelRoute{MessageType: EventMessages, Group: "heavy tackle"}},
+		{value: "errors", want: ChannelRoute{MessageType: ErrorMessages}},
+		{value: "errors/capitals", fail: true},
+		{value: "leaderboard/capitals", fail: true},
+		{value: "unknown", fail: true},
+		{value: "", fail: true},
+	} {
+		got, err := ParseChannelRoute(test.value)
+		if test.fail {
+			if err == nil {
+				t.Errorf("%q: expected error", test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestReportsByChannel(t *testing.T) {
+	routes := channelRoutes{
+		{MessageType: LowStockMessages}:                     "low-stock",
+		{MessageType: LowStockMessages, Group: "capitals"}:  "capitals",
+		{MessageType: ProblematicMessages, Group: "tackle"}: "problematic-tackle",
+	}
+	reports := []doctrineReport{
+		{doctrine: repository.Doctrine{Name: "Svipul", Group: "tackle"}},
+		{doctrine: repository.Doctrine{Name: "Naglfar", Group: "Capitals"}},
+		{doctrine: repository.Doctrine{Name: "Hurricane"}},
+	}
+	b := &quartermasterBot{}
+
+	for _, test := range []struct {
+		name        string
+		messageType MessageType
+		want        map[string][]string
+	}{
+		{
+			name:        "group and type channels",
+			messageType: LowStockMessages,
+			want: map[string][]string{
+				"capitals":  {"Naglfar"},
+				"low-stock": {"Hurricane", "Svipul"},
+			},
+		},
+		{
+			name:        "default channel",
+			messageType: ProblematicMessages,
+			want: map[string][]string{
+				"problematic-tackle": {"Svipul"},
+				"default":            {"Hurricane", "Naglfar"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var got = make(map[string][]string)
+			for channelID, channelReports := range b.reportsByChannel(routes, test.messageType, reports, "default") {
+				for _, report := range channelReports {
+					got[channelID] = append(got[channelID], report.doctrine.Name)
+				}
+				sort.Strings(got[channelID])
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChannelEventSinkWithoutChannel(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+
+	b := newTestBot(t, server, everyone(t))
+	err := channelEventSink{bot: b}.Send([]repository.ContractEvent{
+		{Type: repository.ContractCreated, ContractID: 1, Title: "Svipul", IssuerID: 1001, Doctrine: "Svipul"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	// Nothing is resolved for events that are not posted.
+	if requests := server.Requests(); len(requests) != 0 {
+		t.Errorf("got ESI requests %v, want none", requests)
+	}
+}
@@ -0,0 +1,138 @@
+package bot
+
+import (
+	"sort"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/antihax/goesi/esi"
+)
+
+// inTransitDoctrines returns requirement location -> doctrine name -> count
+// of doctrine ships in outstanding and in progress courier contracts going
+// to the location. Ships are recognised by the doctrine fit, doctrines
+// without a fit are never in transit.
+func (b *quartermasterBot) inTransitDoctrines(
+	requiredDoctrines []repository.Doctrine,
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok,
+	named map[string]map[int64]struct{},
+) map[string]map[string]int {
+	var (
+		out        = make(map[string]map[string]int)
+		doctrines  = fittedDoctrines(requiredDoctrines)
+		byDoctrine = make(map[string]repository.Doctrine)
+	)
+	if len(doctrines) == 0 {
+		return out
+	}
+	for _, doctrine := range doctrines {
+		byDoctrine[doctrine.Name] = doctrine
+	}
+
+	for _, contract := range contracts {
+		if contract.Type_ != string(typeCourier) {
+			continue
+		}
+		switch contract.Status {
+		case string(statusOutstanding):
+			// Nobody will haul expired contracts.
+			if contract.DateExpired.Before(time.Now()) {
+				continue
+			}
+		case string(statusInProgress):
+		default:
+			continue
+		}
+
+		items, err := b.contractItems(contract)
+		if err != nil {
+			b.log.Errorw("error loading courier contract items", "error", err, "contract_id", contract.ContractId)
+			continue
+		}
+		for doctrineName, count := range courierFits(doctrines, items) {
+			location, ok := b.requirementLocation(byDoctrine[doctrineName], contract.EndLocationId, named)
+			if !ok {
+				continue
+			}
+			if out[location] == nil {
+				out[location] = make(map[string]int)
+			}
+			out[location][doctrineName] += count
+		}
+	}
+	return out
+}
+
+// fittedDoctrines returns doctrines that have a fit, the ones with more items
+// first so that smaller fits on the same hull do not take their modules.
+func fittedDoctrines(doctrines []repository.Doctrine) []repository.Doctrine {
+	var out []repository.Doctrine
+	for _, doctrine := range doctrines {
+		if doctrine.Fit != nil {
+			out = append(out, doctrine)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		sizeI, sizeJ := fitSize(*out[i].Fit), fitSize(*out[j].Fit)
+		if sizeI != sizeJ {
+			return sizeI > sizeJ
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+func fitSize(fit repository.Fit) int {
+	var size = 1
+	for _, item := range fit.Items {
+		size += item.Quantity
+	}
+	return size
+}
+
+// courierFits returns doctrine name -> how many complete doctrine fits are
+// in the courier contract items, each item counts toward one fit only.
+func courierFits(
+	doctrines []repository.Doctrine,
+	items []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok,
+) map[string]int {
+	var (
+		out  = make(map[string]int)
+		have = make(map[int32]int)
+	)
+	for _, item := range items {
+		if !item.IsIncluded {
+			continue
+		}
+		have[item.TypeId] += int(item.Quantity)
+	}
+
+	for _, doctrine := range doctrines {
+		var (
+			fit  = *doctrine.Fit
+			want = map[int32]int{fit.Hull.TypeID: 1}
+		)
+		for _, item := range fit.Items {
+			want[item.TypeID] += item.Quantity
+		}
+
+		count := -1
+		for typeID, quantity := range want {
+			if quantity <= 0 {
+				continue
+			}
+			if fits := have[typeID] / quantity; count == -1 || fits < count {
+				count = fits
+			}
+		}
+		if count <= 0 {
+			continue
+		}
+		for typeID, quantity := range want {
+			have[typeID] -= quantity * count
+		}
+		out[doctrine.Name] += count
+	}
+	return out
+}
@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esifake"
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/antihax/goesi/esi"
+)
+
+var (
+	courierHeron = repository.Doctrine{
+		Name:         "Shield Heron",
+		RequireStock: 3,
+		ContractedOn: repository.Corporation,
+		Fit: &repository.Fit{
+			Hull: repository.FitItem{TypeID: 605, Name: "Heron", Quantity: 1},
+			Items: []repository.FitItem{
+				{TypeID: 3841, Name: "Medium Shield Extender II", Quantity: 2},
+			},
+		},
+	}
+	courierBareHeron = repository.Doctrine{
+		Name:         "Bare Heron",
+		RequireStock: 1,
+		ContractedOn: repository.Corporation,
+		Fit: &repository.Fit{
+			Hull: repository.FitItem{TypeID: 605, Name: "Heron", Quantity: 1},
+		},
+	}
+	// Without a fit, ships in the courier can't be recognised.
+	courierSvipul = repository.Doctrine{
+		Name:         "Svipul",
+		RequireStock: 2,
+		ContractedOn: repository.Corporation,
+	}
+)
+
+func courierItem(typeID int32, quantity int32, included bool) esi.GetCorporationsCorporationIdContractsContractIdItems200Ok {
+	return esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+		TypeId:     typeID,
+		Quantity:   quantity,
+		IsIncluded: included,
+	}
+}
+
+func TestCourierFits(t *testing.T) {
+	tests := []struct {
+		name      string
+		doctrines []repository.Doctrine
+		items     []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok
+		want      map[string]int
+	}{
+		{
+			name:      "complete fits",
+			doctrines: []repository.Doctrine{courierHeron},
+			items: []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+				courierItem(605, 2, true),
+				courierItem(3841, 5, true),
+			},
+			want: map[string]int{"Shield Heron": 2},
+		},
+		{
+			name:      "missing module",
+			doctrines: []repository.Doctrine{courierHeron},
+			items: []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+				courierItem(605, 1, true),
+				courierItem(3841, 1, true),
+			},
+			want: map[string]int{},
+		},
+		{
+			name:      "requested items are not in the courier",
+			doctrines: []repository.Doctrine{courierHeron},
+			items: []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+				courierItem(605, 1, false),
+				courierItem(3841, 2, true),
+			},
+			want: map[string]int{},
+		},
+		{
+			name:      "each item counts toward one fit",
+			doctrines: fittedDoctrines([]repository.Doctrine{courierBareHeron, courierHeron}),
+			items: []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+				courierItem(605, 3, true),
+				courierItem(3841, 4, true),
+			},
+			want: map[string]int{"Shield Heron": 2, "Bare Heron": 1},
+		},
+		{
+			name:      "no fitted doctrines",
+			doctrines: fittedDoctrines([]repository.Doctrine{courierSvipul}),
+			items: []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+				courierItem(605, 1, true),
+			},
+			want: map[string]int{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := courierFits(test.doctrines, test.items)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestInTransitDoctrines(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+
+	courier := func(id int32, status contractStatus) esi.GetCorporationsCorporationIdContracts200Ok {
+		contract := testContract(id, "")
+		contract.Type_ = string(typeCourier)
+		contract.Status = string(status)
+		contract.EndLocationId = 60003760
+		return contract
+	}
+	expired := courier(3, statusOutstanding)
+	expired.DateExpired = time.Now().Add(-time.Hour)
+	contracts := []esi.GetCorporationsCorporationIdContracts200Ok{
+		courier(1, statusOutstanding),
+		courier(2, statusInProgress),
+		expired,
+		courier(4, statusFinished),
+		// Same items, but not a courier.
+		testContract(5, ""),
+	}
+	heronItems := []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+		courierItem(605, 1, true),
+		courierItem(3841, 2, true),
+	}
+	for _, contract := range contracts {
+		server.SetContractItems(contract.ContractId, heronItems)
+	}
+	// Svipul hull, the doctrine has no fit to recognise it.
+	server.SetContractItems(6, []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+		courierItem(34562, 1, true),
+	})
+	contracts = append(contracts, courier(6, statusOutstanding))
+
+	b := newTestBot(t, server, everyone(t))
+	got := b.inTransitDoctrines([]repository.Doctrine{courierHeron, courierSvipul}, contracts, nil)
+	want := map[string]map[string]int{defaultLocation: {"Shield Heron": 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Doctrines without a fit are never in transit.
+	got = b.inTransitDoctrines([]repository.Doctrine{courierSvipul}, contracts, nil)
+	if len(got) != 0 {
+		t.Errorf("got %v in transit without fits, want none", got)
+	}
+}
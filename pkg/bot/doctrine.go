@@ -0,0 +1,356 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+// doctrineHandler will be called every time a new
+// message is created on any channel that the autenticated bot has access to.
+func (b *quartermasterBot) doctrineHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if strings.HasPrefix(m.Content, "!doctrine fit") {
+		b.log.Infow("Responding to !doctrine fit command", "channel_id", m.ChannelID)
+		// Format is (strips ```):
+		// !doctrine fit Doctrine name
+		// [Heron, Shield Heron]
+		// ...
+		commandContent := strings.TrimPrefix(m.Content, "!doctrine fit")
+		commandContent = strings.ReplaceAll(commandContent, "```", "")
+		parts := strings.SplitN(strings.TrimSpace(commandContent), "\n", 2)
+		if len(parts) != 2 {
+			msg := "unrecognised !doctrine fit, the format is `!doctrine fit Some doctrine` followed by EFT fit on the next line"
+			_, err := b.discord.ChannelMessageSend(m.ChannelID, msg)
+			if err != nil {
+				b.log.Errorw("error responding to unknown !doctrine fit", "error", err)
+			}
+			return
+		}
+		doctrineName := strings.TrimSpace(parts[0])
+
+		doctrine, err := b.repository.Get(doctrineName)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				err = errors.Errorf("doctrine `%s` not found, add it with `!require` first", doctrineName)
+			}
+			b.log.Errorw("error loading doctrine data", "error", err, "doctrine_name", doctrineName)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		fit, err := parseEFT(parts[1])
+		if err != nil {
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		fit, err = b.resolveFitTypes(fit)
+		if err != nil {
+			b.log.Errorw("error resolving fit types", "error", err, "doctrine_name", doctrineName)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		doctrine.Fit = &fit
+		err = b.repository.Set(doctrineName, doctrine)
+		if err != nil {
+			b.log.Errorw("error saving doctrine data", "error", err, "doctrine_name", doctrineName)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+		if err != nil {
+			b.log.Errorw("error reacting with :+1:", "error", err)
+			return
+		}
+		return
+	}
+
+	if m.Content == "!doctrine sync" {
+		b.log.Infow("Responding to !doctrine sync command", "channel_id", m.ChannelID)
+
+		diff, err := SyncFittings(b.ctx, b.contractSource, b.universe, b.tokenSource, b.repository)
+		if err != nil {
+			b.log.Errorw("error syncing fittings", "error", err)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		for _, message := range fittingsDiffMessage(diff) {
+			_, err = b.discord.ChannelMessageSendEmbed(m.ChannelID, message)
+			if err != nil {
+				b.log.Errorw("error sending message for !doctrine sync", "error", err)
+			}
+		}
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!doctrine match") {
+		b.log.Infow("Responding to !doctrine match command", "channel_id", m.ChannelID)
+		// Format is: "!doctrine match Doctrine name = strategy [threshold|pattern]", example: "!doctrine match Heron = fuzzy 0.9"
+		commandContent := strings.TrimSpace(strings.TrimPrefix(m.Content, "!doctrine match"))
+		doctrineName, value, ok := splitAssignment(commandContent)
+		if !ok {
+			msg := fmt.Sprintf("unrecognised !doctrine match `%s`, the format is `!doctrine match Some doctrine = default|exact|tokens|fuzzy 0.9|regex pattern`", commandContent)
+			_, err := b.discord.ChannelMessageSend(m.ChannelID, msg)
+			if err != nil {
+				b.log.Errorw("error responding to unknown !doctrine match", "error", err)
+			}
+			return
+		}
+
+		doctrine, err := b.repository.Get(doctrineName)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				err = errors.Errorf("doctrine `%s` not found", doctrineName)
+			}
+			b.log.Errorw("error loading doctrine data", "error", err, "doctrine_name", doctrineName)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		strategy, err := parseMatchStrategy(value)
+		if err != nil {
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		doctrine.Match = strategy
+		err = b.repository.Set(doctrineName, doctrine)
+		if err != nil {
+			b.log.Errorw("error saving doctrine data", "error", err, "doctrine_name", doctrineName)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+		if err != nil {
+			b.log.Errorw("error reacting with :+1:", "error", err)
+			return
+		}
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!doctrine location") {
+		b.log.Infow("Responding to !doctrine location command", "channel_id", m.ChannelID)
+		// Format is: "!doctrine location Doctrine name = ID [ID...]|default", example: "!doctrine location Heron = 1022734985679"
+		commandContent := strings.TrimSpace(strings.TrimPrefix(m.Content, "!doctrine location"))
+		doctrineName, value, ok := splitAssignment(commandContent)
+		if !ok {
+			msg := fmt.Sprintf("unrecognised !doctrine location `%s`, the format is `!doctrine location Some doctrine = ID [ID...]|default`", commandContent)
+			_, err := b.discord.ChannelMessageSend(m.ChannelID, msg)
+			if err != nil {
+				b.log.Errorw("error responding to unknown !doctrine location", "error", err)
+			}
+			return
+		}
+
+		doctrine, err := b.repository.Get(doctrineName)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				err = errors.Errorf("doctrine `%s` not found", doctrineName)
+			}
+			b.log.Errorw("error loading doctrine data", "error", err, "doctrine_name", doctrineName)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		var locations []int64
+		if value != "default" {
+			locations, err = parseLocations(value)
+			if err != nil {
+				b.sendError(err, m.ChannelID)
+				return
+			}
+		}
+		doctrine.Locations = locations
+		err = b.repository.Set(doctrineName, doctrine)
+		if err != nil {
+			b.log.Errorw("error saving doctrine data", "error", err, "doctrine_name", doctrineName)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+		if err != nil {
+			b.log.Errorw("error reacting with :+1:", "error", err)
+			return
+		}
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!doctrine group") {
+		b.log.Infow("Responding to !doctrine group command", "channel_id", m.ChannelID)
+		// Format is: "!doctrine group Doctrine name = group|none", example: "!doctrine group Revelation = capitals"
+		commandContent := strings.TrimSpace(strings.TrimPrefix(m.Content, "!doctrine group"))
+		doctrineName, value, ok := splitAssignment(commandContent)
+		if !ok {
+			msg := fmt.Sprintf("unrecognised !doctrine group `%s`, the format is `!doctrine group Some doctrine = group|none`", commandContent)
+			_, err := b.discord.ChannelMessageSend(m.ChannelID, msg)
+			if err != nil {
+				b.log.Errorw("error responding to unknown !doctrine group", "error", err)
+			}
+			return
+		}
+
+		doctrine, err := b.repository.Get(doctrineName)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				err = errors.Errorf("doctrine `%s` not found", doctrineName)
+			}
+			b.log.Errorw("error loading doctrine data", "error", err, "doctrine_name", doctrineName)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		doctrine.Group = value
+		if value == "none" {
+			doctrine.Group = ""
+		}
+		err = b.repository.Set(doctrineName, doctrine)
+		if err != nil {
+			b.log.Errorw("error saving doctrine data", "error", err, "doctrine_name", doctrineName)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+		if err != nil {
+			b.log.Errorw("error reacting with :+1:", "error", err)
+			return
+		}
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!doctrine show") {
+		b.log.Infow("Responding to !doctrine show command", "channel_id", m.ChannelID)
+		doctrineName := strings.TrimSpace(strings.TrimPrefix(m.Content, "!doctrine show"))
+
+		doctrine, err := b.repository.Get(doctrineName)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				err = errors.Errorf("doctrine `%s` not found", doctrineName)
+			}
+			b.log.Errorw("error loading doctrine data", "error", err, "doctrine_name", doctrineName)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		_, err = b.discord.ChannelMessageSendEmbed(m.ChannelID, b.doctrineShowMessage(doctrine))
+		if err != nil {
+			b.log.Errorw("error sending message for !doctrine show", "error", err)
+		}
+		return
+	}
+}
+
+// parseMatchStrategy parses "strategy [threshold|pattern]", default strategy
+// returns nil so the global strategy is used.
+func parseMatchStrategy(value string) (*repository.MatchStrategy, error) {
+	var (
+		parts    = strings.SplitN(value, " ", 2)
+		strategy = repository.MatchStrategy{Strategy: strings.ToLower(parts[0])}
+	)
+	switch strategy.Strategy {
+	case MatchDefault:
+		return nil, nil
+	case MatchFuzzy:
+		strategy.Threshold = defaultFuzzyThreshold
+		if len(parts) == 2 {
+			threshold, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid fuzzy threshold: %s", parts[1])
+			}
+			strategy.Threshold = threshold
+		}
+	case MatchRegex:
+		if len(parts) != 2 {
+			return nil, errors.New("regex strategy requires a pattern")
+		}
+		strategy.Pattern = parts[1]
+	}
+
+	// Validate the strategy before it is saved.
+	_, err := NewMatcher(strategy.Strategy, strategy.Threshold, strategy.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &strategy, nil
+}
+
+func (b *quartermasterBot) doctrineShowMessage(doctrine repository.Doctrine) *discordgo.MessageEmbed {
+	description := fmt.Sprintf("No fit saved yet, use `!doctrine fit %s` to add one.", doctrine.Name)
+	if doctrine.Fit != nil {
+		description = fmt.Sprintf("```\n%s\n```", doctrine.Fit.EFT)
+	}
+	if len(doctrine.Aliases) != 0 {
+		description += fmt.Sprintf("\nAliases: **%s**", strings.Join(doctrine.Aliases, "**, **"))
+	}
+	if doctrine.Match != nil {
+		description += fmt.Sprintf("\nMatching: **%s**", doctrine.Match.Strategy)
+		switch doctrine.Match.Strategy {
+		case MatchFuzzy:
+			description += fmt.Sprintf(" %.2f", doctrine.Match.Threshold)
+		case MatchRegex:
+			description += fmt.Sprintf(" `%s`", doctrine.Match.Pattern)
+		}
+	}
+	if len(doctrine.Locations) != 0 {
+		var locations []string
+		for _, location := range doctrine.Locations {
+			locations = append(locations, b.locationName(location))
+		}
+		description += fmt.Sprintf("\nLocations: **%s**", strings.Join(locations, "**, **"))
+	}
+
+	return &discordgo.MessageEmbed{
+		Title: fmt.Sprintf(":wrench: %s", doctrine.Name),
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: "https://i.imgur.com/ZwUn8DI.jpg",
+		},
+		Color:       0x00ff00,
+		Description: description,
+		Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+	}
+}
+
+func fittingsDiffMessage(diff FittingsDiff) []*discordgo.MessageEmbed {
+	var parts []string
+	for _, name := range diff.Added {
+		parts = append(parts, fmt.Sprintf(":new: **%s** added", name))
+	}
+	for _, name := range diff.Changed {
+		parts = append(parts, fmt.Sprintf(":wrench: **%s** changed", name))
+	}
+	for _, name := range diff.Removed {
+		parts = append(parts, fmt.Sprintf(":wastebasket: **%s** removed in-game, remove it with `!require 0`", name))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "All doctrines are up to date with in-game fittings.")
+	}
+
+	var messages []*discordgo.MessageEmbed
+	for _, message := range splitMessageParts(parts, discordMaxDescriptionLength) {
+		messages = append(messages, &discordgo.MessageEmbed{
+			Title: ":arrows_counterclockwise: Doctrines synced with fittings",
+			Thumbnail: &discordgo.MessageEmbedThumbnail{
+				URL: "https://i.imgur.com/ZwUn8DI.jpg",
+			},
+			Color:       0x00ff00,
+			Description: message,
+			Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+		})
+	}
+	return messages
+}
@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	eftHeaderRegex   = regexp.MustCompile(`^\[(?P<hull>[^,\]]+),\s*(?P<name>[^\]]*)\]$`)
+	eftQuantityRegex = regexp.MustCompile(`^(?P<name>.+?)\s+x(?P<quantity>[0-9]+)$`)
+)
+
+// parseEFT parses fit in EFT format (as exported from the game) into
+// fit with type names only, type IDs have to be resolved separately.
+// Format is:
+//
+//	[Heron, Shield Heron]
+//	Nanofiber Internal Structure II
+//	[Empty Low slot]
+//
+//	Light Missile Launcher II, Scourge Light Missile
+//
+//	Hobgoblin II x5
+func parseEFT(input string) (repository.Fit, error) {
+	var (
+		fit     repository.Fit
+		indexes = make(map[string]int)
+		lines   = strings.Split(strings.TrimSpace(input), "\n")
+	)
+
+	header := strings.TrimSpace(lines[0])
+	matches := eftHeaderRegex.FindStringSubmatch(header)
+	if len(matches) != 3 {
+		return fit, errors.Errorf("unrecognised EFT header `%s`, the format is `[Hull, Fit name]`", header)
+	}
+	fit.Hull = repository.FitItem{
+		Name:     strings.TrimSpace(matches[1]),
+		Quantity: 1,
+	}
+
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		// Skip section separators and empty slots.
+		if line == "" || strings.HasPrefix(line, "[") {
+			continue
+		}
+		line = strings.TrimSuffix(line, "/OFFLINE")
+
+		var (
+			name     = line
+			quantity = 1
+		)
+		// Loaded charges are not compared, EFT does not say how many are
+		// loaded. They are extra items of the contract, see fitMismatch.
+		if i := strings.Index(name, ","); i != -1 {
+			name = name[:i]
+		}
+		matches := eftQuantityRegex.FindStringSubmatch(name)
+		if len(matches) == 3 {
+			name = matches[1]
+			// It should be impossible to fail since we match with regex [0-9]
+			quantity, _ = strconv.Atoi(matches[2])
+		}
+		name = strings.TrimSpace(name)
+
+		i, ok := indexes[name]
+		if ok {
+			fit.Items[i].Quantity += quantity
+			continue
+		}
+		indexes[name] = len(fit.Items)
+		fit.Items = append(fit.Items, repository.FitItem{
+			Name:     name,
+			Quantity: quantity,
+		})
+	}
+
+	fit.EFT = strings.TrimSpace(input)
+	return fit, nil
+}
+
+// resolveFitTypes fills in type IDs of the fit items by their names.
+func (b *quartermasterBot) resolveFitTypes(fit repository.Fit) (repository.Fit, error) {
+	var names = []string{fit.Hull.Name}
+	for _, item := range fit.Items {
+		names = append(names, item.Name)
+	}
+
+	ids, err := b.universe.IDs(b.ctx, names)
+	if err != nil {
+		return fit, errors.Wrap(err, "error resolving type names")
+	}
+	var typeIDs = make(map[string]int32)
+	for _, inventoryType := range ids.InventoryTypes {
+		typeIDs[strings.ToLower(inventoryType.Name)] = inventoryType.Id
+	}
+
+	var unknown []string
+	resolve := func(item repository.FitItem) repository.FitItem {
+		typeID, ok := typeIDs[strings.ToLower(item.Name)]
+		if !ok {
+			unknown = append(unknown, item.Name)
+		}
+		item.TypeID = typeID
+		return item
+	}
+
+	fit.Hull = resolve(fit.Hull)
+	for i, item := range fit.Items {
+		fit.Items[i] = resolve(item)
+	}
+	if len(unknown) != 0 {
+		return fit, errors.Errorf("unknown items: %s", strings.Join(unknown, ", "))
+	}
+	return fit, nil
+}
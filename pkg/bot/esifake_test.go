@@ -0,0 +1,299 @@
+package bot
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esifake"
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+	"github.com/lunemec/eve-quartermaster/pkg/token"
+
+	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
+	"go.uber.org/zap"
+)
+
+// newTestBot returns bot built by NewQuartermasterBot reading ESI from the
+// fake server, with the doctrines required.
+func newTestBot(
+	t *testing.T,
+	server *esifake.Server,
+	issuerPolicy IssuerPolicy,
+	doctrines ...repository.Doctrine,
+) *quartermasterBot {
+	t.Helper()
+
+	repo, err := repository.NewBBoltRepository(filepath.Join(t.TempDir(), "repository.db"))
+	if err != nil {
+		t.Fatalf("unable to open repository: %+v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	for _, doctrine := range doctrines {
+		err = repo.Set(doctrine.Name, doctrine)
+		if err != nil {
+			t.Fatalf("unable to set doctrine %s: %+v", doctrine.Name, err)
+		}
+	}
+
+	matcher, err := NewMatcher(MatchDefault, 0, "")
+	if err != nil {
+		t.Fatalf("unable to create matcher: %+v", err)
+	}
+	client := goesi.NewAPIClient(http.DefaultClient, "quartermaster-test")
+	client.ESI.ChangeBasePath(server.URL)
+	source := NewESISource(client)
+
+	return NewQuartermasterBot(
+		zap.NewNop().Sugar(),
+		client,
+		source,
+		source,
+		token.NewStaticSource("esifake", "Test Character"),
+		nil,
+		"",
+		MessageRoute{},
+		nil,
+		testCorporationID,
+		0,
+		nil,
+		repo,
+		matcher,
+		nil,
+		issuerPolicy,
+		false,
+		nil,
+		4,
+		time.Minute,
+		time.Hour,
+	).(*quartermasterBot)
+}
+
+func testContract(id int32, title string) esi.GetCorporationsCorporationIdContracts200Ok {
+	return esi.GetCorporationsCorporationIdContracts200Ok{
+		ContractId:          id,
+		Title:               title,
+		Type_:               string(typeItemExchange),
+		Status:              string(statusOutstanding),
+		AssigneeId:          testCorporationID,
+		IssuerId:            1001,
+		IssuerCorporationId: testCorporationID,
+		Price:               1000000,
+		DateIssued:          time.Now().Add(-time.Hour),
+		DateExpired:         time.Now().Add(24 * time.Hour),
+	}
+}
+
+func everyone(t *testing.T) IssuerPolicy {
+	t.Helper()
+	policy, err := NewIssuerPolicy(IssuerEveryone, nil)
+	if err != nil {
+		t.Fatalf("unable to create issuer policy: %+v", err)
+	}
+	return policy
+}
+
+func TestReportMissingMatchesContracts(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	// Contracts are on 3 pages.
+	server.SetPageSize(2)
+	server.SetContracts(testCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{
+		testContract(1, "Svipul"),
+		testContract(2, "svipul shield"),
+		testContract(3, "Hurricane"),
+		testContract(4, "Random junk"),
+		testContract(5, "* Svipul price tracking"),
+	})
+
+	b := newTestBot(t, server, everyone(t),
+		repository.Doctrine{Name: "Svipul", RequireStock: 3, ContractedOn: repository.Corporation},
+		repository.Doctrine{Name: "Hurricane", RequireStock: 1, ContractedOn: repository.Corporation},
+		repository.Doctrine{Name: "Ferox", RequireStock: 2, ContractedOn: repository.Corporation},
+		// Kept for its fit, nothing is required.
+		repository.Doctrine{Name: "Heron", ContractedOn: repository.Corporation, Fit: &repository.Fit{EFT: "[Heron, Heron]"}},
+	)
+	missing, _, allOnContract, err := b.reportMissing()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if allOnContract {
+		t.Fatalf("expected missing doctrines")
+	}
+	var got = make(map[string]int)
+	for _, report := range missing {
+		got[report.doctrine.Name] = report.haveInStock
+	}
+	want := map[string]int{"Svipul": 2, "Ferox": 0}
+	if len(got) != len(want) {
+		t.Fatalf("got missing doctrines %v, want %v", got, want)
+	}
+	for name, stock := range want {
+		if got[name] != stock {
+			t.Errorf("doctrine %s: got %d in stock, want %d", name, got[name], stock)
+		}
+	}
+}
+
+func TestReportFullAlerts(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	cheap := testContract(1, "Svipul")
+	cheap.Price = 100
+	untrusted := testContract(2, "Svipul")
+	untrusted.IssuerId = 2002
+	server.SetContracts(testCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{
+		cheap,
+		untrusted,
+		testContract(3, "Svipul"),
+	})
+	server.SetMembers(testCorporationID, []int32{1001})
+
+	policy, err := NewIssuerPolicy(IssuerMembers, nil)
+	if err != nil {
+		t.Fatalf("unable to create issuer policy: %+v", err)
+	}
+	b := newTestBot(t, server, policy, repository.Doctrine{
+		Name:         "Svipul",
+		RequireStock: 3,
+		ContractedOn: repository.Corporation,
+		Price:        repository.DoctrinePrice{Buy: 500},
+	})
+	report, err := b.reportFull()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	var got = make(map[string]int32)
+	for _, alert := range report.alerts {
+		got[alert.Reason] = alert.Contract.ContractId
+	}
+	want := map[string]int32{"Price": 1, "Untrusted issuer": 2}
+	if len(got) != len(want) {
+		t.Fatalf("got alerts %v, want %v", got, want)
+	}
+	for reason, contractID := range want {
+		if got[reason] != contractID {
+			t.Errorf("alert %s: got contract %d, want %d", reason, got[reason], contractID)
+		}
+	}
+	// Untrusted issuer does not count toward stock.
+	if len(report.corporationDoctrines) != 1 || report.corporationDoctrines[0].haveInStock != 2 {
+		t.Errorf("got doctrine reports %+v, want Svipul with 2 in stock", report.corporationDoctrines)
+	}
+}
+
+func TestContractsFailingESI(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetPageSize(2)
+	server.SetContracts(testCorporationID, testContracts(5))
+
+	b := newTestBot(t, server, everyone(t),
+		repository.Doctrine{Name: "Contract 1", RequireStock: 1, ContractedOn: repository.Corporation},
+	)
+	server.FailNext(fmt.Sprintf("/v1/corporations/%d/contracts/", testCorporationID), http.StatusServiceUnavailable, 1)
+	_, _, _, err := b.reportMissing()
+	if err == nil {
+		t.Fatalf("expected error when ESI fails")
+	}
+
+	// Failed fetch is not cached, the next check reads the contracts.
+	snapshot, err := b.contracts()
+	if err != nil {
+		t.Fatalf("unexpected error after ESI recovered: %+v", err)
+	}
+	if len(snapshot.contracts) != 5 {
+		t.Fatalf("got %d contracts, want 5", len(snapshot.contracts))
+	}
+}
+
+func TestTrustedIssuersOfAllianceMembers(t *testing.T) {
+	const memberCorporationID = 98000002
+
+	server := esifake.New()
+	defer server.Close()
+	fromMember := testContract(1, "Svipul")
+	fromMember.IssuerId = 3003
+	fromMember.IssuerCorporationId = memberCorporationID
+	fromStranger := testContract(2, "Svipul")
+	fromStranger.IssuerId = 4004
+	fromStranger.IssuerCorporationId = 98000003
+	server.SetContracts(testCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{
+		fromMember,
+		fromStranger,
+		testContract(3, "Svipul"),
+	})
+	server.SetMembers(testCorporationID, []int32{1001})
+
+	policy, err := NewIssuerPolicy(IssuerMembers, nil)
+	if err != nil {
+		t.Fatalf("unable to create issuer policy: %+v", err)
+	}
+	b := newTestBot(t, server, policy)
+	b.allianceMembers = []AllianceMember{{
+		CorporationID: memberCorporationID,
+		TokenSource:   token.NewStaticSource("esifake", "Member Character"),
+	}}
+
+	trusted, err := b.trustedIssuers()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	for _, test := range []struct {
+		contract  esi.GetCorporationsCorporationIdContracts200Ok
+		untrusted bool
+	}{
+		{contract: testContract(3, "Svipul"), untrusted: false},
+		{contract: fromMember, untrusted: false},
+		{contract: fromStranger, untrusted: true},
+	} {
+		if got := isUntrustedIssuer(trusted, test.contract); got != test.untrusted {
+			t.Errorf("contract %d: got untrusted %t, want %t", test.contract.ContractId, got, test.untrusted)
+		}
+	}
+}
+
+func TestTrustedIssuersCached(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetMembers(testCorporationID, []int32{1001})
+
+	policy, err := NewIssuerPolicy(IssuerMembers, nil)
+	if err != nil {
+		t.Fatalf("unable to create issuer policy: %+v", err)
+	}
+	b := newTestBot(t, server, policy)
+	membersRequests := func() int {
+		var count int
+		for _, request := range server.Requests() {
+			if strings.Contains(request, fmt.Sprintf("/corporations/%d/members/", testCorporationID)) {
+				count++
+			}
+		}
+		return count
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = b.trustedIssuers()
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+	}
+	if got := membersRequests(); got != 1 {
+		t.Fatalf("got %d corporation members requests, want 1", got)
+	}
+
+	// Members are read again when the cache expires.
+	b.issuersFetched = time.Now().Add(-issuersTTL)
+	_, err = b.trustedIssuers()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if got := membersRequests(); got != 2 {
+		t.Fatalf("got %d corporation members requests after expiry, want 2", got)
+	}
+}
@@ -0,0 +1,333 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/antihax/goesi/esi"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+// EventSink receives contract lifecycle events found between contract
+// snapshots, for example to post them to a log channel.
+type EventSink interface {
+	Send(events []repository.ContractEvent) error
+}
+
+// contractEvents diffs contract ledger before and after update and returns
+// what happened to the contracts in between. Expired contracts are only
+// found when the previous update time is known.
+func contractEvents(
+	previous, current []repository.Contract,
+	previousUpdate, now time.Time,
+) []repository.ContractEvent {
+	// Everything would be new in the first snapshot.
+	if len(previous) == 0 {
+		return nil
+	}
+
+	var (
+		events       []repository.ContractEvent
+		previousByID = make(map[int32]repository.Contract)
+		newEvent     = func(eventType repository.ContractEventType, contract repository.Contract) repository.ContractEvent {
+			return repository.ContractEvent{
+				Type:       eventType,
+				Timestamp:  now,
+				ContractID: contract.ContractID,
+				Title:      contract.Title,
+				IssuerID:   contract.IssuerID,
+				AcceptorID: contract.AcceptorID,
+				Price:      contract.Price,
+			}
+		}
+	)
+	for _, contract := range previous {
+		previousByID[contract.ContractID] = contract
+	}
+
+	for _, contract := range current {
+		old, ok := previousByID[contract.ContractID]
+		if !ok {
+			if contract.Status == string(statusOutstanding) {
+				events = append(events, newEvent(repository.ContractCreated, contract))
+			}
+			continue
+		}
+
+		if old.Status != contract.Status {
+			switch contract.Status {
+			case string(statusInProgress), string(statusFinished), string(statusFinishedIssuer), string(statusFinishedContractor):
+				if old.Status == string(statusOutstanding) {
+					events = append(events, newEvent(repository.ContractAccepted, contract))
+				}
+			case string(statusDeleted), string(statusCancelled):
+				events = append(events, newEvent(repository.ContractDeleted, contract))
+			}
+		}
+		if old.Price != contract.Price {
+			event := newEvent(repository.PriceChanged, contract)
+			event.OldPrice = old.Price
+			events = append(events, event)
+		}
+		if contract.Status == string(statusOutstanding) && !previousUpdate.IsZero() &&
+			contract.DateExpired.After(previousUpdate) && !contract.DateExpired.After(now) {
+			events = append(events, newEvent(repository.ContractExpired, contract))
+		}
+	}
+	return events
+}
+
+// publishEvents adds doctrine stock to the recorded events that were not
+// published yet and sends them to all event sinks. Time of the last published
+// event is saved, so events are not lost or sent again after restart.
+func (b *quartermasterBot) publishEvents(contracts []esi.GetCorporationsCorporationIdContracts200Ok) {
+	b.publishLock.Lock()
+	defer b.publishLock.Unlock()
+
+	lastPublished, err := b.repository.LastPublished()
+	if err != nil {
+		b.log.Errorw("error reading last published contract event", "error", err)
+		return
+	}
+	events, err := b.repository.SeekEvents(lastPublished, time.Now())
+	if err != nil {
+		b.log.Errorw("error reading contract events to publish", "error", err)
+		return
+	}
+	events = eventsAfter(events, lastPublished)
+	if len(events) == 0 {
+		return
+	}
+
+	doctrines, err := b.repository.ReadAll()
+	if err != nil {
+		b.log.Errorw("error reading doctrines for contract events", "error", err)
+	}
+	doctrines = filterRequired(doctrines)
+	namedLocations, err := b.namedLocations()
+	if err != nil {
+		b.log.Errorw("error reading named locations for contract events", "error", err)
+	}
+	stock, err := b.doctrineStock(doctrines, contracts, namedLocations)
+	if err != nil {
+		b.log.Errorw("error counting doctrine stock for contract events", "error", err)
+	}
+	var contractsByID = make(map[int32]esi.GetCorporationsCorporationIdContracts200Ok)
+	for _, contract := range contracts {
+		contractsByID[contract.ContractId] = contract
+	}
+	for i, event := range events {
+		// Price-tracking contracts are not doctrine stock.
+		if strings.HasPrefix(event.Title, "*") {
+			continue
+		}
+		doctrine, _, ok := b.bestDoctrine(doctrines, event.Title)
+		if !ok {
+			continue
+		}
+		// Contracts at named location count toward its own requirement.
+		location := defaultLocation
+		if contract, ok := contractsByID[event.ContractID]; ok {
+			if contractLocation, ok := b.contractLocation(doctrine, contract, namedLocations); ok {
+				location = contractLocation
+			}
+		}
+		events[i].Doctrine = doctrine.Name
+		events[i].Location = location
+		events[i].InStock = stock[doctrine.Name][location]
+		events[i].Required = doctrine.RequireStock
+		if location != defaultLocation {
+			events[i].Required = doctrine.LocationStock[location]
+		}
+	}
+
+	// Events are saved again with the doctrine stock.
+	err = b.repository.RecordEvents(events)
+	if err != nil {
+		b.log.Errorw("error recording contract events", "error", err)
+	}
+	for _, sink := range b.eventSinks {
+		err = sink.Send(events)
+		if err != nil {
+			b.log.Errorw("error sending contract events", "error", err)
+		}
+	}
+	err = b.repository.SetLastPublished(latestEvent(events))
+	if err != nil {
+		b.log.Errorw("error saving last published contract event", "error", err)
+	}
+}
+
+// eventsAfter returns events newer than the timestamp, the repository seeks
+// events by whole seconds. Without the timestamp only the latest check's
+// events are returned, so the whole history is not sent on the first run.
+func eventsAfter(events []repository.ContractEvent, timestamp time.Time) []repository.ContractEvent {
+	if timestamp.IsZero() && len(events) != 0 {
+		timestamp = latestEvent(events).Add(-time.Nanosecond)
+	}
+	var out []repository.ContractEvent
+	for _, event := range events {
+		if event.Timestamp.After(timestamp) {
+			out = append(out, event)
+		}
+	}
+	return out
+}
+
+// latestEvent returns timestamp of the newest event.
+func latestEvent(events []repository.ContractEvent) time.Time {
+	var latest time.Time
+	for _, event := range events {
+		if event.Timestamp.After(latest) {
+			latest = event.Timestamp
+		}
+	}
+	return latest
+}
+
+// doctrineStock returns doctrine name -> requirement location -> how many
+// are in stock there, counted the same way as in reports.
+func (b *quartermasterBot) doctrineStock(
+	doctrines []repository.Doctrine,
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok,
+	namedLocations map[string]map[int64]struct{},
+) (map[string]map[string]int, error) {
+	var stock = make(map[string]map[string]int)
+
+	trustedIssuers, err := b.trustedIssuers()
+	if err != nil {
+		return stock, errors.Wrap(err, "unable to load trusted contract issuers")
+	}
+
+	corporationContracts, allianceContracts := b.filterAndGroupContracts(
+		contracts,
+		statusOutstanding,
+		typeItemExchange,
+		true,
+	)
+	reports := append(
+		b.doctrinesByLocation(
+			filterDoctrines(doctrines, repository.Corporation),
+			b.filterStockContracts(doctrines, trustedIssuers, corporationContracts),
+			namedLocations,
+			nil,
+			b.fullDoctrines,
+		),
+		b.doctrinesByLocation(
+			filterDoctrines(doctrines, repository.Alliance),
+			b.filterStockContracts(doctrines, trustedIssuers, allianceContracts),
+			namedLocations,
+			nil,
+			b.fullDoctrines,
+		)...,
+	)
+	for _, report := range reports {
+		if stock[report.doctrine.Name] == nil {
+			stock[report.doctrine.Name] = make(map[string]int)
+		}
+		stock[report.doctrine.Name][report.location] = report.haveInStock
+	}
+	return stock, nil
+}
+
+// channelEventSink posts doctrine contract events to the events channel,
+// or to the channel of the doctrine group.
+type channelEventSink struct {
+	bot *quartermasterBot
+}
+
+func (s channelEventSink) Send(events []repository.ContractEvent) error {
+	routes := s.bot.channelRoutes()
+	// Events are not posted anywhere without the channel.
+	if !routes.configured(EventMessages) {
+		return nil
+	}
+	doctrines, err := s.bot.repository.ReadAll()
+	if err != nil {
+		return errors.Wrap(err, "error reading required doctrines")
+	}
+	var groups = make(map[string]string)
+	for _, doctrine := range doctrines {
+		groups[doctrine.Name] = doctrine.Group
+	}
+
+	// Resolve all issuer and acceptor names at once.
+	var ids []int64
+	for _, event := range events {
+		if event.Doctrine == "" {
+			continue
+		}
+		ids = append(ids, int64(event.IssuerID))
+		if event.AcceptorID != 0 {
+			ids = append(ids, int64(event.AcceptorID))
+		}
+	}
+	s.bot.resolveNames(ids)
+
+	var (
+		channels []string
+		parts    = make(map[string][]string)
+	)
+	for _, event := range events {
+		// Only doctrine contracts are interesting in the channel.
+		if event.Doctrine == "" {
+			continue
+		}
+		channelID := routes.channel(EventMessages, groups[event.Doctrine])
+		if channelID == "" {
+			continue
+		}
+		if _, ok := parts[channelID]; !ok {
+			channels = append(channels, channelID)
+		}
+		parts[channelID] = append(parts[channelID], s.bot.eventMessage(event))
+	}
+
+	for _, channelID := range channels {
+		for _, message := range splitMessageParts(parts[channelID], discordMaxDescriptionLength) {
+			_, err := s.bot.discord.ChannelMessageSendEmbed(channelID, &discordgo.MessageEmbed{
+				Title: ":scroll: Doctrine contracts",
+				Thumbnail: &discordgo.MessageEmbedThumbnail{
+					URL: "https://i.imgur.com/ZwUn8DI.jpg",
+				},
+				Color:       0x00ff00,
+				Description: message,
+				Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+			})
+			if err != nil {
+				return errors.Wrap(err, "error sending contract events message")
+			}
+		}
+	}
+	return nil
+}
+
+func (b *quartermasterBot) eventMessage(event repository.ContractEvent) string {
+	left := fmt.Sprintf("%d/%d left", event.InStock, event.Required)
+	if event.Location != defaultLocation {
+		left = fmt.Sprintf("%d/%d left @%s", event.InStock, event.Required, event.Location)
+	}
+	switch event.Type {
+	case repository.ContractCreated:
+		inStock := fmt.Sprintf("%d/%d in stock", event.InStock, event.Required)
+		if event.Location != defaultLocation {
+			inStock = fmt.Sprintf("%d/%d in stock @%s", event.InStock, event.Required, event.Location)
+		}
+		return fmt.Sprintf(":new: **%s** listed by %s for ƶ %.0fM, %s",
+			event.Title, b.idToName(event.IssuerID), event.Price/1000000, inStock)
+	case repository.ContractAccepted:
+		return fmt.Sprintf(":moneybag: **%s** accepted by %s, %s", event.Title, b.idToName(event.AcceptorID), left)
+	case repository.ContractExpired:
+		return fmt.Sprintf(":hourglass: **%s** by %s expired, %s", event.Title, b.idToName(event.IssuerID), left)
+	case repository.ContractDeleted:
+		return fmt.Sprintf(":wastebasket: **%s** by %s deleted, %s", event.Title, b.idToName(event.IssuerID), left)
+	case repository.PriceChanged:
+		return fmt.Sprintf(":chart_with_upwards_trend: **%s** by %s price changed ƶ %.0fM -> ƶ %.0fM",
+			event.Title, b.idToName(event.IssuerID), event.OldPrice/1000000, event.Price/1000000)
+	}
+	return fmt.Sprintf("**%s**: %s", event.Title, event.Type)
+}
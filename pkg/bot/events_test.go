@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esifake"
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/antihax/goesi/esi"
+)
+
+// channelSink sends received events to the channel.
+type channelSink chan []repository.ContractEvent
+
+func (s channelSink) Send(events []repository.ContractEvent) error {
+	s <- events
+	return nil
+}
+
+func TestPublishEventsOnce(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetContracts(testCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{
+		testContract(1, "Svipul"),
+	})
+
+	b := newTestBot(t, server, everyone(t),
+		repository.Doctrine{Name: "Svipul", RequireStock: 3, ContractedOn: repository.Corporation},
+	)
+	sink := make(channelSink, 10)
+	b.eventSinks = []EventSink{sink}
+
+	// First snapshot has no events.
+	_, _, err := b.loadContracts()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	server.SetContracts(testCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{
+		testContract(1, "Svipul"),
+		testContract(2, "Svipul"),
+	})
+	contracts, _, err := b.loadContracts()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	select {
+	case events := <-sink:
+		if len(events) != 1 || events[0].Type != repository.ContractCreated || events[0].ContractID != 2 {
+			t.Fatalf("got events %+v, want contract 2 created", events)
+		}
+		if events[0].Doctrine != "Svipul" || events[0].InStock != 2 || events[0].Required != 3 {
+			t.Errorf("got event %+v, want Svipul with 2 of 3 in stock", events[0])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("events were not published")
+	}
+
+	// Published events are not sent again.
+	b.publishEvents(contracts)
+	select {
+	case events := <-sink:
+		t.Fatalf("events published again: %+v", events)
+	default:
+	}
+	lastPublished, err := b.repository.LastPublished()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if lastPublished.IsZero() {
+		t.Errorf("last published event timestamp was not saved")
+	}
+}
+
+func TestExpiredEventAfterRestart(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	expired := testContract(1, "Svipul")
+	expired.DateExpired = time.Now().Add(-time.Minute)
+	server.SetContracts(testCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{expired})
+
+	b := newTestBot(t, server, everyone(t),
+		repository.Doctrine{Name: "Svipul", RequireStock: 3, ContractedOn: repository.Corporation},
+	)
+	sink := make(channelSink, 10)
+	b.eventSinks = []EventSink{sink}
+
+	// Ledger updated by the bot before restart, while the contract was open.
+	err := b.repository.UpsertContracts([]repository.Contract{contractFromESI(expired)}, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	_, _, err = b.loadContracts()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	select {
+	case events := <-sink:
+		if len(events) != 1 || events[0].Type != repository.ContractExpired || events[0].ContractID != 1 {
+			t.Fatalf("got events %+v, want contract 1 expired", events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("events were not published")
+	}
+}
+
+func TestEventsAfter(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 500, time.UTC)
+	events := []repository.ContractEvent{
+		{ContractID: 1, Timestamp: now.Add(-time.Minute)},
+		{ContractID: 2, Timestamp: now.Add(-time.Nanosecond)},
+		{ContractID: 3, Timestamp: now},
+		{ContractID: 4, Timestamp: now},
+	}
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		want      []int32
+	}{
+		{name: "nothing published, latest check only", want: []int32{3, 4}},
+		{name: "published within the same second", timestamp: now.Add(-time.Nanosecond), want: []int32{3, 4}},
+		{name: "published earlier", timestamp: now.Add(-time.Hour), want: []int32{1, 2, 3, 4}},
+		{name: "all published", timestamp: now},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []int32
+			for _, event := range eventsAfter(events, test.timestamp) {
+				got = append(got, event.ContractID)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishEventsLocationTarget(t *testing.T) {
+	const forwardStation = 60008494
+
+	server := esifake.New()
+	defer server.Close()
+	atForward := func(id int32) esi.GetCorporationsCorporationIdContracts200Ok {
+		contract := testContract(id, "Svipul")
+		contract.StartLocationId = forwardStation
+		return contract
+	}
+	server.SetContracts(testCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{atForward(1)})
+
+	// Svipul is required only at the named location.
+	b := newTestBot(t, server, everyone(t), repository.Doctrine{
+		Name:          "Svipul",
+		ContractedOn:  repository.Corporation,
+		LocationStock: map[string]int{"Forward": 4},
+	})
+	err := b.repository.SetLocation(repository.Location{Name: "Forward", IDs: []int64{forwardStation}})
+	if err != nil {
+		t.Fatalf("unable to set location: %+v", err)
+	}
+	sink := make(channelSink, 10)
+	b.eventSinks = []EventSink{sink}
+
+	_, _, err = b.loadContracts()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	server.SetContracts(testCorporationID, []esi.GetCorporationsCorporationIdContracts200Ok{atForward(1), atForward(2)})
+	_, _, err = b.loadContracts()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	select {
+	case events := <-sink:
+		if len(events) != 1 {
+			t.Fatalf("got events %+v, want contract 2 created", events)
+		}
+		if events[0].Location != "Forward" || events[0].InStock != 2 || events[0].Required != 4 {
+			t.Errorf("got event %+v, want Svipul with 2 of 4 in stock @Forward", events[0])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("events were not published")
+	}
+}
+
+func TestTrackPricesOfLocationDoctrine(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+
+	b := newTestBot(t, server, everyone(t), repository.Doctrine{
+		Name:          "Svipul",
+		ContractedOn:  repository.Corporation,
+		LocationStock: map[string]int{"Forward": 1},
+	})
+	sold := testContract(1, "* Svipul")
+	sold.Status = string(statusFinished)
+	sold.Price = 150000000
+	err := b.trackAndSavePrices([]esi.GetCorporationsCorporationIdContracts200Ok{sold})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	doctrine, err := b.repository.Get("Svipul")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if doctrine.Price.Buy != 150000000 {
+		t.Errorf("got price %d, want 150000000", doctrine.Price.Buy)
+	}
+}
@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/antihax/goesi/esi"
+	"github.com/pkg/errors"
+)
+
+// contractItems returns items included in the contract. Contract items
+// never change, so they are cached for the lifetime of the bot. Contracts
+// of alliance members are read as the member corporation.
+func (b *quartermasterBot) contractItems(contract esi.GetCorporationsCorporationIdContracts200Ok) (
+	[]esi.GetCorporationsCorporationIdContractsContractIdItems200Ok,
+	error,
+) {
+	itemsInterface, ok := b.contractItemsCache.Load(contract.ContractId)
+	if ok {
+		return itemsInterface.([]esi.GetCorporationsCorporationIdContractsContractIdItems200Ok), nil
+	}
+
+	ctx, corporationID := b.ctx, b.corporationID
+	if member, ok := b.allianceMember(contract.IssuerCorporationId); ok {
+		ctx, corporationID = b.memberContext(member), member.CorporationID
+	}
+	items, err := b.contractSource.ContractItems(ctx, corporationID, contract.ContractId)
+	if err != nil {
+		return nil, err
+	}
+	b.contractItemsCache.Store(contract.ContractId, items)
+	return items, nil
+}
+
+// fitMismatch compares doctrine fit with contract items and returns
+// type ID -> quantity of missing items. Items not in the fit (loaded
+// charges, paste, scripts etc.) and more items than the fit has are
+// extras, they do not make the contract mismatch.
+func fitMismatch(
+	fit repository.Fit,
+	items []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok,
+) map[int32]int {
+	var (
+		want    = make(map[int32]int)
+		have    = make(map[int32]int)
+		missing = make(map[int32]int)
+	)
+
+	want[fit.Hull.TypeID]++
+	for _, item := range fit.Items {
+		want[item.TypeID] += item.Quantity
+	}
+	for _, item := range items {
+		// Items not included are items the issuer asks for, not what is being sold.
+		if !item.IsIncluded {
+			continue
+		}
+		have[item.TypeId] += int(item.Quantity)
+	}
+
+	for typeID, wantQuantity := range want {
+		if have[typeID] < wantQuantity {
+			missing[typeID] = wantQuantity - have[typeID]
+		}
+	}
+
+	return missing
+}
+
+// fitMismatchReason returns alert reason if contract contents do not match
+// the doctrine fit. Empty string is returned when contract matches or
+// the doctrine has no fit to compare with.
+func (b *quartermasterBot) fitMismatchReason(
+	doctrine repository.Doctrine,
+	contract esi.GetCorporationsCorporationIdContracts200Ok,
+) (string, error) {
+	if doctrine.Fit == nil {
+		return "", nil
+	}
+	items, err := b.contractItems(contract)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to load contract items")
+	}
+
+	missing := fitMismatch(*doctrine.Fit, items)
+	if len(missing) == 0 {
+		return "", nil
+	}
+
+	var names = make(map[int32]string)
+	names[doctrine.Fit.Hull.TypeID] = doctrine.Fit.Hull.Name
+	for _, item := range doctrine.Fit.Items {
+		names[item.TypeID] = item.Name
+	}
+	typeName := func(typeID int32) string {
+		name, ok := names[typeID]
+		if !ok {
+			return b.idToName(typeID)
+		}
+		return name
+	}
+
+	parts := mismatchParts("missing", missing, typeName)
+	return fmt.Sprintf("Fit mismatch: %s", strings.Join(parts, ", ")), nil
+}
+
+func mismatchParts(prefix string, quantities map[int32]int, typeName func(int32) string) []string {
+	var parts []string
+	for typeID, quantity := range quantities {
+		parts = append(parts, fmt.Sprintf("%s %dx %s", prefix, quantity, typeName(typeID)))
+	}
+	sort.Strings(parts)
+	return parts
+}
+
+// filterFitMismatches removes contracts whose contents do not match fit
+// of the doctrine they are for. Contracts for doctrines without a fit are
+// kept as they are.
+func (b *quartermasterBot) filterFitMismatches(
+	requiredDoctrines []repository.Doctrine,
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok,
+) []esi.GetCorporationsCorporationIdContracts200Ok {
+	var out []esi.GetCorporationsCorporationIdContracts200Ok
+
+	for _, contract := range contracts {
+		// Price-tracking contracts are not counted anyway.
+		if strings.HasPrefix(contract.Title, "*") {
+			out = append(out, contract)
+			continue
+		}
+		requiredDoctrine, _, ok := b.bestDoctrine(requiredDoctrines, contract.Title)
+		if ok {
+			reason, err := b.fitMismatchReason(requiredDoctrine, contract)
+			if err != nil {
+				// Do not punish the contract for ESI errors, count it.
+				b.log.Errorw("error verifying contract fit", "error", err, "contract_id", contract.ContractId)
+			}
+			if reason != "" {
+				continue
+			}
+		}
+		out = append(out, contract)
+	}
+
+	return out
+}
@@ -0,0 +1,159 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/antihax/goesi/esi"
+)
+
+func TestParseEFT(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		input string
+		want  []repository.FitItem
+		fail  bool
+	}{
+		{
+			name: "modules with loaded charges",
+			input: `[Heron, Shield Heron]
+Nanofiber Internal Structure II
+[Empty Low slot]
+
+5MN Microwarpdrive II
+Medium Shield Extender II
+
+Light Missile Launcher II, Scourge Light Missile
+Light Missile Launcher II, Scourge Light Missile
+Core Probe Launcher I, Core Scanner Probe I
+`,
+			want: []repository.FitItem{
+				{Name: "Nanofiber Internal Structure II", Quantity: 1},
+				{Name: "5MN Microwarpdrive II", Quantity: 1},
+				{Name: "Medium Shield Extender II", Quantity: 1},
+				{Name: "Light Missile Launcher II", Quantity: 2},
+				{Name: "Core Probe Launcher I", Quantity: 1},
+			},
+		},
+		{
+			name: "drones and cargo",
+			input: `[Vexor, Drone Vexor]
+Drone Damage Amplifier II /OFFLINE
+
+Hobgoblin II x5
+Hammerhead II x3
+
+Nanite Repair Paste x50
+Optimal Range Script x1
+Hobgoblin II x2
+`,
+			want: []repository.FitItem{
+				{Name: "Drone Damage Amplifier II", Quantity: 1},
+				{Name: "Hobgoblin II", Quantity: 7},
+				{Name: "Hammerhead II", Quantity: 3},
+				{Name: "Nanite Repair Paste", Quantity: 50},
+				{Name: "Optimal Range Script", Quantity: 1},
+			},
+		},
+		{
+			name:  "invalid header",
+			input: "Heron\nNanofiber Internal Structure II",
+			fail:  true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			fit, err := parseEFT(test.input)
+			if test.fail {
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if fit.Hull.Quantity != 1 || fit.Hull.Name == "" {
+				t.Errorf("got hull %+v, want one hull", fit.Hull)
+			}
+			if !reflect.DeepEqual(fit.Items, test.want) {
+				t.Errorf("got items %+v, want %+v", fit.Items, test.want)
+			}
+		})
+	}
+}
+
+func TestFitMismatch(t *testing.T) {
+	const (
+		heron          = 605
+		nanofiber      = 2605
+		launcher       = 2404
+		scourge        = 209
+		paste          = 28668
+		script         = 29009
+		mwd            = 440
+		shieldExtender = 3831
+	)
+	fit := repository.Fit{
+		Hull: repository.FitItem{TypeID: heron, Quantity: 1},
+		Items: []repository.FitItem{
+			{TypeID: nanofiber, Quantity: 1},
+			{TypeID: launcher, Quantity: 2},
+			{TypeID: paste, Quantity: 10},
+		},
+	}
+	item := func(typeID int32, quantity int32) esi.GetCorporationsCorporationIdContractsContractIdItems200Ok {
+		return esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{TypeId: typeID, Quantity: quantity, IsIncluded: true}
+	}
+	exact := []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+		item(heron, 1), item(nanofiber, 1), item(launcher, 2), item(paste, 10),
+	}
+
+	for _, test := range []struct {
+		name    string
+		items   []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok
+		missing map[int32]int
+	}{
+		{
+			name:    "exact",
+			items:   exact,
+			missing: map[int32]int{},
+		},
+		{
+			name:    "loaded charges",
+			items:   append(exact, item(scourge, 80)),
+			missing: map[int32]int{},
+		},
+		{
+			name:    "more paste and scripts",
+			items:   append(exact, item(paste, 40), item(script, 1)),
+			missing: map[int32]int{},
+		},
+		{
+			name:    "extra modules",
+			items:   append(exact, item(mwd, 1), item(launcher, 1)),
+			missing: map[int32]int{},
+		},
+		{
+			name:    "missing modules",
+			items:   append(exact[:2:2], item(launcher, 1), item(shieldExtender, 1)),
+			missing: map[int32]int{launcher: 1, paste: 10},
+		},
+		{
+			name: "items not included are asked for",
+			items: []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok{
+				item(nanofiber, 1), item(launcher, 2), item(paste, 10),
+				{TypeId: heron, Quantity: 1, IsIncluded: false},
+			},
+			missing: map[int32]int{heron: 1},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := fitMismatch(fit, test.items)
+			if !reflect.DeepEqual(got, test.missing) {
+				t.Errorf("got missing %v, want %v", got, test.missing)
+			}
+		})
+	}
+}
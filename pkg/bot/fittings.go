@@ -0,0 +1,238 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+	"github.com/lunemec/eve-quartermaster/pkg/token"
+
+	"github.com/antihax/goesi/esi"
+	"github.com/pkg/errors"
+)
+
+// FittingsDiff is list of doctrine names that were added, changed
+// or removed in-game since last sync.
+type FittingsDiff struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+// eftSections is order in which slots are printed in EFT format, items
+// in sections with quantity are printed as "Item xN".
+var eftSections = []struct {
+	flagPrefix   string
+	withQuantity bool
+}{
+	{"LoSlot", false},
+	{"MedSlot", false},
+	{"HiSlot", false},
+	{"RigSlot", false},
+	{"SubSystemSlot", false},
+	{"ServiceSlot", false},
+	{"DroneBay", true},
+	{"FighterBay", true},
+	{"Cargo", true},
+}
+
+// SyncFittings creates or updates doctrines from personal fittings saved
+// in-game by the character the token belongs to, ESI has no corporation
+// fittings. RequireStock of existing doctrines
+// is kept, doctrines removed in-game are only reported, not deleted.
+func SyncFittings(
+	ctx context.Context,
+	source ContractSource,
+	universe UniverseSource,
+	tokenSource token.Source,
+	doctrines repository.Repository,
+) (FittingsDiff, error) {
+	var diff FittingsDiff
+
+	verify, err := tokenSource.Verify()
+	if err != nil {
+		return diff, errors.Wrap(err, "unable to verify token")
+	}
+	fittings, err := source.Fittings(ctx, verify.CharacterID)
+	if err != nil {
+		return diff, err
+	}
+	typeNames, err := fittingTypeNames(ctx, universe, fittings)
+	if err != nil {
+		return diff, errors.Wrap(err, "unable to resolve fitting type names")
+	}
+
+	savedDoctrines, err := doctrines.ReadAll()
+	if err != nil {
+		return diff, errors.Wrap(err, "error reading saved doctrines")
+	}
+	var (
+		doctrinesByName = make(map[string]int)
+		fittingIDs      = make(map[int32]struct{})
+	)
+	for i, doctrine := range savedDoctrines {
+		doctrinesByName[doctrine.Name] = i
+	}
+
+	for _, fitting := range fittings {
+		fittingIDs[fitting.FittingId] = struct{}{}
+		fit := fittingToFit(fitting, typeNames)
+
+		i, ok := doctrinesByName[fitting.Name]
+		if !ok {
+			// New doctrines have nothing required yet, that is left to !require.
+			doctrinesByName[fitting.Name] = len(savedDoctrines)
+			savedDoctrines = append(savedDoctrines, repository.Doctrine{
+				Name:         fitting.Name,
+				ContractedOn: repository.Corporation,
+				Fit:          &fit,
+			})
+			diff.Added = append(diff.Added, fitting.Name)
+			continue
+		}
+		// Fits pasted by hand keep their EFT when they have the same items.
+		if savedDoctrines[i].Fit != nil && sameFit(*savedDoctrines[i].Fit, fit) {
+			savedDoctrines[i].Fit.FittingID = fit.FittingID
+			continue
+		}
+		diff.Changed = append(diff.Changed, fitting.Name)
+		savedDoctrines[i].Fit = &fit
+	}
+
+	for _, doctrine := range savedDoctrines {
+		if doctrine.Fit == nil || doctrine.Fit.FittingID == 0 {
+			continue
+		}
+		if _, ok := fittingIDs[doctrine.Fit.FittingID]; !ok {
+			diff.Removed = append(diff.Removed, doctrine.Name)
+		}
+	}
+
+	err = doctrines.WriteAll(savedDoctrines)
+	if err != nil {
+		return diff, errors.Wrap(err, "error saving doctrines")
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Removed)
+	return diff, nil
+}
+
+// fittingTypeNames returns type ID -> name of all types used in the fittings.
+func fittingTypeNames(
+	ctx context.Context,
+	universe UniverseSource,
+	fittings []esi.GetCharactersCharacterIdFittings200Ok,
+) (map[int32]string, error) {
+	var (
+		ids   []int32
+		seen  = make(map[int32]struct{})
+		names = make(map[int32]string)
+	)
+	addID := func(id int32) {
+		if _, ok := seen[id]; ok {
+			return
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	for _, fitting := range fittings {
+		addID(fitting.ShipTypeId)
+		for _, item := range fitting.Items {
+			addID(item.TypeId)
+		}
+	}
+	if len(ids) == 0 {
+		return names, nil
+	}
+
+	resolved, err := universe.Names(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range resolved {
+		names[name.Id] = name.Name
+	}
+	return names, nil
+}
+
+// sameFit returns true when both fits have the same hull and the same
+// quantity of each item, regardless of their order in EFT.
+func sameFit(a, b repository.Fit) bool {
+	if a.Hull.TypeID != b.Hull.TypeID {
+		return false
+	}
+	quantities := func(fit repository.Fit) map[int32]int {
+		var out = make(map[int32]int)
+		for _, item := range fit.Items {
+			out[item.TypeID] += item.Quantity
+		}
+		return out
+	}
+	aItems, bItems := quantities(a), quantities(b)
+	if len(aItems) != len(bItems) {
+		return false
+	}
+	for typeID, quantity := range aItems {
+		if bItems[typeID] != quantity {
+			return false
+		}
+	}
+	return true
+}
+
+// fittingToFit converts in-game fitting to doctrine fit, including
+// the EFT representation.
+func fittingToFit(fitting esi.GetCharactersCharacterIdFittings200Ok, typeNames map[int32]string) repository.Fit {
+	var (
+		fit = repository.Fit{
+			FittingID: fitting.FittingId,
+			Hull: repository.FitItem{
+				TypeID:   fitting.ShipTypeId,
+				Name:     typeNames[fitting.ShipTypeId],
+				Quantity: 1,
+			},
+		}
+		indexes  = make(map[int32]int)
+		eftLines = []string{fmt.Sprintf("[%s, %s]", fit.Hull.Name, fitting.Name)}
+	)
+
+	for _, item := range fitting.Items {
+		i, ok := indexes[item.TypeId]
+		if ok {
+			fit.Items[i].Quantity += int(item.Quantity)
+			continue
+		}
+		indexes[item.TypeId] = len(fit.Items)
+		fit.Items = append(fit.Items, repository.FitItem{
+			TypeID:   item.TypeId,
+			Name:     typeNames[item.TypeId],
+			Quantity: int(item.Quantity),
+		})
+	}
+
+	for _, section := range eftSections {
+		var sectionLines []string
+		for _, item := range fitting.Items {
+			if !strings.HasPrefix(item.Flag, section.flagPrefix) {
+				continue
+			}
+			line := typeNames[item.TypeId]
+			if section.withQuantity {
+				line = fmt.Sprintf("%s x%d", line, item.Quantity)
+			}
+			sectionLines = append(sectionLines, line)
+		}
+		if len(sectionLines) == 0 {
+			continue
+		}
+		eftLines = append(eftLines, "")
+		eftLines = append(eftLines, sectionLines...)
+	}
+	fit.EFT = strings.Join(eftLines, "\n")
+
+	return fit
+}
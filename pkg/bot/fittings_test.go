@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esifake"
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+	"github.com/lunemec/eve-quartermaster/pkg/token"
+
+	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
+)
+
+const testCharacterID = 90000001
+
+// characterTokenSource is static token of the character, static token
+// has no character ID.
+type characterTokenSource struct {
+	token.Source
+	characterID int32
+}
+
+func (s characterTokenSource) Verify() (*goesi.VerifyResponse, error) {
+	verify, err := s.Source.Verify()
+	if err != nil {
+		return nil, err
+	}
+	verify.CharacterID = s.characterID
+	return verify, nil
+}
+
+func TestSyncFittings(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+
+	for id, name := range map[int32]string{
+		605:   "Heron",
+		626:   "Vexor",
+		603:   "Merlin",
+		2605:  "Nanofiber Internal Structure II",
+		12076: "5MN Microwarpdrive II",
+		3841:  "Medium Shield Extender II",
+		2456:  "Hobgoblin II",
+		4405:  "Drone Damage Amplifier II",
+	} {
+		server.SetName(id, name, esifake.CategoryInventoryType)
+	}
+	server.SetFittings(testCharacterID, []esi.GetCharactersCharacterIdFittings200Ok{
+		{
+			FittingId:  1,
+			Name:       "Shield Heron",
+			ShipTypeId: 605,
+			Items: []esi.GetCharactersCharacterIdFittingsItem{
+				{TypeId: 2605, Flag: "LoSlot0", Quantity: 1},
+				{TypeId: 12076, Flag: "MedSlot0", Quantity: 1},
+				{TypeId: 3841, Flag: "MedSlot1", Quantity: 1},
+			},
+		},
+		{
+			FittingId:  2,
+			Name:       "Drone Vexor",
+			ShipTypeId: 626,
+			Items: []esi.GetCharactersCharacterIdFittingsItem{
+				{TypeId: 4405, Flag: "LoSlot0", Quantity: 1},
+				{TypeId: 2456, Flag: "DroneBay", Quantity: 5},
+			},
+		},
+		{
+			FittingId:  3,
+			Name:       "New Merlin",
+			ShipTypeId: 603,
+			Items: []esi.GetCharactersCharacterIdFittingsItem{
+				{TypeId: 3841, Flag: "MedSlot0", Quantity: 1},
+			},
+		},
+	})
+
+	// Pasted by hand, in different order and with extra whitespace than
+	// the in-game fitting.
+	heronEFT := `[Heron,   Shield Heron]
+
+Medium Shield Extender II
+  5MN Microwarpdrive II
+
+Nanofiber Internal Structure II
+[Empty Rig slot]
+`
+	heronFit := repository.Fit{
+		EFT:  heronEFT,
+		Hull: repository.FitItem{TypeID: 605, Name: "Heron", Quantity: 1},
+		Items: []repository.FitItem{
+			{TypeID: 3841, Name: "Medium Shield Extender II", Quantity: 1},
+			{TypeID: 12076, Name: "5MN Microwarpdrive II", Quantity: 1},
+			{TypeID: 2605, Name: "Nanofiber Internal Structure II", Quantity: 1},
+		},
+	}
+	repo, err := repository.NewBBoltRepository(filepath.Join(t.TempDir(), "repository.db"))
+	if err != nil {
+		t.Fatalf("unable to open repository: %+v", err)
+	}
+	defer repo.Close()
+	err = repo.WriteAll([]repository.Doctrine{
+		{Name: "Shield Heron", RequireStock: 3, ContractedOn: repository.Corporation, Fit: &heronFit},
+		{
+			Name:         "Drone Vexor",
+			RequireStock: 2,
+			ContractedOn: repository.Corporation,
+			Fit: &repository.Fit{
+				FittingID: 2,
+				Hull:      repository.FitItem{TypeID: 626, Name: "Vexor", Quantity: 1},
+				Items: []repository.FitItem{
+					{TypeID: 4405, Name: "Drone Damage Amplifier II", Quantity: 1},
+					{TypeID: 2456, Name: "Hobgoblin II", Quantity: 3},
+				},
+			},
+		},
+		{
+			Name:         "Old Vexor",
+			RequireStock: 1,
+			ContractedOn: repository.Corporation,
+			Fit: &repository.Fit{
+				FittingID: 99,
+				Hull:      repository.FitItem{TypeID: 626, Name: "Vexor", Quantity: 1},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to save doctrines: %+v", err)
+	}
+
+	client := goesi.NewAPIClient(http.DefaultClient, "quartermaster-test")
+	client.ESI.ChangeBasePath(server.URL)
+	source := NewESISource(client)
+	tokenSource := characterTokenSource{
+		Source:      token.NewStaticSource("esifake", "Test Character"),
+		characterID: testCharacterID,
+	}
+
+	diff, err := SyncFittings(context.Background(), source, source, tokenSource, repo)
+	if err != nil {
+		t.Fatalf("unable to sync fittings: %+v", err)
+	}
+	want := FittingsDiff{
+		Added:   []string{"New Merlin"},
+		Changed: []string{"Drone Vexor"},
+		Removed: []string{"Old Vexor"},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diff = %+v, want %+v", diff, want)
+	}
+
+	heron, err := repo.Get("Shield Heron")
+	if err != nil {
+		t.Fatalf("unable to read Shield Heron: %+v", err)
+	}
+	if heron.Fit.EFT != heronEFT {
+		t.Errorf("EFT pasted by hand was replaced by:\n%s", heron.Fit.EFT)
+	}
+	if heron.Fit.FittingID != 1 {
+		t.Errorf("Shield Heron fitting ID = %d, want 1", heron.Fit.FittingID)
+	}
+	vexor, err := repo.Get("Drone Vexor")
+	if err != nil {
+		t.Fatalf("unable to read Drone Vexor: %+v", err)
+	}
+	if vexor.RequireStock != 2 {
+		t.Errorf("Drone Vexor RequireStock = %d, want 2", vexor.RequireStock)
+	}
+	if len(vexor.Fit.Items) != 2 || vexor.Fit.Items[1].Quantity != 5 {
+		t.Errorf("Drone Vexor fit was not updated: %+v", vexor.Fit.Items)
+	}
+
+	// Nothing changed in-game since the last sync.
+	diff, err = SyncFittings(context.Background(), source, source, tokenSource, repo)
+	if err != nil {
+		t.Fatalf("unable to sync fittings again: %+v", err)
+	}
+	want = FittingsDiff{Removed: []string{"Old Vexor"}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("second diff = %+v, want %+v", diff, want)
+	}
+}
@@ -19,15 +19,35 @@ func (b *quartermasterBot) helpHandler(s *discordgo.Session, m *discordgo.Messag
 		"`!report` or `!qm` - shows a report of missing stock\n" +
 		"`!report full` - shows full report of required doctrines with stock/missing counts\n" +
 		"`!stock` - shows currently available ships on contract\n" +
-		"`!require NN Alliance|Corporation Doctrine name` - require to have `Doctrine name` `NN`" +
-		" times on alliance or corporation contracts at all times (0 to remove)\n" +
+		"`!require NN Alliance|Corporation [@Location] Doctrine name` - require to have `Doctrine name` `NN`" +
+		" times on alliance or corporation contracts at all times (0 to remove), optionally at named location\n" +
 		"`!require list` - list of doctrine ships required to have on contract at all times\n" +
 		"`!parse excel` - parse copy+pasted columns from excel (sheet)\n" +
 		"`!price fetch` - re-check for price contracts, starting with `*`\n" +
 		"`!price set 45000000 Doctrine Name` - set price to 45M for `Doctrine name`\n" +
 		"`!leaderboard` - show leaderboard of haulers who made correct pricing contracts (starting with `*`)\n" +
 		"`!leaderboard 2022-01-01 2022-04-01` - to specify range\n" +
-		"`!migrate v4 v5` - for easier upgrading of doctrines, it is simple string replacement"
+		"`!migrate v4 v5` - for easier upgrading of doctrines, it is simple string replacement\n" +
+		"`!doctrine fit Doctrine name` - save EFT fit pasted on the next lines to `Doctrine name`\n" +
+		"`!doctrine show Doctrine name` - show saved fit of `Doctrine name`\n" +
+		"`!doctrine sync` - create or update doctrines from personal in-game fittings of the character the bot is logged in with, not corporation fittings\n" +
+		"`!doctrine match Doctrine name = fuzzy 0.9` - set how contract titles are matched to `Doctrine name`," +
+		" one of `default`, `exact`, `tokens`, `fuzzy 0.9` or `regex pattern`\n" +
+		"`!doctrine location Doctrine name = ID [ID...]` - count `Doctrine name` contracts only at these stations" +
+		" or structures, `default` to use `--staging_location_id`\n" +
+		"`!location add Name = ID [ID...]` - name stations or structures for `!require` at `@Name`," +
+		" `!location remove Name` and `!location list` to manage them\n" +
+		"`!doctrine group Doctrine name = group` - put `Doctrine name` to `group` for `!channel set`, `none` to remove\n" +
+		"`!alias add|remove Doctrine name = Contract title` - accept `Contract title` as `Doctrine name`\n" +
+		"`!match Contract title` - explain which doctrine `Contract title` counts toward and why\n" +
+		"`!perm set command = @Role [@Role...]` - allow only `@Role` and server administrators to run command changing" +
+		" the doctrines, `everyone` for no limit, `!perm reset command` to use the configuration and `!perm list` to show them\n" +
+		"`!channel set type [group] #channel` - send `low_stock`, `problematic`, `events`, `leaderboard` or `errors`" +
+		" messages to `#channel`, optionally only for doctrines of `group`, `!channel reset type [group]` to use the" +
+		" configuration and `!channel list` to show them\n\n" +
+		"`/require`, `/report`, `/stock`, `/price`, `/leaderboard`, `/migrate`, `/parse-excel`, `/doctrine`, `/alias`," +
+		" `/match`, `/location`, `/perm` and `/channel` slash commands do the same, doctrine names are autocompleted." +
+		" `/parse-excel` and `/doctrine fit` read the text from attached file"
 
 	_, err := b.discord.ChannelMessageSendEmbed(m.ChannelID, &discordgo.MessageEmbed{
 		Title: "Hello, I'm your Quartermaster.",
@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"strings"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+	"github.com/pkg/errors"
+)
+
+// Issuer policies, deciding whose contracts count toward stock.
+const (
+	// IssuerEveryone trusts contracts issued by anyone.
+	IssuerEveryone = "everyone"
+	// IssuerMembers trusts contracts issued by current corporation members,
+	// members of alliance member corporations and characters on the allow list.
+	IssuerMembers = "members"
+	// IssuerAllowList trusts contracts issued by characters on the allow list only.
+	IssuerAllowList = "allowlist"
+)
+
+// IssuerPolicy decides whose contracts count toward stock.
+type IssuerPolicy struct {
+	policy    string
+	allowList map[int32]struct{}
+}
+
+// NewIssuerPolicy returns issuer policy, allowIDs are character IDs that are
+// trusted by members and allowlist policies.
+func NewIssuerPolicy(policy string, allowIDs []int32) (IssuerPolicy, error) {
+	switch policy {
+	case IssuerEveryone, "":
+		return IssuerPolicy{policy: IssuerEveryone}, nil
+	case IssuerMembers, IssuerAllowList:
+		allowList := make(map[int32]struct{})
+		for _, id := range allowIDs {
+			allowList[id] = struct{}{}
+		}
+		return IssuerPolicy{policy: policy, allowList: allowList}, nil
+	}
+	return IssuerPolicy{}, errors.Errorf("unknown issuer policy: %s, use one of: %s", policy, strings.Join([]string{
+		IssuerEveryone, IssuerMembers, IssuerAllowList,
+	}, ", "))
+}
+
+// issuersTTL is how long corporation members are trusted before they are
+// read again, ESI caches them for an hour.
+const issuersTTL = time.Hour
+
+// issuerSet is set of trusted contract issuers.
+type issuerSet struct {
+	characters   map[int32]struct{} // Trusted character IDs.
+	corporations map[int32]struct{} // Issuer corporation IDs whose members are all trusted.
+}
+
+// trustedIssuers returns issuers whose contracts count toward stock. Nil
+// means everyone is trusted. Alliance member corporations are trusted by the
+// issuer corporation of the contract, their members are not read. The set is
+// cached for issuersTTL and shared, it must not be modified.
+func (b *quartermasterBot) trustedIssuers() (*issuerSet, error) {
+	switch b.issuerPolicy.policy {
+	case IssuerMembers:
+		b.issuersLock.Lock()
+		defer b.issuersLock.Unlock()
+		if b.issuers != nil && time.Since(b.issuersFetched) < issuersTTL {
+			return b.issuers, nil
+		}
+
+		members, err := b.contractSource.CorporationMembers(b.ctx, b.corporationID)
+		if err != nil {
+			return nil, err
+		}
+		var trusted = &issuerSet{
+			characters:   make(map[int32]struct{}),
+			corporations: make(map[int32]struct{}),
+		}
+		for id := range b.issuerPolicy.allowList {
+			trusted.characters[id] = struct{}{}
+		}
+		for _, id := range members {
+			trusted.characters[id] = struct{}{}
+		}
+		// Alliance members' contracts are issued by their own members.
+		for _, member := range b.allianceMembers {
+			trusted.corporations[member.CorporationID] = struct{}{}
+		}
+		b.issuers = trusted
+		b.issuersFetched = time.Now()
+		return trusted, nil
+	case IssuerAllowList:
+		return &issuerSet{characters: b.issuerPolicy.allowList}, nil
+	}
+	return nil, nil
+}
+
+// isUntrustedIssuer reports whether the contract issuer is not trusted.
+func isUntrustedIssuer(trusted *issuerSet, contract esi.GetCorporationsCorporationIdContracts200Ok) bool {
+	if trusted == nil {
+		return false
+	}
+	if _, ok := trusted.characters[contract.IssuerId]; ok {
+		return false
+	}
+	_, ok := trusted.corporations[contract.IssuerCorporationId]
+	return !ok
+}
+
+// filterUntrustedIssuers removes contracts issued by untrusted characters.
+func filterUntrustedIssuers(
+	trusted *issuerSet,
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok,
+) []esi.GetCorporationsCorporationIdContracts200Ok {
+	var out []esi.GetCorporationsCorporationIdContracts200Ok
+	for _, contract := range contracts {
+		if isUntrustedIssuer(trusted, contract) {
+			continue
+		}
+		out = append(out, contract)
+	}
+	return out
+}
@@ -60,13 +60,24 @@ func (b *quartermasterBot) leaderboard(s *discordgo.Session, m *discordgo.Messag
 		}
 
 		message := b.leaderboardMessage(priceData, dateStart, dateEnd)
-		_, err = b.discord.ChannelMessageSendEmbed(m.ChannelID, message)
+		// Leaderboard can have its own channel.
+		channelID := b.channelRoutes().channel(LeaderboardMessages, "")
+		if channelID == "" {
+			channelID = m.ChannelID
+		}
+		_, err = b.discord.ChannelMessageSendEmbed(channelID, message)
 		if err != nil {
 			b.log.Errorw("error sending message for !leaderboard", "error", err)
 
 			b.sendError(err, m.ChannelID)
 			return
 		}
+		if channelID != m.ChannelID {
+			err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+			if err != nil {
+				b.log.Errorw("error reacting with :+1:", "error", err)
+			}
+		}
 	}
 }
 
@@ -102,6 +113,13 @@ func (b *quartermasterBot) leaderboardMessage(priceData []repository.PriceData,
 		stats = stats[:10]
 	}
 
+	// Resolve all issuer names at once.
+	var issuerIDs []int64
+	for _, stat := range stats {
+		issuerIDs = append(issuerIDs, int64(stat.IssuerID))
+	}
+	b.resolveNames(issuerIDs)
+
 	var msgParts []string
 	for i, stat := range stats {
 		position := i + 1
@@ -0,0 +1,221 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/antihax/goesi/esi"
+	"github.com/pkg/errors"
+)
+
+// esiContractHorizon is how long ESI returns contracts for, older contracts
+// are dropped by ESI even when nothing happened to them.
+const esiContractHorizon = 30 * 24 * time.Hour
+
+// loadContracts fetches contracts from EVE ESI, saves them to the contract
+// ledger and returns open and recent contracts from the ledger, including
+// the ones ESI no longer returns, and when ESI data expires. Changes of the
+// contracts are published as events. Use contracts() to read the shared
+// snapshot.
+func (b *quartermasterBot) loadContracts() (
+	[]esi.GetCorporationsCorporationIdContracts200Ok,
+	time.Time,
+	error,
+) {
+	b.ledgerLock.Lock()
+	defer b.ledgerLock.Unlock()
+
+	esiContracts, expires, err := b.fetchContracts()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	previousContracts, err := b.repository.ReadAllContracts()
+	if err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "error reading contract ledger")
+	}
+	// Expired contracts are found since the last update, also before restart.
+	previousUpdate, err := b.repository.LedgerUpdated()
+	if err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "error reading contract ledger update time")
+	}
+	esiContracts = mergeContracts(esiContracts, b.fetchAllianceContracts(previousContracts))
+	now := time.Now()
+	err = b.updateLedger(esiContracts, previousContracts, now)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "unable to update contract ledger")
+	}
+
+	ledgerContracts, err := b.repository.ReadAllContracts()
+	if err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "error reading contract ledger")
+	}
+	// Reports need only last month, the ledger keeps everything.
+	var contracts []esi.GetCorporationsCorporationIdContracts200Ok
+	for _, contract := range openOrRecent(ledgerContracts, now) {
+		contracts = append(contracts, contractToESI(contract))
+	}
+
+	events := contractEvents(previousContracts, ledgerContracts, previousUpdate, now)
+	if len(events) != 0 {
+		err = b.repository.RecordEvents(events)
+		if err != nil {
+			b.log.Errorw("error recording contract events", "error", err)
+		}
+		// Sinks call Discord and ESI, the ledger and snapshot are not locked meanwhile.
+		go b.publishEvents(contracts)
+	}
+
+	return contracts, expires, nil
+}
+
+// updateLedger saves contracts to the ledger. ESI returns all outstanding and
+// in progress contracts within its horizon, so ledger contracts still open
+// that ESI no longer returns were deleted in-game, unless they are too old
+// for ESI to return them.
+func (b *quartermasterBot) updateLedger(
+	esiContracts []esi.GetCorporationsCorporationIdContracts200Ok,
+	ledgerContracts []repository.Contract,
+	timestamp time.Time,
+) error {
+	var (
+		contracts []repository.Contract
+		seen      = make(map[int32]struct{})
+	)
+	for _, contract := range esiContracts {
+		seen[contract.ContractId] = struct{}{}
+		contracts = append(contracts, contractFromESI(contract))
+	}
+	for _, contract := range ledgerContracts {
+		if _, ok := seen[contract.ContractID]; ok {
+			continue
+		}
+		if !isOpen(contract) || pastESIHorizon(contract, timestamp) {
+			continue
+		}
+		contract.Status = string(statusDeleted)
+		contracts = append(contracts, contract)
+	}
+
+	return b.repository.UpsertContracts(contracts, timestamp)
+}
+
+// isOpen reports whether the contract can still be accepted or completed.
+func isOpen(contract repository.Contract) bool {
+	return contract.Status == string(statusOutstanding) || contract.Status == string(statusInProgress)
+}
+
+// pastESIHorizon reports whether the contract ended so long ago that ESI no
+// longer returns it. In progress contracts end when the time to complete
+// them runs out.
+func pastESIHorizon(contract repository.Contract, now time.Time) bool {
+	end := contract.DateExpired
+	if contract.Status == string(statusInProgress) {
+		end = contract.DateAccepted.AddDate(0, 0, int(contract.DaysToComplete))
+	}
+	return end.Before(now.Add(-esiContractHorizon))
+}
+
+// openOrRecent returns ledger contracts that are open or changed in the last
+// month, which is what reports and price tracking look at.
+func openOrRecent(contracts []repository.Contract, now time.Time) []repository.Contract {
+	var (
+		out   []repository.Contract
+		since = now.AddDate(0, -1, 0)
+	)
+	for _, contract := range contracts {
+		if isOpen(contract) && !pastESIHorizon(contract, now) {
+			out = append(out, contract)
+			continue
+		}
+		if lastActivity(contract).After(since) {
+			out = append(out, contract)
+		}
+	}
+	return out
+}
+
+// lastActivity returns when anything last happened to the contract.
+func lastActivity(contract repository.Contract) time.Time {
+	last := contract.DateIssued
+	for _, date := range []time.Time{contract.DateAccepted, contract.DateCompleted} {
+		if date.After(last) {
+			last = date
+		}
+	}
+	if len(contract.StatusHistory) != 0 {
+		changed := contract.StatusHistory[len(contract.StatusHistory)-1].Timestamp
+		if changed.After(last) {
+			last = changed
+		}
+	}
+	return last
+}
+
+func contractFromESI(contract esi.GetCorporationsCorporationIdContracts200Ok) repository.Contract {
+	return repository.Contract{
+		ContractID:          contract.ContractId,
+		Type:                contract.Type_,
+		Status:              contract.Status,
+		Title:               contract.Title,
+		Availability:        contract.Availability,
+		ForCorporation:      contract.ForCorporation,
+		IssuerID:            contract.IssuerId,
+		IssuerCorporationID: contract.IssuerCorporationId,
+		AssigneeID:          contract.AssigneeId,
+		AcceptorID:          contract.AcceptorId,
+		StartLocationID:     contract.StartLocationId,
+		EndLocationID:       contract.EndLocationId,
+		Price:               contract.Price,
+		Reward:              contract.Reward,
+		Collateral:          contract.Collateral,
+		Buyout:              contract.Buyout,
+		Volume:              contract.Volume,
+		DaysToComplete:      contract.DaysToComplete,
+		DateIssued:          contract.DateIssued,
+		DateExpired:         contract.DateExpired,
+		DateAccepted:        contract.DateAccepted,
+		DateCompleted:       contract.DateCompleted,
+	}
+}
+
+func contractToESI(contract repository.Contract) esi.GetCorporationsCorporationIdContracts200Ok {
+	return esi.GetCorporationsCorporationIdContracts200Ok{
+		ContractId:          contract.ContractID,
+		Type_:               contract.Type,
+		Status:              contract.Status,
+		Title:               contract.Title,
+		Availability:        contract.Availability,
+		ForCorporation:      contract.ForCorporation,
+		IssuerId:            contract.IssuerID,
+		IssuerCorporationId: contract.IssuerCorporationID,
+		AssigneeId:          contract.AssigneeID,
+		AcceptorId:          contract.AcceptorID,
+		StartLocationId:     contract.StartLocationID,
+		EndLocationId:       contract.EndLocationID,
+		Price:               contract.Price,
+		Reward:              contract.Reward,
+		Collateral:          contract.Collateral,
+		Buyout:              contract.Buyout,
+		Volume:              contract.Volume,
+		DaysToComplete:      contract.DaysToComplete,
+		DateIssued:          contract.DateIssued,
+		DateExpired:         contract.DateExpired,
+		DateAccepted:        contract.DateAccepted,
+		DateCompleted:       contract.DateCompleted,
+	}
+}
+
+// completedSince returns contracts completed after the time.
+func completedSince(
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok,
+	since time.Time,
+) []esi.GetCorporationsCorporationIdContracts200Ok {
+	var out []esi.GetCorporationsCorporationIdContracts200Ok
+	for _, contract := range contracts {
+		if contract.DateCompleted.After(since) {
+			out = append(out, contract)
+		}
+	}
+	return out
+}
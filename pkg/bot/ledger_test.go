@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+)
+
+func TestPastESIHorizon(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		contract repository.Contract
+		want     bool
+	}{
+		{
+			name:     "outstanding, expires in future",
+			contract: repository.Contract{Status: string(statusOutstanding), DateExpired: now.AddDate(0, 0, 7)},
+			want:     false,
+		},
+		{
+			name:     "outstanding, expired within horizon",
+			contract: repository.Contract{Status: string(statusOutstanding), DateExpired: now.AddDate(0, 0, -20)},
+			want:     false,
+		},
+		{
+			name:     "outstanding, expired before horizon",
+			contract: repository.Contract{Status: string(statusOutstanding), DateExpired: now.AddDate(0, 0, -31)},
+			want:     true,
+		},
+		{
+			name: "in progress, time to complete within horizon",
+			contract: repository.Contract{
+				Status:         string(statusInProgress),
+				DateExpired:    now.AddDate(0, 0, -40),
+				DateAccepted:   now.AddDate(0, 0, -35),
+				DaysToComplete: 7,
+			},
+			want: false,
+		},
+		{
+			name: "in progress, time to complete before horizon",
+			contract: repository.Contract{
+				Status:         string(statusInProgress),
+				DateAccepted:   now.AddDate(0, 0, -40),
+				DaysToComplete: 3,
+			},
+			want: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := pastESIHorizon(test.contract, now)
+			if got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOpenOrRecent(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	contracts := []repository.Contract{
+		{ContractID: 1, Status: string(statusOutstanding), DateIssued: now.AddDate(0, 0, -60), DateExpired: now.AddDate(0, 0, 30)},
+		{ContractID: 2, Status: string(statusFinished), DateIssued: now.AddDate(0, 0, -20), DateCompleted: now.AddDate(0, 0, -10)},
+		{ContractID: 3, Status: string(statusFinished), DateIssued: now.AddDate(0, -3, 0), DateCompleted: now.AddDate(0, -2, 0)},
+		{ContractID: 4, Status: string(statusDeleted), DateIssued: now.AddDate(0, -2, 0), StatusHistory: []repository.ContractStatus{
+			{Status: string(statusOutstanding), Timestamp: now.AddDate(0, -2, 0)},
+			{Status: string(statusDeleted), Timestamp: now.AddDate(0, 0, -1)},
+		}},
+		{ContractID: 5, Status: string(statusDeleted), DateIssued: now.AddDate(0, -4, 0), StatusHistory: []repository.ContractStatus{
+			{Status: string(statusDeleted), Timestamp: now.AddDate(0, -3, 0)},
+		}},
+		{ContractID: 6, Status: string(statusOutstanding), DateIssued: now.AddDate(0, -6, 0), DateExpired: now.AddDate(0, -5, 0)},
+	}
+
+	var got []int32
+	for _, contract := range openOrRecent(contracts, now) {
+		got = append(got, contract.ContractID)
+	}
+	want := []int32{1, 2, 4}
+	if len(got) != len(want) {
+		t.Fatalf("got contracts %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got contracts %v, want %v", got, want)
+		}
+	}
+}
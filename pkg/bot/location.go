@@ -0,0 +1,347 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/antihax/goesi/esi"
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+// locationHandler will be called every time a new
+// message is created on any channel that the autenticated bot has access to.
+func (b *quartermasterBot) locationHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Content == "!location list" {
+		b.log.Infow("Responding to !location list command", "channel_id", m.ChannelID)
+		locations, err := b.repository.ReadAllLocations()
+		if err != nil {
+			b.log.Errorw("error reading named locations", "error", err)
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		for _, message := range b.locationListMessage(locations) {
+			_, err = b.discord.ChannelMessageSendEmbed(m.ChannelID, message)
+			if err != nil {
+				b.log.Errorw("error sending message for !location list", "error", err)
+			}
+		}
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!location add ") {
+		b.log.Infow("Responding to !location add command", "channel_id", m.ChannelID)
+		// Format is: "!location add Name = ID [ID...]", example: "!location add Forward = 1022734985679"
+		commandContent := strings.TrimSpace(strings.TrimPrefix(m.Content, "!location add "))
+		name, value, ok := splitAssignment(commandContent)
+		if !ok || strings.ContainsAny(name, " \t") {
+			msg := fmt.Sprintf("unrecognised !location add `%s`, the format is `!location add Name = ID [ID...]`, name can't contain spaces", commandContent)
+			_, err := b.discord.ChannelMessageSend(m.ChannelID, msg)
+			if err != nil {
+				b.log.Errorw("error responding to unknown !location add", "error", err)
+			}
+			return
+		}
+		ids, err := parseLocations(value)
+		if err != nil {
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		err = b.repository.SetLocation(repository.Location{Name: name, IDs: ids})
+		if err != nil {
+			b.log.Errorw("error saving named location", "error", err, "location", name)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+		if err != nil {
+			b.log.Errorw("error reacting with :+1:", "error", err)
+			return
+		}
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!location remove ") {
+		b.log.Infow("Responding to !location remove command", "channel_id", m.ChannelID)
+		name := strings.TrimSpace(strings.TrimPrefix(m.Content, "!location remove "))
+
+		doctrines, err := b.repository.ReadAll()
+		if err != nil {
+			b.log.Errorw("error reading required doctrines", "error", err)
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		var requiredBy []string
+		for _, doctrine := range doctrines {
+			if _, ok := doctrine.LocationStock[name]; ok {
+				requiredBy = append(requiredBy, doctrine.Name)
+			}
+		}
+		if len(requiredBy) != 0 {
+			err = errors.Errorf("location `%s` is required by: %s, remove the requirements with `!require 0` first", name, strings.Join(requiredBy, ", "))
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		err = b.repository.DeleteLocation(name)
+		if err != nil {
+			if errors.Is(err, repository.ErrLocationNotFound) {
+				err = errors.Errorf("location `%s` not found", name)
+			}
+			b.log.Errorw("error removing named location", "error", err, "location", name)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+
+		err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+		if err != nil {
+			b.log.Errorw("error reacting with :+1:", "error", err)
+			return
+		}
+		return
+	}
+}
+
+func (b *quartermasterBot) locationListMessage(locations []repository.Location) []*discordgo.MessageEmbed {
+	var parts []string
+	for _, location := range locations {
+		var names []string
+		for _, id := range location.IDs {
+			names = append(names, b.locationName(id))
+		}
+		parts = append(parts, fmt.Sprintf("**@%s**: %s", location.Name, strings.Join(names, ", ")))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "No named locations yet, add one with `!location add Name = ID [ID...]`.")
+	}
+
+	var messages []*discordgo.MessageEmbed
+	for _, message := range splitMessageParts(parts, discordMaxDescriptionLength) {
+		messages = append(messages, &discordgo.MessageEmbed{
+			Title: ":round_pushpin: Named locations",
+			Thumbnail: &discordgo.MessageEmbedThumbnail{
+				URL: "https://i.imgur.com/ZwUn8DI.jpg",
+			},
+			Color:       0x00ff00,
+			Description: message,
+			Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+		})
+	}
+	return messages
+}
+
+// allowedLocations returns set of location IDs where doctrine contracts
+// count toward stock. Nil means contracts count from anywhere.
+func (b *quartermasterBot) allowedLocations(doctrine repository.Doctrine) map[int64]struct{} {
+	locations := doctrine.Locations
+	if len(locations) == 0 {
+		locations = b.stagingLocations
+	}
+	if len(locations) == 0 {
+		return nil
+	}
+
+	var out = make(map[int64]struct{})
+	for _, location := range locations {
+		out[location] = struct{}{}
+	}
+	return out
+}
+
+// defaultLocation is requirement location of doctrine RequireStock, which
+// is at the doctrine staging locations.
+const defaultLocation = ""
+
+// isWrongLocation reports whether the location is where the doctrine
+// contracts do not count toward stock.
+func (b *quartermasterBot) isWrongLocation(doctrine repository.Doctrine, locationID int64) bool {
+	allowed := b.allowedLocations(doctrine)
+	if allowed == nil {
+		return false
+	}
+	_, ok := allowed[locationID]
+	return !ok
+}
+
+// namedLocations returns named location -> set of its location IDs.
+func (b *quartermasterBot) namedLocations() (map[string]map[int64]struct{}, error) {
+	locations, err := b.repository.ReadAllLocations()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading named locations")
+	}
+
+	var out = make(map[string]map[int64]struct{})
+	for _, location := range locations {
+		ids := make(map[int64]struct{})
+		for _, id := range location.IDs {
+			ids[id] = struct{}{}
+		}
+		out[location.Name] = ids
+	}
+	return out, nil
+}
+
+// contractLocation returns requirement location the contract counts toward
+// for the doctrine, false if the contract is at location with no requirement.
+func (b *quartermasterBot) contractLocation(
+	doctrine repository.Doctrine,
+	contract esi.GetCorporationsCorporationIdContracts200Ok,
+	named map[string]map[int64]struct{},
+) (string, bool) {
+	return b.requirementLocation(doctrine, contract.StartLocationId, named)
+}
+
+// requirementLocation returns requirement location of the doctrine the location
+// ID belongs to, false if the doctrine has no requirement there.
+func (b *quartermasterBot) requirementLocation(
+	doctrine repository.Doctrine,
+	locationID int64,
+	named map[string]map[int64]struct{},
+) (string, bool) {
+	for _, location := range sortedLocations(doctrine.LocationStock) {
+		if _, ok := named[location][locationID]; ok {
+			return location, true
+		}
+	}
+	if b.isWrongLocation(doctrine, locationID) {
+		return "", false
+	}
+	return defaultLocation, true
+}
+
+// groupByLocation groups contracts by requirement location they count toward,
+// contracts at locations with no requirement are left out.
+func (b *quartermasterBot) groupByLocation(
+	requiredDoctrines []repository.Doctrine,
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok,
+	named map[string]map[int64]struct{},
+) map[string][]esi.GetCorporationsCorporationIdContracts200Ok {
+	var out = make(map[string][]esi.GetCorporationsCorporationIdContracts200Ok)
+	for _, contract := range contracts {
+		location := defaultLocation
+		requiredDoctrine, _, ok := b.bestDoctrine(requiredDoctrines, contract.Title)
+		if ok {
+			location, ok = b.contractLocation(requiredDoctrine, contract, named)
+			if !ok {
+				continue
+			}
+		}
+		out[location] = append(out[location], contract)
+	}
+	return out
+}
+
+// requirementLocations returns default location and all named locations
+// the doctrines have requirements at.
+func requirementLocations(doctrines []repository.Doctrine) []string {
+	var locations = make(map[string]int)
+	for _, doctrine := range doctrines {
+		for location := range doctrine.LocationStock {
+			locations[location]++
+		}
+	}
+	return append([]string{defaultLocation}, sortedLocations(locations)...)
+}
+
+// requiredAt returns doctrines with RequireStock of what is required at the location.
+func requiredAt(doctrines []repository.Doctrine, location string) []repository.Doctrine {
+	if location == defaultLocation {
+		return doctrines
+	}
+	var out []repository.Doctrine
+	for _, doctrine := range doctrines {
+		doctrine.RequireStock = doctrine.LocationStock[location]
+		out = append(out, doctrine)
+	}
+	return out
+}
+
+// doctrinesByLocation returns doctrine reports created by the report function
+// for each requirement location, with ships in transit to the location and
+// corporations that supplied the stock.
+func (b *quartermasterBot) doctrinesByLocation(
+	requiredDoctrines []repository.Doctrine,
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok,
+	named map[string]map[int64]struct{},
+	inTransit map[string]map[string]int,
+	report func([]repository.Doctrine, map[string]int) []doctrineReport,
+) []doctrineReport {
+	var (
+		out     []doctrineReport
+		grouped = b.groupByLocation(requiredDoctrines, contracts, named)
+	)
+	for _, location := range requirementLocations(requiredDoctrines) {
+		var (
+			required  = requiredAt(requiredDoctrines, location)
+			reports   = report(required, doctrinesAvailable(grouped[location]))
+			suppliers = b.doctrineSuppliers(required, grouped[location])
+		)
+		for _, doctrineReport := range reports {
+			// Doctrines required only at named locations have nothing to report
+			// at the default location.
+			if doctrineReport.doctrine.RequireStock == 0 &&
+				(location != defaultLocation || len(doctrineReport.doctrine.LocationStock) != 0) {
+				continue
+			}
+			doctrineReport.location = location
+			doctrineReport.inTransit = inTransit[location][doctrineReport.doctrine.Name]
+			doctrineReport.suppliers = suppliers[doctrineReport.doctrine.Name]
+			out = append(out, doctrineReport)
+		}
+	}
+	return out
+}
+
+// groupReportsByLocation returns locations in order and doctrine reports for each of them.
+func groupReportsByLocation(reports []doctrineReport) ([]string, map[string][]doctrineReport) {
+	var (
+		locations []string
+		grouped   = make(map[string][]doctrineReport)
+	)
+	for _, report := range reports {
+		if _, ok := grouped[report.location]; !ok {
+			locations = append(locations, report.location)
+		}
+		grouped[report.location] = append(grouped[report.location], report)
+	}
+	return locations, grouped
+}
+
+// locationTitle returns message title with contract target and location, for
+// example "Doctrines full report [Alliance @Forward]".
+func locationTitle(title, contractedOn, location string) string {
+	if location == defaultLocation {
+		return fmt.Sprintf("%s [%s]", title, contractedOn)
+	}
+	return fmt.Sprintf("%s [%s @%s]", title, contractedOn, location)
+}
+
+func sortedLocations(locations map[string]int) []string {
+	var out []string
+	for location := range locations {
+		out = append(out, location)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// parseLocations parses space separated list of location IDs.
+func parseLocations(input string) ([]int64, error) {
+	var locations []int64
+	for _, field := range strings.Fields(input) {
+		location, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid location ID: %s", field)
+		}
+		locations = append(locations, location)
+	}
+	return locations, nil
+}
@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// How many non-matching doctrines to show as candidates.
+const maxMatchCandidates = 10
+
+type matchCandidate struct {
+	doctrine   repository.Doctrine
+	wordsFound int
+	wordsTotal int
+	similarity float64
+	strategy   string
+	matched    bool
+	aliasMatch bool
+}
+
+// matchHandler will be called every time a new
+// message is created on any channel that the autenticated bot has access to.
+func (b *quartermasterBot) matchHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if !strings.HasPrefix(m.Content, "!match ") {
+		return
+	}
+	b.log.Infow("Responding to !match command", "channel_id", m.ChannelID, "msg", m.Content)
+	title := strings.TrimSpace(strings.TrimPrefix(m.Content, "!match "))
+
+	requiredDoctrines, err := b.repository.ReadAll()
+	if err != nil {
+		b.log.Errorw("error reading required doctrines", "error", err)
+		b.sendError(err, m.ChannelID)
+		return
+	}
+	requiredDoctrines = filterRequired(requiredDoctrines)
+	if len(requiredDoctrines) == 0 {
+		b.sendNoDoctrinesAddedMessage(m)
+		return
+	}
+
+	best, _, _ := b.bestDoctrine(requiredDoctrines, title)
+	for _, message := range matchMessage(title, best.Name, b.matchCandidates(requiredDoctrines, title)) {
+		_, err = b.discord.ChannelMessageSendEmbed(m.ChannelID, message)
+		if err != nil {
+			b.log.Errorw("error sending message for !match", "error", err)
+		}
+	}
+}
+
+// matchCandidates explains how contract title is matched against each of the doctrines.
+func (b *quartermasterBot) matchCandidates(doctrines []repository.Doctrine, title string) []matchCandidate {
+	var candidates []matchCandidate
+	for _, doctrine := range doctrines {
+		found, total := tokenOverlap(doctrine.Name, title)
+		candidate := matchCandidate{
+			doctrine:   doctrine,
+			wordsFound: found,
+			wordsTotal: total,
+			similarity: jaccardSimilarity(doctrine.Name, title),
+			strategy:   b.matchStrategyName(doctrine),
+			matched:    b.matchDoctrine(doctrine, title),
+		}
+		for _, alias := range doctrine.Aliases {
+			if strings.EqualFold(strings.TrimSpace(alias), strings.TrimSpace(title)) {
+				candidate.aliasMatch = true
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].matched != candidates[j].matched {
+			return candidates[i].matched
+		}
+		if candidates[i].similarity != candidates[j].similarity {
+			return candidates[i].similarity > candidates[j].similarity
+		}
+		return candidates[i].doctrine.Name < candidates[j].doctrine.Name
+	})
+	return candidates
+}
+
+// matchStrategyName returns human readable matching strategy of the doctrine.
+func (b *quartermasterBot) matchStrategyName(doctrine repository.Doctrine) string {
+	strategy := "global"
+	if doctrine.Match != nil {
+		strategy = doctrine.Match.Strategy
+		switch doctrine.Match.Strategy {
+		case MatchFuzzy:
+			strategy = fmt.Sprintf("%s %.2f", strategy, doctrine.Match.Threshold)
+		case MatchRegex:
+			strategy = fmt.Sprintf("%s `%s`", strategy, doctrine.Match.Pattern)
+		}
+	}
+	return strategy
+}
+
+func matchMessage(title string, countsToward string, candidates []matchCandidate) []*discordgo.MessageEmbed {
+	var (
+		parts          []string
+		alsoMatches    []string
+		shownUnmatched int
+		msgCandidate   = "%s **%s** - words %d/%d, jaccard %.2f, strategy %s%s"
+	)
+
+	for _, candidate := range candidates {
+		icon := ":x:"
+		switch {
+		case candidate.doctrine.Name == countsToward:
+			icon = ":white_check_mark:"
+		case candidate.matched:
+			icon = ":warning:"
+			alsoMatches = append(alsoMatches, candidate.doctrine.Name)
+		default:
+			if shownUnmatched >= maxMatchCandidates {
+				continue
+			}
+			shownUnmatched++
+		}
+		alias := ""
+		if candidate.aliasMatch {
+			alias = ", matched by alias"
+		}
+		parts = append(parts, fmt.Sprintf(msgCandidate,
+			icon,
+			candidate.doctrine.Name,
+			candidate.wordsFound,
+			candidate.wordsTotal,
+			candidate.similarity,
+			candidate.strategy,
+			alias,
+		))
+	}
+
+	summary := "Counts toward: **nothing**, this contract is not counted as stock."
+	if countsToward != "" {
+		summary = fmt.Sprintf("Counts toward: **%s**", countsToward)
+	}
+	if len(alsoMatches) != 0 {
+		summary += fmt.Sprintf("\nAmbiguous, also matches: **%s**", strings.Join(alsoMatches, "**, **"))
+	}
+	parts = append([]string{summary, ""}, parts...)
+
+	var messages []*discordgo.MessageEmbed
+	for _, message := range splitMessageParts(parts, discordMaxDescriptionLength) {
+		messages = append(messages, &discordgo.MessageEmbed{
+			Title: fmt.Sprintf(":mag: Matching \"%s\"", title),
+			Thumbnail: &discordgo.MessageEmbedThumbnail{
+				URL: "https://i.imgur.com/ZwUn8DI.jpg",
+			},
+			Color:       0x00ff00,
+			Description: message,
+			Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+		})
+	}
+	return messages
+}
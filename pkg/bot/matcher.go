@@ -0,0 +1,270 @@
+package bot
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/adrg/strutil"
+	"github.com/adrg/strutil/metrics"
+	"github.com/pkg/errors"
+)
+
+// Matcher decides if contract title is for given doctrine.
+type Matcher interface {
+	Match(doctrineName, title string) bool
+}
+
+// Contract title matching strategies.
+const (
+	// MatchDefault matches when all words of doctrine name are in the title,
+	// or when the names are at least 0.8 similar.
+	MatchDefault = "default"
+	// MatchExact matches only identical names (case insensitive).
+	MatchExact = "exact"
+	// MatchTokens matches when all words of doctrine name are in the title.
+	MatchTokens = "tokens"
+	// MatchFuzzy matches when Jaccard similarity is at least the threshold.
+	MatchFuzzy = "fuzzy"
+	// MatchRegex matches when the title matches the pattern, "{doctrine}"
+	// in the pattern is replaced by the escaped doctrine name.
+	MatchRegex = "regex"
+)
+
+const defaultFuzzyThreshold = 0.8
+
+// NewMatcher returns contract title matcher for given strategy. Threshold is
+// only used by fuzzy matching and pattern only by regex matching.
+func NewMatcher(strategy string, threshold float64, pattern string) (Matcher, error) {
+	switch strategy {
+	case MatchDefault, "":
+		return anyMatcher{tokenSubsetMatcher{}, fuzzyMatcher{threshold: defaultFuzzyThreshold}}, nil
+	case MatchExact:
+		return exactMatcher{}, nil
+	case MatchTokens:
+		return tokenSubsetMatcher{}, nil
+	case MatchFuzzy:
+		if threshold <= 0 || threshold > 1 {
+			return nil, errors.Errorf("fuzzy threshold must be between 0 and 1, got: %v", threshold)
+		}
+		return fuzzyMatcher{threshold: threshold}, nil
+	case MatchRegex:
+		// Validate the pattern here so we don't fail on every match.
+		re, err := regexp.Compile(strings.ReplaceAll(pattern, "{doctrine}", ""))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid regex pattern: %s", pattern)
+		}
+		if !strings.Contains(pattern, "{doctrine}") {
+			return regexMatcher{pattern: pattern, re: re}, nil
+		}
+		return regexMatcher{pattern: pattern, doctrines: new(sync.Map)}, nil
+	}
+	return nil, errors.Errorf("unknown matching strategy: %s, use one of: %s", strategy, strings.Join([]string{
+		MatchDefault, MatchExact, MatchTokens, MatchFuzzy, MatchRegex,
+	}, ", "))
+}
+
+type exactMatcher struct{}
+
+func (exactMatcher) Match(doctrineName, title string) bool {
+	return strings.EqualFold(strings.TrimSpace(doctrineName), strings.TrimSpace(title))
+}
+
+type tokenSubsetMatcher struct{}
+
+func (tokenSubsetMatcher) Match(doctrineName, title string) bool {
+	found, total := tokenOverlap(doctrineName, title)
+	return total > 0 && found == total
+}
+
+type fuzzyMatcher struct {
+	threshold float64
+}
+
+func (m fuzzyMatcher) Match(doctrineName, title string) bool {
+	return jaccardSimilarity(doctrineName, title) >= m.threshold
+}
+
+type regexMatcher struct {
+	pattern string
+	// compiled pattern without "{doctrine}".
+	re *regexp.Regexp
+	// doctrine name -> compiled pattern with the doctrine name.
+	doctrines *sync.Map
+}
+
+func (m regexMatcher) Match(doctrineName, title string) bool {
+	if m.re != nil {
+		return m.re.MatchString(title)
+	}
+	re, ok := m.doctrines.Load(doctrineName)
+	if !ok {
+		compiled, err := regexp.Compile(strings.ReplaceAll(m.pattern, "{doctrine}", regexp.QuoteMeta(doctrineName)))
+		if err != nil {
+			return false
+		}
+		re, _ = m.doctrines.LoadOrStore(doctrineName, compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(title)
+}
+
+// anyMatcher matches when any of the matchers match.
+type anyMatcher []Matcher
+
+func (m anyMatcher) Match(doctrineName, title string) bool {
+	for _, matcher := range m {
+		if matcher.Match(doctrineName, title) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenOverlap returns how many words of doctrine name are in the title
+// and how many words the doctrine name has.
+func tokenOverlap(doctrineName, title string) (int, int) {
+	var (
+		requireParts = strings.Fields(strings.ToLower(doctrineName))
+		haveParts    = make(map[string]struct{})
+		found        int
+	)
+	for _, havePart := range strings.Fields(strings.ToLower(title)) {
+		haveParts[havePart] = struct{}{}
+	}
+	for _, requirePart := range requireParts {
+		if _, ok := haveParts[requirePart]; ok {
+			found++
+		}
+	}
+	return found, len(requireParts)
+}
+
+func jaccardSimilarity(a, b string) float64 {
+	metric := metrics.NewJaccard()
+	metric.CaseSensitive = false
+	return strutil.Similarity(a, b, metric)
+}
+
+// matchDoctrine reports whether contract title is for the doctrine. Doctrine
+// aliases are accepted as exact titles, otherwise doctrine matching strategy
+// is used, or the global one if doctrine has none.
+func (b *quartermasterBot) matchDoctrine(doctrine repository.Doctrine, title string) bool {
+	for _, alias := range doctrine.Aliases {
+		if strings.EqualFold(strings.TrimSpace(alias), strings.TrimSpace(title)) {
+			return true
+		}
+	}
+	return b.doctrineMatcher(doctrine).Match(doctrine.Name, title)
+}
+
+// doctrineMatcher returns matcher configured for the doctrine or the
+// global matcher. Matchers are cached by the doctrine matching strategy,
+// so they are not created again for every contract.
+func (b *quartermasterBot) doctrineMatcher(doctrine repository.Doctrine) Matcher {
+	if doctrine.Match == nil {
+		return b.matcher
+	}
+	if matcher, ok := b.matchers.Load(*doctrine.Match); ok {
+		return matcher.(Matcher)
+	}
+	matcher, err := NewMatcher(doctrine.Match.Strategy, doctrine.Match.Threshold, doctrine.Match.Pattern)
+	if err != nil {
+		b.log.Errorw("invalid doctrine matching strategy, using global", "error", err, "doctrine_name", doctrine.Name)
+		matcher = b.matcher
+	}
+	b.matchers.Store(*doctrine.Match, matcher)
+	return matcher
+}
+
+// matchScore returns how well the contract title matches the doctrine, it is
+// used to pick one doctrine when the title matches more of them.
+func matchScore(doctrine repository.Doctrine, title string) float64 {
+	for _, alias := range doctrine.Aliases {
+		if strings.EqualFold(strings.TrimSpace(alias), strings.TrimSpace(title)) {
+			return 1
+		}
+	}
+	return jaccardSimilarity(doctrine.Name, title)
+}
+
+// bestDoctrine returns the best scoring doctrine the contract title matches
+// and all the doctrines it matches, sorted from the best.
+func (b *quartermasterBot) bestDoctrine(
+	doctrines []repository.Doctrine,
+	title string,
+) (repository.Doctrine, []repository.Doctrine, bool) {
+	var (
+		matched []repository.Doctrine
+		scores  = make(map[string]float64)
+	)
+	for _, doctrine := range doctrines {
+		if !b.matchDoctrine(doctrine, title) {
+			continue
+		}
+		matched = append(matched, doctrine)
+		scores[doctrine.Name] = matchScore(doctrine, title)
+	}
+	if len(matched) == 0 {
+		return repository.Doctrine{}, nil, false
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		scoreI, scoreJ := scores[matched[i].Name], scores[matched[j].Name]
+		if scoreI != scoreJ {
+			return scoreI > scoreJ
+		}
+		// More specific (longer) doctrine name wins on equal score.
+		if len(matched[i].Name) != len(matched[j].Name) {
+			return len(matched[i].Name) > len(matched[j].Name)
+		}
+		return matched[i].Name < matched[j].Name
+	})
+	return matched[0], matched, true
+}
+
+type ambiguousContract struct {
+	Title      string   // Contract title.
+	Count      int      // How many contracts have this title.
+	AssignedTo string   // Doctrine the contracts were counted toward.
+	Doctrines  []string // All doctrines the title matches.
+}
+
+// assignContracts assigns each contract title to exactly one doctrine and
+// returns contract title -> doctrine name, and list of titles that matched
+// more than one doctrine.
+func (b *quartermasterBot) assignContracts(
+	doctrines []repository.Doctrine,
+	gotContracts map[string]int,
+) (map[string]string, []ambiguousContract) {
+	var (
+		assigned  = make(map[string]string)
+		ambiguous []ambiguousContract
+	)
+	for title, count := range gotContracts {
+		best, matched, ok := b.bestDoctrine(doctrines, title)
+		if !ok {
+			continue
+		}
+		assigned[title] = best.Name
+		if len(matched) > 1 {
+			var names []string
+			for _, doctrine := range matched {
+				names = append(names, doctrine.Name)
+			}
+			ambiguous = append(ambiguous, ambiguousContract{
+				Title:      title,
+				Count:      count,
+				AssignedTo: best.Name,
+				Doctrines:  names,
+			})
+		}
+	}
+
+	sort.Slice(ambiguous, func(i, j int) bool {
+		return ambiguous[i].Title < ambiguous[j].Title
+	})
+	return assigned, ambiguous
+}
@@ -0,0 +1,305 @@
+package bot
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"go.uber.org/zap"
+)
+
+func TestMatcher(t *testing.T) {
+	// Fuzzy threshold edges are relative to the similarity itself.
+	similarity := jaccardSimilarity("Shield Heron", "Heron Shield")
+
+	for _, test := range []struct {
+		name      string
+		strategy  string
+		threshold float64
+		pattern   string
+		doctrine  string
+		title     string
+		want      bool
+	}{
+		{name: "default all words", strategy: MatchDefault, doctrine: "Shield Heron", title: "heron shield fit", want: true},
+		{name: "default similar", strategy: MatchDefault, doctrine: "Shield Heron", title: "Shield Herons", want: true},
+		{name: "default different", strategy: MatchDefault, doctrine: "Shield Heron", title: "Armor Heron", want: false},
+		{name: "empty strategy is default", strategy: "", doctrine: "Shield Heron", title: "Heron Shield", want: true},
+		{name: "exact", strategy: MatchExact, doctrine: "Shield Heron", title: " shield heron ", want: true},
+		{name: "exact more words", strategy: MatchExact, doctrine: "Shield Heron", title: "Shield Heron 2", want: false},
+		{name: "tokens any order", strategy: MatchTokens, doctrine: "Shield Heron", title: "Heron Shield v2", want: true},
+		{name: "tokens missing word", strategy: MatchTokens, doctrine: "Shield Heron", title: "Heron", want: false},
+		{name: "tokens empty doctrine", strategy: MatchTokens, doctrine: " ", title: "Heron", want: false},
+		{name: "fuzzy at threshold", strategy: MatchFuzzy, threshold: similarity, doctrine: "Shield Heron", title: "Heron Shield", want: true},
+		{name: "fuzzy above threshold", strategy: MatchFuzzy, threshold: similarity + 0.01, doctrine: "Shield Heron", title: "Heron Shield", want: false},
+		{name: "fuzzy threshold 1", strategy: MatchFuzzy, threshold: 1, doctrine: "Shield Heron", title: "shield heron", want: true},
+		{name: "regex", strategy: MatchRegex, pattern: `^SH\d+$`, doctrine: "Shield Heron", title: "SH2", want: true},
+		{name: "regex no match", strategy: MatchRegex, pattern: `^SH\d+$`, doctrine: "Shield Heron", title: "SH", want: false},
+		{name: "regex doctrine", strategy: MatchRegex, pattern: `(?i)^{doctrine}( v\d+)?$`, doctrine: "Shield Heron", title: "shield heron v3", want: true},
+		{name: "regex doctrine is quoted", strategy: MatchRegex, pattern: `^{doctrine}$`, doctrine: "Heron (T2)", title: "Heron (T2)", want: true},
+		{name: "regex doctrine quoted no match", strategy: MatchRegex, pattern: `^{doctrine}$`, doctrine: "Heron.", title: "Herons", want: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			matcher, err := NewMatcher(test.strategy, test.threshold, test.pattern)
+			if err != nil {
+				t.Fatalf("unable to create matcher: %+v", err)
+			}
+			got := matcher.Match(test.doctrine, test.title)
+			if got != test.want {
+				t.Errorf("Match(%q, %q) = %t, want %t", test.doctrine, test.title, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewMatcherInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		strategy  string
+		threshold float64
+		pattern   string
+	}{
+		{name: "unknown strategy", strategy: "prefix"},
+		{name: "fuzzy zero threshold", strategy: MatchFuzzy, threshold: 0},
+		{name: "fuzzy negative threshold", strategy: MatchFuzzy, threshold: -0.5},
+		{name: "fuzzy threshold over 1", strategy: MatchFuzzy, threshold: 1.01},
+		{name: "invalid regex", strategy: MatchRegex, pattern: `^(Heron`},
+		{name: "invalid regex with doctrine", strategy: MatchRegex, pattern: `{doctrine}[`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewMatcher(test.strategy, test.threshold, test.pattern)
+			if err == nil {
+				t.Errorf("NewMatcher(%q, %v, %q) did not fail", test.strategy, test.threshold, test.pattern)
+			}
+		})
+	}
+}
+
+// newMatchingBot returns bot with just the matching configured.
+func newMatchingBot(t *testing.T, strategy string) *quartermasterBot {
+	t.Helper()
+
+	matcher, err := NewMatcher(strategy, 0, "")
+	if err != nil {
+		t.Fatalf("unable to create matcher: %+v", err)
+	}
+	return &quartermasterBot{
+		log:      zap.NewNop().Sugar(),
+		matcher:  matcher,
+		matchers: new(sync.Map),
+	}
+}
+
+func TestMatchDoctrine(t *testing.T) {
+	b := newMatchingBot(t, MatchDefault)
+
+	for _, test := range []struct {
+		name     string
+		doctrine repository.Doctrine
+		title    string
+		want     bool
+	}{
+		{
+			name:     "global strategy",
+			doctrine: repository.Doctrine{Name: "Shield Heron"},
+			title:    "Heron Shield",
+			want:     true,
+		},
+		{
+			name:     "doctrine strategy overrides global",
+			doctrine: repository.Doctrine{Name: "Shield Heron", Match: &repository.MatchStrategy{Strategy: MatchExact}},
+			title:    "Heron Shield",
+			want:     false,
+		},
+		{
+			name: "doctrine fuzzy",
+			doctrine: repository.Doctrine{
+				Name:  "Shield Heron",
+				Match: &repository.MatchStrategy{Strategy: MatchFuzzy, Threshold: 0.3},
+			},
+			title: "Heron",
+			want:  true,
+		},
+		{
+			name: "doctrine regex",
+			doctrine: repository.Doctrine{
+				Name:  "Shield Heron",
+				Match: &repository.MatchStrategy{Strategy: MatchRegex, Pattern: `^SH\d$`},
+			},
+			title: "SH1",
+			want:  true,
+		},
+		{
+			name: "invalid doctrine strategy uses global",
+			doctrine: repository.Doctrine{
+				Name:  "Shield Heron",
+				Match: &repository.MatchStrategy{Strategy: MatchRegex, Pattern: `^(SH`},
+			},
+			title: "Heron Shield",
+			want:  true,
+		},
+		{
+			name: "alias",
+			doctrine: repository.Doctrine{
+				Name:    "Shield Heron",
+				Aliases: []string{"SH"},
+				Match:   &repository.MatchStrategy{Strategy: MatchExact},
+			},
+			title: " sh ",
+			want:  true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := b.matchDoctrine(test.doctrine, test.title)
+			if got != test.want {
+				t.Errorf("matchDoctrine(%q, %q) = %t, want %t", test.doctrine.Name, test.title, got, test.want)
+			}
+		})
+	}
+}
+
+func TestDoctrineMatcherCached(t *testing.T) {
+	b := newMatchingBot(t, MatchDefault)
+	strategy := &repository.MatchStrategy{Strategy: MatchRegex, Pattern: `^{doctrine}$`}
+	heron := repository.Doctrine{Name: "Shield Heron", Match: strategy}
+	merlin := repository.Doctrine{Name: "Merlin", Match: strategy}
+
+	first, ok := b.doctrineMatcher(heron).(regexMatcher)
+	if !ok {
+		t.Fatalf("doctrine matcher is %T, want regexMatcher", b.doctrineMatcher(heron))
+	}
+	if !b.matchDoctrine(heron, "Shield Heron") || !b.matchDoctrine(merlin, "Merlin") {
+		t.Fatalf("doctrines did not match their names")
+	}
+	if b.matchDoctrine(merlin, "Shield Heron") {
+		t.Errorf("pattern compiled for Shield Heron was used for Merlin")
+	}
+
+	second := b.doctrineMatcher(merlin).(regexMatcher)
+	if first.doctrines != second.doctrines {
+		t.Errorf("matcher was created again for the same strategy")
+	}
+	var compiled int
+	second.doctrines.Range(func(key, value interface{}) bool {
+		compiled++
+		return true
+	})
+	if compiled != 2 {
+		t.Errorf("pattern compiled for %d doctrines, want 2", compiled)
+	}
+}
+
+func TestBestDoctrine(t *testing.T) {
+	b := newMatchingBot(t, MatchDefault)
+
+	for _, test := range []struct {
+		name      string
+		doctrines []repository.Doctrine
+		title     string
+		want      string
+		matched   []string
+	}{
+		{
+			name:      "no match",
+			doctrines: []repository.Doctrine{{Name: "Muninn"}, {Name: "Shield Heron"}},
+			title:     "Jackdaw",
+		},
+		{
+			name:      "shorter name only",
+			doctrines: []repository.Doctrine{{Name: "Muninn"}, {Name: "Muninn Fleet"}},
+			title:     "Muninn",
+			want:      "Muninn",
+			matched:   []string{"Muninn"},
+		},
+		{
+			name:      "overlapping names, higher score wins",
+			doctrines: []repository.Doctrine{{Name: "Muninn"}, {Name: "Muninn Fleet"}},
+			title:     "Muninn Fleet",
+			want:      "Muninn Fleet",
+			matched:   []string{"Muninn Fleet", "Muninn"},
+		},
+		{
+			name: "alias scores highest",
+			doctrines: []repository.Doctrine{
+				{Name: "Muninn Fleet"},
+				{Name: "Muninn", Aliases: []string{"Muninn Fleet v2"}},
+			},
+			title:   "Muninn Fleet v2",
+			want:    "Muninn",
+			matched: []string{"Muninn", "Muninn Fleet"},
+		},
+		{
+			name: "equal score, longer name wins",
+			doctrines: []repository.Doctrine{
+				{Name: "Cerb", Aliases: []string{"MWD Cerberus"}},
+				{Name: "Cerberus", Aliases: []string{"MWD Cerberus"}},
+			},
+			title:   "MWD Cerberus",
+			want:    "Cerberus",
+			matched: []string{"Cerberus", "Cerb"},
+		},
+		{
+			name: "equal score and length, name wins",
+			doctrines: []repository.Doctrine{
+				{Name: "Vexor B", Aliases: []string{"Drone Vexor"}},
+				{Name: "Vexor A", Aliases: []string{"Drone Vexor"}},
+			},
+			title:   "Drone Vexor",
+			want:    "Vexor A",
+			matched: []string{"Vexor A", "Vexor B"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			best, matched, ok := b.bestDoctrine(test.doctrines, test.title)
+			if ok != (test.want != "") {
+				t.Fatalf("bestDoctrine(%q) ok = %t, want %t", test.title, ok, test.want != "")
+			}
+			if best.Name != test.want {
+				t.Errorf("bestDoctrine(%q) = %q, want %q", test.title, best.Name, test.want)
+			}
+			var names []string
+			for _, doctrine := range matched {
+				names = append(names, doctrine.Name)
+			}
+			if !reflect.DeepEqual(names, test.matched) {
+				t.Errorf("bestDoctrine(%q) matched %v, want %v", test.title, names, test.matched)
+			}
+		})
+	}
+}
+
+func TestAssignContracts(t *testing.T) {
+	b := newMatchingBot(t, MatchDefault)
+	doctrines := []repository.Doctrine{
+		{Name: "Muninn"},
+		{Name: "Muninn Fleet"},
+		{Name: "Shield Heron", Match: &repository.MatchStrategy{Strategy: MatchExact}},
+	}
+
+	assigned, ambiguous := b.assignContracts(doctrines, map[string]int{
+		"Muninn":         3,
+		"Muninn Fleet":   2,
+		"Heron Shield":   1,
+		"Shield Heron":   4,
+		"Jackdaw":        1,
+		"muninn fleet 2": 5,
+	})
+	wantAssigned := map[string]string{
+		"Muninn":         "Muninn",
+		"Muninn Fleet":   "Muninn Fleet",
+		"Shield Heron":   "Shield Heron",
+		"muninn fleet 2": "Muninn Fleet",
+	}
+	if !reflect.DeepEqual(assigned, wantAssigned) {
+		t.Errorf("assigned = %v, want %v", assigned, wantAssigned)
+	}
+	wantAmbiguous := []ambiguousContract{
+		{Title: "Muninn Fleet", Count: 2, AssignedTo: "Muninn Fleet", Doctrines: []string{"Muninn Fleet", "Muninn"}},
+		{Title: "muninn fleet 2", Count: 5, AssignedTo: "Muninn Fleet", Doctrines: []string{"Muninn Fleet", "Muninn"}},
+	}
+	if !reflect.DeepEqual(ambiguous, wantAmbiguous) {
+		t.Errorf("ambiguous = %+v, want %+v", ambiguous, wantAmbiguous)
+	}
+}
@@ -36,25 +36,30 @@ func (b *quartermasterBot) migrate(s *discordgo.Session, m *discordgo.MessageCre
 		}
 		b.log.Infow("Responding to !migrate", "channel_id", m.ChannelID, "msg", m.Content, "params", params)
 
-		migrateFrom, migrateTo := params[0], params[1]
-		message := b.migrateConfirmMessage(migrateFrom, migrateTo)
+		b.requestMigration(m.ChannelID, params[0], params[1])
+	}
+}
 
-		msg, err := b.discord.ChannelMessageSendEmbed(m.ChannelID, message)
-		if err != nil {
-			b.log.Errorw("error sending message for !migrate", "error", err)
+// requestMigration asks to confirm the migration by reacting, the migration
+// is applied by migrateReact.
+func (b *quartermasterBot) requestMigration(channelID, migrateFrom, migrateTo string) {
+	message := b.migrateConfirmMessage(migrateFrom, migrateTo)
 
-			b.sendError(err, m.ChannelID)
-			return
-		}
+	msg, err := b.discord.ChannelMessageSendEmbed(channelID, message)
+	if err != nil {
+		b.log.Errorw("error sending message for !migrate", "error", err)
 
-		b.pendingMigrations.Store(msg.ID, migration{
-			ChannelID: msg.ChannelID,
-			MessageID: msg.ID,
-			From:      migrateFrom,
-			To:        migrateTo,
-			Created:   time.Now().UTC(),
-		})
+		b.sendError(err, channelID)
+		return
 	}
+
+	b.pendingMigrations.Store(msg.ID, migration{
+		ChannelID: msg.ChannelID,
+		MessageID: msg.ID,
+		From:      migrateFrom,
+		To:        migrateTo,
+		Created:   time.Now().UTC(),
+	})
 }
 
 func (b *quartermasterBot) migrateConfirmMessage(migrateFrom, migrateTo string) *discordgo.MessageEmbed {
@@ -79,6 +84,19 @@ func (b *quartermasterBot) migrateReact(s *discordgo.Session, m *discordgo.Messa
 		return
 	}
 	migration := migrationInterface.(migration)
+	allowed, allowedRoles, err := b.permitted(s, m.GuildID, m.ChannelID, m.UserID, nil, "migrate")
+	if err != nil {
+		b.pendingMigrations.Store(m.MessageID, migration)
+		b.log.Errorw("error checking command permissions", "error", err, "command", "migrate")
+		b.sendError(err, m.ChannelID)
+		return
+	}
+	if !allowed {
+		// Someone allowed can still confirm it.
+		b.pendingMigrations.Store(m.MessageID, migration)
+		b.refuse(m.ChannelID, m.UserID, "migrate", allowedRoles)
+		return
+	}
 	if migration.Created.Before(time.Now().UTC().Add(-10 * time.Minute)) {
 		ref := discordgo.MessageReference{
 			MessageID: migration.MessageID,
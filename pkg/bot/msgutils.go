@@ -34,3 +34,17 @@ func splitMessageParts(slice []string, maxLength int) []string {
 	messages = append(messages, buf.String())
 	return messages
 }
+
+// splitAssignment splits command parameters in format "Doctrine name = value"
+// into doctrine name and value.
+func splitAssignment(params string) (string, string, bool) {
+	parts := strings.SplitN(params, " = ", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if name == "" || value == "" {
+		return "", "", false
+	}
+	return name, value, true
+}
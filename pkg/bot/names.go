@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+)
+
+const (
+	// Player structures have IDs above this, anything below is NPC station.
+	minStructureID = 1000000000000
+	// Names saved in the repository are resolved again after this, characters
+	// and structures can be renamed.
+	nameTTL = 7 * 24 * time.Hour
+	// Maximum number of IDs ESI resolves in one call.
+	maxNamesPerCall = 1000
+	// Failed name calls are not split to find invalid IDs when less ESI
+	// errors remain, the error limit is shared with contract fetching.
+	minNamesErrorLimit = 20
+)
+
+// idToName resolves character, corporation, type etc. ID to its name.
+func (b *quartermasterBot) idToName(id int32) string {
+	return b.resolveNames([]int64{int64(id)})[int64(id)]
+}
+
+// locationName resolves station or structure ID to its name.
+func (b *quartermasterBot) locationName(locationID int64) string {
+	return b.resolveNames([]int64{locationID})[locationID]
+}
+
+// resolveNames returns ID -> name of all the IDs. Names are looked up in
+// memory, then in the repository and the rest is resolved by ESI in one
+// batched call, structures by the authenticated structures endpoint. IDs
+// that can't be resolved are returned as the ID, and are not cached so
+// they will be resolved again next time. Cached names are resolved again
+// after nameTTL.
+func (b *quartermasterBot) resolveNames(ids []int64) map[int64]string {
+	var (
+		out     = make(map[int64]string)
+		missing []int64
+	)
+	for _, id := range ids {
+		if _, ok := out[id]; ok {
+			continue
+		}
+		nameInterface, ok := b.names.Load(id)
+		if ok && time.Since(nameInterface.(repository.Name).Resolved) < nameTTL {
+			out[id] = nameInterface.(repository.Name).Name
+			continue
+		}
+		out[id] = fmt.Sprint(id)
+		missing = append(missing, id)
+	}
+	if len(missing) == 0 {
+		return out
+	}
+
+	saved, err := b.repository.GetNames(missing)
+	if err != nil {
+		b.log.Errorw("Error reading saved names", "error", err)
+	}
+	var unresolved []int64
+	for _, id := range missing {
+		name, ok := saved[id]
+		if ok && time.Since(name.Resolved) < nameTTL {
+			out[id] = name.Name
+			b.names.Store(id, name)
+			continue
+		}
+		unresolved = append(unresolved, id)
+	}
+	if len(unresolved) == 0 {
+		return out
+	}
+
+	var resolved []repository.Name
+	for id, name := range b.resolveESINames(unresolved) {
+		out[id] = name
+		name := repository.Name{ID: id, Name: name, Resolved: time.Now()}
+		b.names.Store(id, name)
+		resolved = append(resolved, name)
+	}
+	err = b.repository.SetNames(resolved)
+	if err != nil {
+		b.log.Errorw("Error saving resolved names", "error", err)
+	}
+	return out
+}
+
+// resolveESINames resolves the IDs by ESI, IDs that can't be resolved
+// are left out.
+func (b *quartermasterBot) resolveESINames(ids []int64) map[int64]string {
+	var (
+		out       = make(map[int64]string)
+		universal []int32
+	)
+	for _, id := range ids {
+		if id < minStructureID {
+			universal = append(universal, int32(id))
+			continue
+		}
+		// Structures can't be resolved in batch, and need authentication.
+		name, err := b.universe.StructureName(b.ctx, id)
+		if err != nil {
+			b.log.Errorw("Error translating structure ID to name", "error", err, "location_id", id)
+			continue
+		}
+		out[id] = name
+	}
+
+	for start := 0; start < len(universal); start += maxNamesPerCall {
+		end := start + maxNamesPerCall
+		if end > len(universal) {
+			end = len(universal)
+		}
+		if !b.resolveNameBatch(universal[start:end], out) {
+			break
+		}
+	}
+	return out
+}
+
+// resolveNameBatch resolves names of the IDs to out. The whole call fails
+// when any of the IDs is invalid, so failed batch is split in halves until
+// the invalid IDs are left out. Returns false when ESI error limit is too low
+// to continue.
+func (b *quartermasterBot) resolveNameBatch(ids []int32, out map[int64]string) bool {
+	names, err := b.universe.Names(b.ctx, ids)
+	if err == nil {
+		for _, name := range names {
+			out[int64(name.Id)] = name.Name
+		}
+		return true
+	}
+	b.log.Errorw("Error translating IDs to names", "error", err, "ids", ids)
+	if remain, ok := errorLimitRemain(err); ok && remain < minNamesErrorLimit {
+		b.log.Errorw("ESI error limit is low, not resolving more names", "error_limit_remain", remain)
+		return false
+	}
+	if len(ids) == 1 {
+		return true
+	}
+	half := len(ids) / 2
+	return b.resolveNameBatch(ids[:half], out) && b.resolveNameBatch(ids[half:], out)
+}
@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esifake"
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+)
+
+// nameRequests returns how many names calls the server got.
+func nameRequests(server *esifake.Server) int {
+	var count int
+	for _, request := range server.Requests() {
+		if strings.Contains(request, "/universe/names/") {
+			count++
+		}
+	}
+	return count
+}
+
+func TestResolveNamesBisectsFailedBatch(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	var ids []int64
+	for id := int32(1); id <= 16; id++ {
+		ids = append(ids, int64(id))
+		if id == 5 || id == 12 {
+			continue
+		}
+		server.SetName(id, fmt.Sprintf("Character %d", id), esifake.CategoryCharacter)
+	}
+
+	b := newTestBot(t, server, everyone(t))
+	names := b.resolveNames(ids)
+	for _, id := range ids {
+		want := fmt.Sprintf("Character %d", id)
+		if id == 5 || id == 12 {
+			want = fmt.Sprint(id)
+		}
+		if names[id] != want {
+			t.Errorf("ID %d: got name %q, want %q", id, names[id], want)
+		}
+	}
+	// One call for each ID would be 17.
+	if requests := nameRequests(server); requests >= 17 {
+		t.Errorf("got %d names calls, failed batch was not bisected", requests)
+	}
+}
+
+func TestResolveNamesStopsAtLowErrorLimit(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetName(1, "Character 1", esifake.CategoryCharacter)
+	server.SetErrorLimit(minNamesErrorLimit)
+
+	b := newTestBot(t, server, everyone(t))
+	names := b.resolveNames([]int64{1, 2})
+	if names[1] != "1" || names[2] != "2" {
+		t.Errorf("got names %v, want IDs", names)
+	}
+	if requests := nameRequests(server); requests != 1 {
+		t.Errorf("got %d names calls, want 1 with low error limit", requests)
+	}
+}
+
+func TestResolveNamesExpiresCachedNames(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetName(1, "New Name", esifake.CategoryCharacter)
+	server.SetName(2, "Resolved Again", esifake.CategoryCharacter)
+
+	b := newTestBot(t, server, everyone(t))
+	b.names.Store(int64(1), repository.Name{ID: 1, Name: "Old Name", Resolved: time.Now().Add(-nameTTL - time.Hour)})
+	b.names.Store(int64(2), repository.Name{ID: 2, Name: "Fresh Name", Resolved: time.Now()})
+
+	names := b.resolveNames([]int64{1, 2})
+	if names[1] != "New Name" {
+		t.Errorf("got expired name %q, want %q", names[1], "New Name")
+	}
+	if names[2] != "Fresh Name" {
+		t.Errorf("got name %q, want cached %q", names[2], "Fresh Name")
+	}
+}
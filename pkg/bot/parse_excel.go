@@ -31,7 +31,13 @@ func (b *quartermasterBot) parseExcelHandler(s *discordgo.Session, m *discordgo.
 			}
 			return
 		}
-		err := b.repository.WriteAll(doctrines)
+		existing, err := b.repository.ReadAll()
+		if err != nil {
+			b.log.Errorw("error reading doctrines for bulk insert", "error", err)
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		err = b.repository.WriteAll(repository.MergeRequirements(existing, doctrines))
 		if err != nil {
 			b.log.Errorw("error saving bulk insert in stock doctrine", "error", err)
 
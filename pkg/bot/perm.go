@@ -0,0 +1,325 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/repository"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+// restrictedCommands are commands that change the repository, they can be
+// limited to Discord roles by configuration or !perm. Command -> its
+// read-only subcommands anyone can run.
+var restrictedCommands = map[string][]string{
+	"require":     {"list"},
+	"parse excel": nil,
+	"price":       nil,
+	"migrate":     nil,
+	"doctrine":    {"show"},
+	"alias":       nil,
+	"location":    {"list"},
+	"perm":        {"list"},
+	"channel":     {"list"},
+}
+
+// IsRestrictedCommand reports whether the command can be limited to roles.
+func IsRestrictedCommand(command string) bool {
+	_, ok := restrictedCommands[command]
+	return ok
+}
+
+// restrictedCommand returns restricted command of the message, false if
+// anyone can run it.
+func restrictedCommand(content string) (string, bool) {
+	for command, readOnly := range restrictedCommands {
+		prefix := "!" + command
+		if !strings.HasPrefix(content, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(content, prefix)
+		if rest != "" && !strings.ContainsAny(rest[:1], " \t\n") {
+			continue
+		}
+		fields := strings.Fields(rest)
+		for _, subcommand := range readOnly {
+			if len(fields) != 0 && fields[0] == subcommand {
+				return "", false
+			}
+		}
+		return command, true
+	}
+	return "", false
+}
+
+// checkPermissions calls the command handlers only when the message author
+// can run the command, so denied command is refused once for all handlers.
+func (b *quartermasterBot) checkPermissions(
+	handlers []func(*discordgo.Session, *discordgo.MessageCreate),
+) func(*discordgo.Session, *discordgo.MessageCreate) {
+	return func(s *discordgo.Session, m *discordgo.MessageCreate) {
+		if command, ok := restrictedCommand(m.Content); ok {
+			var roles []string
+			if m.Member != nil {
+				roles = m.Member.Roles
+			}
+			allowed, allowedRoles, err := b.permitted(s, m.GuildID, m.ChannelID, m.Author.ID, roles, command)
+			if err != nil {
+				b.log.Errorw("error checking command permissions", "error", err, "command", command)
+				b.sendError(err, m.ChannelID)
+				return
+			}
+			if !allowed {
+				b.refuse(m.ChannelID, m.Author.ID, command, allowedRoles)
+				return
+			}
+		}
+		for _, handler := range handlers {
+			handler(s, m)
+		}
+	}
+}
+
+// permitted reports whether the user can run the command, and which roles
+// can. Server administrators can run every command, !perm is only for them
+// until roles are set. Roles are read from the guild when nil.
+func (b *quartermasterBot) permitted(
+	s *discordgo.Session,
+	guildID, channelID, userID string,
+	roles []string,
+	command string,
+) (bool, []string, error) {
+	allowedRoles, err := b.commandRoles(command)
+	if err != nil {
+		return false, nil, err
+	}
+	if len(allowedRoles) == 0 && command != "perm" {
+		return true, nil, nil
+	}
+
+	permissions, err := s.UserChannelPermissions(userID, channelID)
+	if err != nil {
+		b.log.Errorw("error reading user permissions", "error", err, "user_id", userID)
+	}
+	if permissions&discordgo.PermissionAdministrator == discordgo.PermissionAdministrator {
+		return true, allowedRoles, nil
+	}
+
+	if roles == nil {
+		member, err := s.GuildMember(guildID, userID)
+		if err != nil {
+			return false, nil, errors.Wrap(err, "unable to read user roles")
+		}
+		roles = member.Roles
+	}
+	for _, role := range roles {
+		for _, allowedRole := range allowedRoles {
+			if role == allowedRole {
+				return true, allowedRoles, nil
+			}
+		}
+	}
+	return false, allowedRoles, nil
+}
+
+// commandRoles returns roles that can run the command, set by !perm or by
+// the configuration. Empty for everyone.
+func (b *quartermasterBot) commandRoles(command string) ([]string, error) {
+	permissions, err := b.repository.ReadAllPermissions()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read command permissions")
+	}
+	for _, permission := range permissions {
+		if permission.Command == command {
+			return permission.RoleIDs, nil
+		}
+	}
+	return b.configRoles[command], nil
+}
+
+// refuse tells the user the command is not allowed.
+func (b *quartermasterBot) refuse(channelID, userID, command string, allowedRoles []string) {
+	b.log.Infow("Denied command", "command", command, "user_id", userID, "channel_id", channelID)
+
+	_, err := b.discord.ChannelMessageSendEmbed(channelID, &discordgo.MessageEmbed{
+		Title: ":no_entry: Not allowed",
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: "https://i.imgur.com/ZwUn8DI.jpg",
+		},
+		Color: 0xff0000,
+		Description: fmt.Sprintf(
+			"Sorry <@%s>, `!%s` can be run only by %s.",
+			userID,
+			command,
+			rolesText(command, allowedRoles),
+		),
+		Timestamp: time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+	})
+	if err != nil {
+		b.log.Errorw("error sending message for denied command", "error", err)
+	}
+}
+
+// rolesText returns who can run the command with roles, roles are
+// mentioned, it does not ping them in embeds.
+func rolesText(command string, roles []string) string {
+	if len(roles) == 0 {
+		if command == "perm" {
+			return "server administrators"
+		}
+		return "everyone"
+	}
+	var mentions []string
+	for _, role := range roles {
+		mentions = append(mentions, fmt.Sprintf("<@&%s>", role))
+	}
+	return strings.Join(mentions, ", ") + " and server administrators"
+}
+
+var roleRegex = regexp.MustCompile(`^(?:<@&([0-9]+)>|([0-9]+))$`)
+
+// permHandler will be called every time a new
+// message is created on any channel that the autenticated bot has access to.
+func (b *quartermasterBot) permHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Content == "!perm list" {
+		b.log.Infow("Responding to !perm list command", "channel_id", m.ChannelID)
+		message, err := b.permListMessage()
+		if err != nil {
+			b.log.Errorw("error reading command permissions", "error", err)
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		_, err = b.discord.ChannelMessageSendEmbed(m.ChannelID, message)
+		if err != nil {
+			b.log.Errorw("error sending message for !perm list", "error", err)
+		}
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!perm set ") {
+		b.log.Infow("Responding to !perm set command", "channel_id", m.ChannelID)
+		// Format is: "!perm set command = @Role [@Role...]", example: "!perm set require = @Logistics"
+		commandContent := strings.TrimSpace(strings.TrimPrefix(m.Content, "!perm set "))
+		command, value, ok := splitAssignment(commandContent)
+		if !ok {
+			msg := fmt.Sprintf("unrecognised !perm set `%s`, the format is `!perm set command = @Role [@Role...]` or `!perm set command = everyone`", commandContent)
+			_, err := b.discord.ChannelMessageSend(m.ChannelID, msg)
+			if err != nil {
+				b.log.Errorw("error responding to unknown !perm set", "error", err)
+			}
+			return
+		}
+		if !IsRestrictedCommand(command) {
+			b.sendError(errors.Errorf("`%s` can't be limited to roles, use one of: %s", command, strings.Join(restrictedCommandNames(), ", ")), m.ChannelID)
+			return
+		}
+		roles, err := parseRoles(value)
+		if err != nil {
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		err = b.repository.SetPermission(repository.Permission{Command: command, RoleIDs: roles})
+		if err != nil {
+			b.log.Errorw("error saving command permission", "error", err, "command", command)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		b.log.Infow("Command permission set", "command", command, "roles", roles, "user_id", m.Author.ID)
+
+		err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+		if err != nil {
+			b.log.Errorw("error reacting with :+1:", "error", err)
+			return
+		}
+		return
+	}
+
+	if strings.HasPrefix(m.Content, "!perm reset ") {
+		b.log.Infow("Responding to !perm reset command", "channel_id", m.ChannelID)
+		command := strings.TrimSpace(strings.TrimPrefix(m.Content, "!perm reset "))
+		if !IsRestrictedCommand(command) {
+			b.sendError(errors.Errorf("`%s` can't be limited to roles, use one of: %s", command, strings.Join(restrictedCommandNames(), ", ")), m.ChannelID)
+			return
+		}
+		err := b.repository.DeletePermission(command)
+		if err != nil {
+			b.log.Errorw("error removing command permission", "error", err, "command", command)
+
+			b.sendError(err, m.ChannelID)
+			return
+		}
+		b.log.Infow("Command permission reset", "command", command, "user_id", m.Author.ID)
+
+		err = b.discord.MessageReactionAdd(m.ChannelID, m.ID, `👍`)
+		if err != nil {
+			b.log.Errorw("error reacting with :+1:", "error", err)
+			return
+		}
+		return
+	}
+}
+
+func (b *quartermasterBot) permListMessage() (*discordgo.MessageEmbed, error) {
+	permissions, err := b.repository.ReadAllPermissions()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read command permissions")
+	}
+	var set = make(map[string][]string)
+	for _, permission := range permissions {
+		set[permission.Command] = permission.RoleIDs
+	}
+
+	var parts []string
+	for _, command := range restrictedCommandNames() {
+		roles, ok := set[command]
+		source := "set by `!perm`"
+		if !ok {
+			roles = b.configRoles[command]
+			source = "configuration"
+		}
+		parts = append(parts, fmt.Sprintf("`!%s`: %s (%s)", command, rolesText(command, roles), source))
+	}
+	return &discordgo.MessageEmbed{
+		Title: ":lock: Command permissions",
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: "https://i.imgur.com/ZwUn8DI.jpg",
+		},
+		Color:       0x00ff00,
+		Description: strings.Join(parts, "\n"),
+		Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
+	}, nil
+}
+
+func restrictedCommandNames() []string {
+	var out []string
+	for command := range restrictedCommands {
+		out = append(out, command)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// parseRoles parses role mentions or IDs, "everyone" for no roles.
+func parseRoles(value string) ([]string, error) {
+	if value == "everyone" {
+		return []string{}, nil
+	}
+	var out []string
+	for _, field := range strings.Fields(value) {
+		match := roleRegex.FindStringSubmatch(field)
+		if match == nil {
+			return nil, errors.Errorf("`%s` is not a role, mention the role or use its ID", field)
+		}
+		out = append(out, match[1]+match[2])
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no roles given, mention the roles or use `everyone`")
+	}
+	return out, nil
+}
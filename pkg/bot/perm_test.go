@@ -0,0 +1,40 @@
+package bot
+
+import "testing"
+
+func TestRestrictedCommand(t *testing.T) {
+	tests := []struct {
+		content    string
+		want       string
+		restricted bool
+	}{
+		{content: "!require list", restricted: false},
+		{content: "!require 10 Corp Heron", want: "require", restricted: true},
+		{content: "!require", want: "require", restricted: true},
+		{content: "!requirex", restricted: false},
+		{content: "!parse excel\nHeron    5    Corporation", want: "parse excel", restricted: true},
+		{content: "!parse excelsior", restricted: false},
+		{content: "!price set 100 Heron", want: "price", restricted: true},
+		{content: "!migrate v1 v2", want: "migrate", restricted: true},
+		{content: "!doctrine show Shield Heron", restricted: false},
+		{content: "!doctrine sync", want: "doctrine", restricted: true},
+		{content: "!doctrine fit Shield Heron\n[Heron, Shield Heron]", want: "doctrine", restricted: true},
+		{content: "!alias add Heron = Exploration Heron", want: "alias", restricted: true},
+		{content: "!location list", restricted: false},
+		{content: "!location add Forward = 1021", want: "location", restricted: true},
+		{content: "!perm list", restricted: false},
+		{content: "!perm set require = @Logistics", want: "perm", restricted: true},
+		{content: "!channel list", restricted: false},
+		{content: "!channel set events = 123", want: "channel", restricted: true},
+		{content: "!report full", restricted: false},
+		{content: "!help", restricted: false},
+	}
+	for _, test := range tests {
+		t.Run(test.content, func(t *testing.T) {
+			got, restricted := restrictedCommand(test.content)
+			if restricted != test.restricted || got != test.want {
+				t.Errorf("got (%q, %t), want (%q, %t)", got, restricted, test.want, test.restricted)
+			}
+		})
+	}
+}
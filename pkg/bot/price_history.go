@@ -19,6 +19,7 @@ func (b *quartermasterBot) recordPrice(s *discordgo.Session, m *discordgo.Messag
 	if strings.HasPrefix(m.Content, "!price fetch") {
 		b.log.Infow("Responding to !price fetch", "channel_id", m.ChannelID)
 
+		b.invalidateSnapshot()
 		_, _, _, err := b.reportMissing()
 		if err != nil {
 			b.log.Errorw("error loading contracts", "error", err)
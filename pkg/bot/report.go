@@ -3,6 +3,7 @@ package bot
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -15,7 +16,7 @@ import (
 func (b *quartermasterBot) reportHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if m.Content == "!report full" {
 		b.log.Infow("Responding to !report full", "channel_id", m.ChannelID)
-		corporationDoctrines, soldCorporationDoctrines, allianceDoctrines, soldAllianceDoctrines, alerts, err := b.reportFull()
+		report, err := b.reportFull()
 		if err != nil {
 			b.log.Errorw("Error checking for missing doctrines",
 				"error", err,
@@ -24,13 +25,7 @@ func (b *quartermasterBot) reportHandler(s *discordgo.Session, m *discordgo.Mess
 			b.sendError(err, m.ChannelID)
 			return
 		}
-		messages := b.reportFullMessage(
-			corporationDoctrines,
-			soldCorporationDoctrines,
-			allianceDoctrines,
-			soldAllianceDoctrines,
-			alerts,
-		)
+		messages := b.addDataAge(b.reportFullMessage(report))
 
 		if len(messages) == 0 {
 			b.sendNoDoctrinesAddedMessage(m)
@@ -64,7 +59,7 @@ func (b *quartermasterBot) reportHandler(s *discordgo.Session, m *discordgo.Mess
 			return
 		}
 
-		messages := b.notifyMessage(missingCorporationDoctrines, missingAllianceDoctrines)
+		messages := b.addDataAge(b.notifyMessage(missingCorporationDoctrines, missingAllianceDoctrines))
 		if len(messages) == 0 {
 			b.sendNoDoctrinesAddedMessage(m)
 			return
@@ -82,18 +77,21 @@ func (b *quartermasterBot) reportHandler(s *discordgo.Session, m *discordgo.Mess
 	}
 }
 
-func (b *quartermasterBot) reportFull() (
-	[]doctrineReport,
-	map[string]int,
-	[]doctrineReport,
-	map[string]int,
-	[]alertContract,
-	error,
-) {
-	allContracts, err := b.loadContracts()
+type fullReport struct {
+	corporationDoctrines     []doctrineReport
+	soldCorporationDoctrines map[string]int
+	allianceDoctrines        []doctrineReport
+	soldAllianceDoctrines    map[string]int
+	alerts                   []alertContract
+	ambiguous                []ambiguousContract
+}
+
+func (b *quartermasterBot) reportFull() (fullReport, error) {
+	snapshot, err := b.contracts()
 	if err != nil {
-		return nil, nil, nil, nil, nil, errors.Wrap(err, "unable to load contracts")
+		return fullReport{}, errors.Wrap(err, "unable to load contracts")
 	}
+	allContracts := snapshot.contracts
 
 	corporationContracts, allianceContracts := b.filterAndGroupContracts(
 		allContracts,
@@ -101,16 +99,28 @@ func (b *quartermasterBot) reportFull() (
 		typeItemExchange,
 		true,
 	)
-	gotCorporationDoctrines := doctrinesAvailable(corporationContracts)
-	gotAllianceDoctrines := doctrinesAvailable(allianceContracts)
 	requireAllDoctrines, err := b.repository.ReadAll()
 	if err != nil {
-		return nil, nil, nil, nil, nil, errors.Wrap(err, "error reading required doctrines")
+		return fullReport{}, errors.Wrap(err, "error reading required doctrines")
+	}
+	requireAllDoctrines = filterRequired(requireAllDoctrines)
+	namedLocations, err := b.namedLocations()
+	if err != nil {
+		return fullReport{}, err
 	}
+	trustedIssuers, err := b.trustedIssuers()
+	if err != nil {
+		return fullReport{}, errors.Wrap(err, "unable to load trusted contract issuers")
+	}
+	corporationContracts = b.filterStockContracts(requireAllDoctrines, trustedIssuers, corporationContracts)
+	allianceContracts = b.filterStockContracts(requireAllDoctrines, trustedIssuers, allianceContracts)
+
+	gotCorporationDoctrines := doctrinesAvailable(corporationContracts)
+	gotAllianceDoctrines := doctrinesAvailable(allianceContracts)
 
-	// Get list of finished contracts to see how many sell per month.
+	// Get list of contracts finished last month to see how many sell per month.
 	finishedCorporationContracts, finishedAllianceContracts := b.filterAndGroupContracts(
-		allContracts,
+		completedSince(allContracts, time.Now().AddDate(0, -1, 0)),
 		statusFinished,
 		typeItemExchange,
 		false,
@@ -122,12 +132,19 @@ func (b *quartermasterBot) reportFull() (
 	requireCorporationDoctrines := filterDoctrines(requireAllDoctrines, repository.Corporation)
 	requireAllianceDoctrines := filterDoctrines(requireAllDoctrines, repository.Alliance)
 
-	return b.fullDoctrines(requireCorporationDoctrines, gotCorporationDoctrines),
-		b.soldDoctrines(requireCorporationDoctrines, finishedCorporationDoctrines),
-		b.fullDoctrines(requireAllianceDoctrines, gotAllianceDoctrines),
-		b.soldDoctrines(requireAllianceDoctrines, finishedAllianceDoctrines),
-		b.filterAlertContracts(requireAllDoctrines, allContracts),
-		nil
+	inTransit := b.inTransitDoctrines(requireAllDoctrines, allContracts, namedLocations)
+
+	_, ambiguousCorporation := b.assignContracts(requireCorporationDoctrines, gotCorporationDoctrines)
+	_, ambiguousAlliance := b.assignContracts(requireAllianceDoctrines, gotAllianceDoctrines)
+
+	return fullReport{
+		corporationDoctrines:     b.doctrinesByLocation(requireCorporationDoctrines, corporationContracts, namedLocations, inTransit, b.fullDoctrines),
+		soldCorporationDoctrines: b.soldDoctrines(requireCorporationDoctrines, finishedCorporationDoctrines),
+		allianceDoctrines:        b.addSuppliers(b.doctrinesByLocation(requireAllianceDoctrines, allianceContracts, namedLocations, inTransit, b.fullDoctrines)),
+		soldAllianceDoctrines:    b.soldDoctrines(requireAllianceDoctrines, finishedAllianceDoctrines),
+		alerts:                   b.filterAlertContracts(requireAllDoctrines, trustedIssuers, allContracts),
+		ambiguous:                append(ambiguousAlliance, ambiguousCorporation...),
+	}, nil
 }
 
 func (b *quartermasterBot) fullDoctrines(
@@ -136,7 +153,7 @@ func (b *quartermasterBot) fullDoctrines(
 ) []doctrineReport {
 	var doctrines []doctrineReport
 
-	doctrinesDiff := diffDoctrines(requireDoctrines, gotDoctrines)
+	doctrinesDiff := b.diffDoctrines(requireDoctrines, gotDoctrines)
 	for _, doctrine := range doctrinesDiff {
 		doctrines = append(doctrines, doctrine)
 	}
@@ -152,66 +169,28 @@ func (b *quartermasterBot) soldDoctrines(
 	gotDoctrines map[string]int,
 ) map[string]int {
 	var doctrines = make(map[string]int)
-	for _, requiredDoctrine := range requireDoctrines {
-		if requiredDoctrine.RequireStock == 0 {
-			continue
-		}
-		for doctrine, count := range gotDoctrines {
-			namesEqual := compareDoctrineNames(requiredDoctrine.Name, doctrine)
-
-			if namesEqual {
-				doctrines[requiredDoctrine.Name] += count
-			}
-		}
+	assigned, _ := b.assignContracts(requireDoctrines, gotDoctrines)
+	for title, doctrineName := range assigned {
+		doctrines[doctrineName] += gotDoctrines[title]
 	}
 	return doctrines
 }
 
-func (b *quartermasterBot) reportFullMessage(
-	corporationDoctrines []doctrineReport,
-	soldCorporationDoctrines map[string]int,
-	allianceDoctrines []doctrineReport,
-	soldAllianceDoctrines map[string]int,
-	alerts []alertContract,
-) []*discordgo.MessageEmbed {
+func (b *quartermasterBot) reportFullMessage(report fullReport) []*discordgo.MessageEmbed {
 	var (
-		partsCorporation, partsAlliance, partsAlerts []string
-		msgOK                                        = ":small_blue_diamond: **%s** [ƶ %.0fM, %d/mo] - stocked %d, required %d"
-		msgMissing                                   = ":small_orange_diamond: **%s** [ƶ %.0fM, %d/mo] - stocked %d, required %d"
-		msgAlert                                     = "**%s**: Reason: **%s** By: **%s**, Type: **%s**, Status: **%s**"
+		partsAlerts, partsAmbiguous []string
+		msgAlert                    = "**%s**: Reason: **%s** By: **%s**, Type: **%s**, Status: **%s**"
+		msgAmbiguous                = "**%s** (%dx) counted as **%s**, matches: %s"
 	)
 
-	for _, doctrine := range allianceDoctrines {
-		msg := msgOK
-		if doctrine.haveInStock < doctrine.doctrine.RequireStock {
-			msg = msgMissing
-		}
-		part := fmt.Sprintf(msg,
-			doctrine.doctrine.Name,
-			float64(doctrine.doctrine.Price.Buy)/1000000,
-			soldAllianceDoctrines[doctrine.doctrine.Name],
-			doctrine.haveInStock,
-			doctrine.doctrine.RequireStock,
-		)
-		partsAlliance = append(partsAlliance, part)
-	}
-
-	for _, doctrine := range corporationDoctrines {
-		msg := msgOK
-		if doctrine.haveInStock < doctrine.doctrine.RequireStock {
-			msg = msgMissing
-		}
-		part := fmt.Sprintf(msg,
-			doctrine.doctrine.Name,
-			float64(doctrine.doctrine.Price.Buy)/1000000,
-			soldCorporationDoctrines[doctrine.doctrine.Name],
-			doctrine.haveInStock,
-			doctrine.doctrine.RequireStock,
-		)
-		partsCorporation = append(partsCorporation, part)
+	// Resolve all issuer names at once.
+	var issuerIDs []int64
+	for _, alert := range report.alerts {
+		issuerIDs = append(issuerIDs, int64(alert.Contract.IssuerId))
 	}
+	b.resolveNames(issuerIDs)
 
-	for _, alert := range alerts {
+	for _, alert := range report.alerts {
 		contract := alert.Contract
 		part := fmt.Sprintf(msgAlert,
 			contract.Title,
@@ -223,58 +202,104 @@ func (b *quartermasterBot) reportFullMessage(
 		partsAlerts = append(partsAlerts, part)
 	}
 
-	var (
-		messages []*discordgo.MessageEmbed
-		color    = 0x00ff00
-	)
-	if len(allianceDoctrines) != 0 {
-		allianceMessages := splitMessageParts(partsAlliance, discordMaxDescriptionLength)
-		for _, allianceMessage := range allianceMessages {
+	for _, ambiguous := range report.ambiguous {
+		part := fmt.Sprintf(msgAmbiguous,
+			ambiguous.Title,
+			ambiguous.Count,
+			ambiguous.AssignedTo,
+			strings.Join(ambiguous.Doctrines, ", "),
+		)
+		partsAmbiguous = append(partsAmbiguous, part)
+	}
+
+	var messages []*discordgo.MessageEmbed
+	messages = append(messages, fullDoctrinesMessages("Alliance", report.allianceDoctrines, report.soldAllianceDoctrines)...)
+	messages = append(messages, fullDoctrinesMessages("Corporation", report.corporationDoctrines, report.soldCorporationDoctrines)...)
+	if len(report.alerts) != 0 {
+		alertsMessages := splitMessageParts(partsAlerts, discordMaxDescriptionLength)
+		for _, allertMessage := range alertsMessages {
 			messages = append(messages,
 				&discordgo.MessageEmbed{
 					Thumbnail: &discordgo.MessageEmbedThumbnail{
 						URL: "https://i.imgur.com/ZwUn8DI.jpg",
 					},
-					Color:       color,
-					Description: allianceMessage,
+					Color:       0xff0000,
+					Description: allertMessage,
 					Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
-					Title:       ":scroll: Doctrines full report [Alliance]",
+					Title:       ":x: Problematic contracts",
 				},
 			)
 		}
 	}
-	if len(corporationDoctrines) != 0 {
-		corporationMessages := splitMessageParts(partsCorporation, discordMaxDescriptionLength)
-		for _, corporationMessage := range corporationMessages {
+	if len(partsAmbiguous) != 0 {
+		ambiguousMessages := splitMessageParts(partsAmbiguous, discordMaxDescriptionLength)
+		for _, ambiguousMessage := range ambiguousMessages {
 			messages = append(messages,
 				&discordgo.MessageEmbed{
 					Thumbnail: &discordgo.MessageEmbedThumbnail{
 						URL: "https://i.imgur.com/ZwUn8DI.jpg",
 					},
-					Color:       color,
-					Description: corporationMessage,
+					Color:       0xffff00,
+					Description: ambiguousMessage,
 					Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
-					Title:       ":scroll: Doctrines full report [Corporation]",
+					Title:       ":warning: Ambiguous contracts",
 				},
 			)
 		}
 	}
-	if len(alerts) != 0 {
-		alertsMessages := splitMessageParts(partsAlerts, discordMaxDescriptionLength)
-		for _, allertMessage := range alertsMessages {
+
+	return messages
+}
+
+// fullDoctrinesMessages returns full report messages of the doctrines, one
+// block for each requirement location.
+func fullDoctrinesMessages(
+	contractedOn string,
+	doctrines []doctrineReport,
+	soldDoctrines map[string]int,
+) []*discordgo.MessageEmbed {
+	var (
+		messages   []*discordgo.MessageEmbed
+		color      = 0x00ff00
+		msgOK      = ":small_blue_diamond: **%s** [ƶ %.0fM, %d/mo] - stocked %d, in transit %d, required %d"
+		msgMissing = ":small_orange_diamond: **%s** [ƶ %.0fM, %d/mo] - stocked %d, in transit %d, required %d"
+	)
+
+	locations, grouped := groupReportsByLocation(doctrines)
+	for _, location := range locations {
+		var parts []string
+		for _, doctrine := range grouped[location] {
+			msg := msgOK
+			if doctrine.haveInStock < doctrine.doctrine.RequireStock {
+				msg = msgMissing
+			}
+			part := fmt.Sprintf(msg,
+				doctrine.doctrine.Name,
+				float64(doctrine.doctrine.Price.Buy)/1000000,
+				soldDoctrines[doctrine.doctrine.Name],
+				doctrine.haveInStock,
+				doctrine.inTransit,
+				doctrine.doctrine.RequireStock,
+			)
+			if doctrine.suppliedBy != "" {
+				part += fmt.Sprintf(" (%s)", doctrine.suppliedBy)
+			}
+			parts = append(parts, part)
+		}
+
+		for _, message := range splitMessageParts(parts, discordMaxDescriptionLength) {
 			messages = append(messages,
 				&discordgo.MessageEmbed{
 					Thumbnail: &discordgo.MessageEmbedThumbnail{
 						URL: "https://i.imgur.com/ZwUn8DI.jpg",
 					},
-					Color:       0xff0000,
-					Description: allertMessage,
+					Color:       color,
+					Description: message,
 					Timestamp:   time.Now().Format(time.RFC3339), // Discord wants ISO8601; RFC3339 is an extension of ISO8601 and should be completely compatible.
-					Title:       ":x: Problematic contracts",
+					Title:       locationTitle(":scroll: Doctrines full report", contractedOn, location),
 				},
 			)
 		}
 	}
-
 	return messages
 }
@@ -13,7 +13,7 @@ import (
 	"github.com/pkg/errors"
 )
 
-var requireRegex = regexp.MustCompile(`^(?P<number>[0-9]+)\s(?P<contract>[Aa]lliance|[Cc]orporation|[Cc]orp)\s(?P<name>.*)$`)
+var requireRegex = regexp.MustCompile(`^(?P<number>[0-9]+)\s(?P<contract>[Aa]lliance|[Cc]orporation|[Cc]orp)\s(?:@(?P<location>\S+)\s)?(?P<name>.*)$`)
 
 // requireHandler will be called every time a new
 // message is created on any channel that the autenticated bot has access to.
@@ -27,7 +27,7 @@ func (b *quartermasterBot) requireHandler(s *discordgo.Session, m *discordgo.Mes
 			b.sendError(err, m.ChannelID)
 			return
 		}
-		messages := requireListMessage(requiredDoctrines)
+		messages := requireListMessage(filterRequired(requiredDoctrines))
 		if len(messages) == 0 {
 			b.sendNoDoctrinesAddedMessage(m)
 			return
@@ -43,13 +43,13 @@ func (b *quartermasterBot) requireHandler(s *discordgo.Session, m *discordgo.Mes
 
 	if strings.HasPrefix(m.Content, "!require") {
 		b.log.Infow("Responding to !require command", "channel_id", m.ChannelID)
-		// Format is: "!require NN alliance|corporation Doctrine name", example: "!require 10 Alliance Shield Drake"
+		// Format is: "!require NN alliance|corporation [@Location] Doctrine name", example: "!require 10 Alliance @Forward Shield Drake"
 		commandContent := strings.TrimPrefix(m.Content, "!require ")
 		matches := requireRegex.FindAllStringSubmatch(commandContent, -1)
 
-		if len(matches) == 0 || (len(matches) != 0 && len(matches[0]) != 4) {
+		if len(matches) == 0 || (len(matches) != 0 && len(matches[0]) != 5) {
 			// Send back "unrecognised - format is ..."
-			msg := fmt.Sprintf("unrecognised !require `%s`, the format is `!require N Alliance|Corp Some doctrine`", commandContent)
+			msg := fmt.Sprintf("unrecognised !require `%s`, the format is `!require N Alliance|Corp [@Location] Some doctrine`", commandContent)
 			_, err := b.discord.ChannelMessageSend(m.ChannelID, msg)
 			if err != nil {
 				b.log.Errorw("error responding to unknown !require", "error", err)
@@ -58,7 +58,8 @@ func (b *quartermasterBot) requireHandler(s *discordgo.Session, m *discordgo.Mes
 			return
 		}
 
-		doctrineName := matches[0][3]
+		locationName := matches[0][3]
+		doctrineName := matches[0][4]
 		// It should be impossible to fail since we match with reges [0-9]
 		requireStock, _ := strconv.Atoi(matches[0][1])
 		contractOn, err := validateContractOn(strings.ToLower(matches[0][2]))
@@ -73,10 +74,38 @@ func (b *quartermasterBot) requireHandler(s *discordgo.Session, m *discordgo.Mes
 			b.sendError(err, m.ChannelID)
 			return
 		}
+		if locationName != "" {
+			_, err = b.repository.GetLocation(locationName)
+			if err != nil {
+				if errors.Is(err, repository.ErrLocationNotFound) {
+					err = errors.Errorf("location `%s` not found, add it with `!location add` first", locationName)
+				}
+				b.log.Errorw("error loading location data", "error", err)
+
+				b.sendError(err, m.ChannelID)
+				return
+			}
+		}
 		doctrine.ContractedOn = contractOn
-		doctrine.RequireStock = requireStock
 		doctrine.Name = doctrineName
-		err = b.repository.Set(doctrineName, doctrine)
+		switch {
+		case locationName == "":
+			doctrine.RequireStock = requireStock
+		case requireStock == 0:
+			delete(doctrine.LocationStock, locationName)
+		default:
+			if doctrine.LocationStock == nil {
+				doctrine.LocationStock = make(map[string]int)
+			}
+			doctrine.LocationStock[locationName] = requireStock
+		}
+		// Remove doctrines with nothing required, unless they have a fit or
+		// other settings that would be lost.
+		if !doctrine.Required() && !doctrine.HasSettings() {
+			err = b.repository.Delete(doctrineName)
+		} else {
+			err = b.repository.Set(doctrineName, doctrine)
+		}
 		if err != nil {
 			b.log.Errorw("error saving require in stock doctrine", "error", err)
 
@@ -103,11 +132,11 @@ func requireListMessage(requiredDoctrines []repository.Doctrine) []*discordgo.Me
 	})
 
 	for _, doctrine := range filterDoctrines(requiredDoctrines, repository.Corporation) {
-		partsCorporation = append(partsCorporation, fmt.Sprintf("**%s** %d", doctrine.Name, doctrine.RequireStock))
+		partsCorporation = append(partsCorporation, fmt.Sprintf("**%s** %d%s", doctrine.Name, doctrine.RequireStock, locationStockPart(doctrine)))
 	}
 
 	for _, doctrine := range filterDoctrines(requiredDoctrines, repository.Alliance) {
-		partsAlliance = append(partsAlliance, fmt.Sprintf("**%s** %d", doctrine.Name, doctrine.RequireStock))
+		partsAlliance = append(partsAlliance, fmt.Sprintf("**%s** %d%s", doctrine.Name, doctrine.RequireStock, locationStockPart(doctrine)))
 	}
 
 	var (
@@ -148,6 +177,21 @@ func requireListMessage(requiredDoctrines []repository.Doctrine) []*discordgo.Me
 	return messages
 }
 
+// locationStockPart returns ", @Forward 10" for each named location requirement.
+func locationStockPart(doctrine repository.Doctrine) string {
+	var locations []string
+	for location := range doctrine.LocationStock {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+
+	var out string
+	for _, location := range locations {
+		out += fmt.Sprintf(", @%s %d", location, doctrine.LocationStock[location])
+	}
+	return out
+}
+
 func validateContractOn(input string) (repository.ContractedOn, error) {
 	switch input {
 	case "corp", "corporation":
@@ -0,0 +1,37 @@
+package bot
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// MessageRoute decides which Discord messages the bot responds to, so that
+// bots of several corporations can share one Discord session. Zero route
+// responds to all messages.
+type MessageRoute struct {
+	GuildID   string // Respond to messages in the guild.
+	ChannelID string // Respond to messages in the channel, used when GuildID is empty.
+}
+
+// matches reports whether the message is for the bot with this route.
+func (r MessageRoute) matches(guildID, channelID string) bool {
+	switch {
+	case r.GuildID != "":
+		return guildID == r.GuildID
+	case r.ChannelID != "":
+		return channelID == r.ChannelID
+	}
+	return true
+}
+
+func IgnoreOtherRoutes(
+	route MessageRoute,
+	handler func(*discordgo.Session, *discordgo.MessageCreate),
+) func(*discordgo.Session, *discordgo.MessageCreate) {
+	return func(s *discordgo.Session, m *discordgo.MessageCreate) {
+		// Ignore messages for other corporations sharing the session.
+		if !route.matches(m.GuildID, m.ChannelID) {
+			return
+		}
+		handler(s, m)
+	}
+}
@@ -0,0 +1,750 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+)
+
+// discordgo we use predates application commands, so slash commands are
+// registered and answered by plain REST calls and read from raw gateway
+// events. Each slash command is translated to its ! command and passed to
+// the command handlers, the ! commands keep working during the transition.
+
+// Discord interaction, option and response types.
+const (
+	interactionApplicationCommand = 2
+	interactionAutocomplete       = 4
+
+	optionSubCommand = 1
+	optionString     = 3
+	optionInteger    = 4
+	optionChannel    = 7
+	optionNumber     = 10
+	optionAttachment = 11
+
+	responseChannelMessage         = 4
+	responseDeferredChannelMessage = 5
+	responseAutocomplete           = 8
+
+	// Maximum number of autocomplete choices Discord accepts.
+	maxAutocompleteChoices = 25
+)
+
+type applicationCommand struct {
+	Name         string                     `json:"name"`
+	Description  string                     `json:"description"`
+	Options      []applicationCommandOption `json:"options,omitempty"`
+	DMPermission bool                       `json:"dm_permission"`
+}
+
+type applicationCommandOption struct {
+	Type         int                        `json:"type"`
+	Name         string                     `json:"name"`
+	Description  string                     `json:"description"`
+	Required     bool                       `json:"required,omitempty"`
+	Autocomplete bool                       `json:"autocomplete,omitempty"`
+	Choices      []commandOptionChoice      `json:"choices,omitempty"`
+	Options      []applicationCommandOption `json:"options,omitempty"`
+}
+
+type commandOptionChoice struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+type interaction struct {
+	ID        string            `json:"id"`
+	Type      int               `json:"type"`
+	Token     string            `json:"token"`
+	GuildID   string            `json:"guild_id"`
+	ChannelID string            `json:"channel_id"`
+	Data      interactionData   `json:"data"`
+	Member    *discordgo.Member `json:"member"`
+}
+
+type interactionData struct {
+	Name     string              `json:"name"`
+	Options  []interactionOption `json:"options"`
+	Resolved struct {
+		Attachments map[string]struct {
+			URL string `json:"url"`
+		} `json:"attachments"`
+	} `json:"resolved"`
+}
+
+type interactionOption struct {
+	Name    string              `json:"name"`
+	Type    int                 `json:"type"`
+	Value   json.RawMessage     `json:"value"`
+	Focused bool                `json:"focused"`
+	Options []interactionOption `json:"options"`
+}
+
+// doctrineOption is doctrine name option autocompleted from required doctrines.
+func doctrineOption(description string) applicationCommandOption {
+	return applicationCommandOption{
+		Type:         optionString,
+		Name:         "doctrine",
+		Description:  description,
+		Required:     true,
+		Autocomplete: true,
+	}
+}
+
+// permCommandChoices returns commands that can be limited to roles.
+func permCommandChoices() []commandOptionChoice {
+	var choices []commandOptionChoice
+	for _, command := range restrictedCommandNames() {
+		choices = append(choices, commandOptionChoice{Name: command, Value: command})
+	}
+	return choices
+}
+
+// messageTypeChoices returns types of messages that can have their own channel.
+func messageTypeChoices() []commandOptionChoice {
+	var choices []commandOptionChoice
+	for _, messageType := range messageTypes {
+		choices = append(choices, commandOptionChoice{Name: string(messageType), Value: string(messageType)})
+	}
+	return choices
+}
+
+// slashCommands are slash versions of the ! commands.
+var slashCommands = []applicationCommand{
+	{
+		Name:        "help",
+		Description: "Show list of commands",
+	},
+	{
+		Name:        "report",
+		Description: "Show doctrine stock report",
+		Options: []applicationCommandOption{
+			{Type: optionSubCommand, Name: "missing", Description: "Show doctrines low in stock"},
+			{Type: optionSubCommand, Name: "full", Description: "Show all required doctrines with stock and missing counts"},
+		},
+	},
+	{
+		Name:        "stock",
+		Description: "Show doctrine ships currently on contract",
+	},
+	{
+		Name:        "require",
+		Description: "Manage how many doctrine ships to have on contract",
+		Options: []applicationCommandOption{
+			{
+				Type:        optionSubCommand,
+				Name:        "set",
+				Description: "Require doctrine ships on contract at all times, 0 to remove",
+				Options: []applicationCommandOption{
+					{Type: optionInteger, Name: "count", Description: "How many ships to have on contract", Required: true},
+					{
+						Type:        optionString,
+						Name:        "contracted_on",
+						Description: "Alliance or corporation contracts",
+						Required:    true,
+						Choices: []commandOptionChoice{
+							{Name: "Alliance", Value: "Alliance"},
+							{Name: "Corporation", Value: "Corporation"},
+						},
+					},
+					doctrineOption("Doctrine name, new doctrine is added"),
+					{Type: optionString, Name: "location", Description: "Named location, see !location list"},
+				},
+			},
+			{Type: optionSubCommand, Name: "list", Description: "List required doctrines"},
+		},
+	},
+	{
+		Name:        "price",
+		Description: "Manage doctrine prices",
+		Options: []applicationCommandOption{
+			{Type: optionSubCommand, Name: "fetch", Description: "Re-check price contracts starting with *"},
+			{
+				Type:        optionSubCommand,
+				Name:        "set",
+				Description: "Set doctrine price",
+				Options: []applicationCommandOption{
+					{Type: optionInteger, Name: "price", Description: "Price in ISK", Required: true},
+					doctrineOption("Doctrine name"),
+				},
+			},
+		},
+	},
+	{
+		Name:        "leaderboard",
+		Description: "Show leaderboard of haulers who made price contracts",
+		Options: []applicationCommandOption{
+			{Type: optionString, Name: "start", Description: "Start date YYYY-MM-DD, requires end"},
+			{Type: optionString, Name: "end", Description: "End date YYYY-MM-DD, requires start"},
+		},
+	},
+	{
+		Name:        "migrate",
+		Description: "Replace text in doctrine names, confirm by reacting",
+		Options: []applicationCommandOption{
+			{Type: optionString, Name: "from", Description: "Text to replace", Required: true},
+			{Type: optionString, Name: "to", Description: "Replacement", Required: true},
+		},
+	},
+	{
+		Name:        "parse-excel",
+		Description: "Bulk insert doctrines from file with columns copied from excel (sheet)",
+		Options: []applicationCommandOption{
+			{Type: optionAttachment, Name: "file", Description: "Text file with the copied columns", Required: true},
+		},
+	},
+	{
+		Name:        "doctrine",
+		Description: "Manage doctrine fits and contract matching",
+		Options: []applicationCommandOption{
+			{
+				Type:        optionSubCommand,
+				Name:        "fit",
+				Description: "Save EFT fit of the doctrine",
+				Options: []applicationCommandOption{
+					doctrineOption("Doctrine name"),
+					{Type: optionAttachment, Name: "file", Description: "Text file with the EFT fit copied from the game", Required: true},
+				},
+			},
+			{
+				Type:        optionSubCommand,
+				Name:        "show",
+				Description: "Show doctrine fit and settings",
+				Options:     []applicationCommandOption{doctrineOption("Doctrine name")},
+			},
+			{Type: optionSubCommand, Name: "sync", Description: "Create or update doctrines from in-game fittings"},
+			{
+				Type:        optionSubCommand,
+				Name:        "match",
+				Description: "Set how contract titles are matched to the doctrine",
+				Options: []applicationCommandOption{
+					doctrineOption("Doctrine name"),
+					{
+						Type:        optionString,
+						Name:        "strategy",
+						Description: "Matching strategy, default uses the global one",
+						Required:    true,
+						Choices: []commandOptionChoice{
+							{Name: MatchDefault, Value: MatchDefault},
+							{Name: MatchExact, Value: MatchExact},
+							{Name: MatchTokens, Value: MatchTokens},
+							{Name: MatchFuzzy, Value: MatchFuzzy},
+							{Name: MatchRegex, Value: MatchRegex},
+						},
+					},
+					{Type: optionNumber, Name: "threshold", Description: "Similarity threshold of fuzzy strategy, 0.8 if not set"},
+					{Type: optionString, Name: "pattern", Description: "Pattern of regex strategy, {doctrine} is the doctrine name"},
+				},
+			},
+			{
+				Type:        optionSubCommand,
+				Name:        "location",
+				Description: "Set stations or structures where doctrine contracts count",
+				Options: []applicationCommandOption{
+					doctrineOption("Doctrine name"),
+					{Type: optionString, Name: "locations", Description: "Station or structure IDs separated by spaces, or default", Required: true},
+				},
+			},
+			{
+				Type:        optionSubCommand,
+				Name:        "group",
+				Description: "Put doctrine to a group, groups can have their own channels",
+				Options: []applicationCommandOption{
+					doctrineOption("Doctrine name"),
+					{Type: optionString, Name: "group", Description: "Group name, or none", Required: true},
+				},
+			},
+		},
+	},
+	{
+		Name:        "alias",
+		Description: "Manage additional contract titles of doctrines",
+		Options: []applicationCommandOption{
+			{
+				Type:        optionSubCommand,
+				Name:        "add",
+				Description: "Accept contract title for the doctrine",
+				Options: []applicationCommandOption{
+					doctrineOption("Doctrine name"),
+					{Type: optionString, Name: "title", Description: "Contract title", Required: true},
+				},
+			},
+			{
+				Type:        optionSubCommand,
+				Name:        "remove",
+				Description: "Remove accepted contract title of the doctrine",
+				Options: []applicationCommandOption{
+					doctrineOption("Doctrine name"),
+					{Type: optionString, Name: "title", Description: "Contract title", Required: true},
+				},
+			},
+		},
+	},
+	{
+		Name:        "match",
+		Description: "Explain which doctrine a contract title counts toward",
+		Options: []applicationCommandOption{
+			{Type: optionString, Name: "title", Description: "Contract title", Required: true},
+		},
+	},
+	{
+		Name:        "location",
+		Description: "Manage named locations with their own doctrine requirements",
+		Options: []applicationCommandOption{
+			{
+				Type:        optionSubCommand,
+				Name:        "add",
+				Description: "Add or replace named location",
+				Options: []applicationCommandOption{
+					{Type: optionString, Name: "name", Description: "Location name without spaces", Required: true},
+					{Type: optionString, Name: "locations", Description: "Station or structure IDs separated by spaces", Required: true},
+				},
+			},
+			{
+				Type:        optionSubCommand,
+				Name:        "remove",
+				Description: "Remove named location",
+				Options: []applicationCommandOption{
+					{Type: optionString, Name: "name", Description: "Location name", Required: true},
+				},
+			},
+			{Type: optionSubCommand, Name: "list", Description: "List named locations"},
+		},
+	},
+	{
+		Name:        "perm",
+		Description: "Manage roles allowed to run commands",
+		Options: []applicationCommandOption{
+			{
+				Type:        optionSubCommand,
+				Name:        "set",
+				Description: "Limit command to roles",
+				Options: []applicationCommandOption{
+					{Type: optionString, Name: "command", Description: "Command to limit", Required: true, Choices: permCommandChoices()},
+					{Type: optionString, Name: "roles", Description: "Role mentions or IDs separated by spaces, or everyone", Required: true},
+				},
+			},
+			{
+				Type:        optionSubCommand,
+				Name:        "reset",
+				Description: "Use roles from the configuration for command",
+				Options: []applicationCommandOption{
+					{Type: optionString, Name: "command", Description: "Command to reset", Required: true, Choices: permCommandChoices()},
+				},
+			},
+			{Type: optionSubCommand, Name: "list", Description: "List roles allowed to run commands"},
+		},
+	},
+	{
+		Name:        "channel",
+		Description: "Manage where messages are sent",
+		Options: []applicationCommandOption{
+			{
+				Type:        optionSubCommand,
+				Name:        "set",
+				Description: "Send messages of the type to the channel",
+				Options: []applicationCommandOption{
+					{Type: optionString, Name: "type", Description: "Message type", Required: true, Choices: messageTypeChoices()},
+					{Type: optionChannel, Name: "channel", Description: "Channel to send the messages to", Required: true},
+					{Type: optionString, Name: "group", Description: "Doctrine group with its own channel"},
+				},
+			},
+			{
+				Type:        optionSubCommand,
+				Name:        "reset",
+				Description: "Send messages of the type to the default channel",
+				Options: []applicationCommandOption{
+					{Type: optionString, Name: "type", Description: "Message type", Required: true, Choices: messageTypeChoices()},
+					{Type: optionString, Name: "group", Description: "Doctrine group with its own channel"},
+				},
+			},
+			{Type: optionSubCommand, Name: "list", Description: "List channels of the messages"},
+		},
+	},
+}
+
+// registerCommands registers slash commands of the bot application. It
+// replaces all commands, so it is safe to call by every bot sharing the session.
+func (b *quartermasterBot) registerCommands() error {
+	user, err := b.discord.User("@me")
+	if err != nil {
+		return errors.Wrap(err, "unable to read bot user")
+	}
+	// Bot user ID is its application ID.
+	endpoint := discordgo.EndpointAPI + "applications/" + user.ID + "/commands"
+	_, err = b.discord.RequestWithBucketID("PUT", endpoint, slashCommands, endpoint)
+	return errors.Wrap(err, "unable to register slash commands")
+}
+
+// interactionHandler answers slash commands and doctrine name autocompletion
+// for interactions in the bot route.
+func (b *quartermasterBot) interactionHandler(s *discordgo.Session, e *discordgo.Event) {
+	if e.Type != "INTERACTION_CREATE" {
+		return
+	}
+	var i interaction
+	err := json.Unmarshal(e.RawData, &i)
+	if err != nil {
+		b.log.Errorw("error decoding interaction", "error", err)
+		return
+	}
+	// Ignore private messages, and interactions of other corporations sharing the session.
+	if i.GuildID == "" || !b.route.matches(i.GuildID, i.ChannelID) {
+		return
+	}
+
+	switch i.Type {
+	case interactionAutocomplete:
+		b.autocomplete(i)
+	case interactionApplicationCommand:
+		b.slashCommand(s, i)
+	}
+}
+
+// slashCommand translates the slash command to ! command, answers the
+// interaction with it and passes it to the command handlers. The answer is
+// the command message, so the handlers can react to it.
+func (b *quartermasterBot) slashCommand(s *discordgo.Session, i interaction) {
+	b.log.Infow("Responding to slash command", "channel_id", i.ChannelID, "command", i.Data.Name)
+	content, err := commandContent(i.Data)
+	if err != nil {
+		b.respondInteraction(i, responseChannelMessage, map[string]interface{}{
+			"content": fmt.Sprintf("Sorry, some error happened: %s", err),
+		})
+		return
+	}
+
+	var message *discordgo.Message
+	if attachmentURL(i.Data) != "" {
+		message, content, err = b.attachmentCommand(i, content)
+	} else {
+		message, err = b.echoCommand(i, content)
+	}
+	if err != nil {
+		b.log.Errorw("error answering slash command", "error", err, "command", i.Data.Name)
+		return
+	}
+
+	var author *discordgo.User
+	if i.Member != nil {
+		author = i.Member.User
+	}
+	if author == nil {
+		return
+	}
+	m := &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        message.ID,
+		ChannelID: i.ChannelID,
+		GuildID:   i.GuildID,
+		Content:   content,
+		Author:    author,
+		Member:    i.Member,
+	}}
+	handlers := b.commandHandlers()
+	if handler := b.slashHandler(i.Data); handler != nil {
+		handlers = []func(*discordgo.Session, *discordgo.MessageCreate){handler}
+	}
+	b.checkPermissions(handlers)(s, m)
+}
+
+// slashHandler returns handler running the slash command with its option
+// values, for commands whose values can't be passed to the ! command
+// handlers as text. Returns nil for the other commands.
+func (b *quartermasterBot) slashHandler(data interactionData) func(*discordgo.Session, *discordgo.MessageCreate) {
+	_, values := commandOptions(data)
+	switch data.Name {
+	case "migrate":
+		// !migrate splits FROM and TO on spaces, the options can contain them.
+		return func(s *discordgo.Session, m *discordgo.MessageCreate) {
+			b.requestMigration(m.ChannelID, values["from"], values["to"])
+		}
+	}
+	return nil
+}
+
+// echoCommand answers the interaction with the ! command and returns the answer.
+func (b *quartermasterBot) echoCommand(i interaction, content string) (*discordgo.Message, error) {
+	err := b.respondInteraction(i, responseChannelMessage, map[string]interface{}{
+		"content": fmt.Sprintf("`%s`", content),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.originalResponse(i)
+}
+
+// attachmentCommand answers the interaction first, Discord waits only 3 seconds
+// for the answer, then downloads the attached file and appends it to the !
+// command. The answer is edited to the command or the download error.
+func (b *quartermasterBot) attachmentCommand(i interaction, content string) (*discordgo.Message, string, error) {
+	err := b.respondInteraction(i, responseDeferredChannelMessage, map[string]interface{}{})
+	if err != nil {
+		return nil, "", err
+	}
+	attachment, err := downloadAttachment(attachmentURL(i.Data))
+	if err != nil {
+		_, editErr := b.editResponse(i, fmt.Sprintf("Sorry, some error happened: %s", err))
+		if editErr != nil {
+			b.log.Errorw("error editing slash command response", "error", editErr)
+		}
+		return nil, "", err
+	}
+	message, err := b.editResponse(i, fmt.Sprintf("`%s`", content))
+	if err != nil {
+		return nil, "", err
+	}
+	return message, fmt.Sprintf("%s\n%s", content, attachment), nil
+}
+
+// commandOptions returns subcommand of the slash command, empty if it has
+// none, and option name -> value of the command or its subcommand.
+func commandOptions(data interactionData) (string, map[string]string) {
+	var (
+		subcommand string
+		options    = data.Options
+	)
+	if len(options) == 1 && options[0].Type == optionSubCommand {
+		subcommand = options[0].Name
+		options = options[0].Options
+	}
+	values := make(map[string]string)
+	for _, option := range options {
+		values[option.Name] = optionValue(option)
+	}
+	return subcommand, values
+}
+
+// commandContent returns ! command of the slash command, without the
+// attached file of /parse-excel and /doctrine fit.
+func commandContent(data interactionData) (string, error) {
+	subcommand, values := commandOptions(data)
+
+	switch {
+	case data.Name == "help":
+		return "!help", nil
+	case data.Name == "report" && subcommand == "full":
+		return "!report full", nil
+	case data.Name == "report":
+		return "!report", nil
+	case data.Name == "stock":
+		return "!stock", nil
+	case data.Name == "require" && subcommand == "list":
+		return "!require list", nil
+	case data.Name == "require":
+		location := values["location"]
+		if location != "" {
+			location = fmt.Sprintf("@%s ", strings.TrimPrefix(location, "@"))
+		}
+		return fmt.Sprintf("!require %s %s %s%s", values["count"], values["contracted_on"], location, values["doctrine"]), nil
+	case data.Name == "price" && subcommand == "fetch":
+		return "!price fetch", nil
+	case data.Name == "price":
+		return fmt.Sprintf("!price set %s %s", values["price"], values["doctrine"]), nil
+	case data.Name == "leaderboard":
+		if (values["start"] == "") != (values["end"] == "") {
+			return "", errors.New("leaderboard needs both start and end date, or neither")
+		}
+		return strings.TrimSpace(fmt.Sprintf("!leaderboard %s %s", values["start"], values["end"])), nil
+	case data.Name == "migrate":
+		return fmt.Sprintf("!migrate %s %s", values["from"], values["to"]), nil
+	case data.Name == "parse-excel":
+		if attachmentURL(data) == "" {
+			return "", errors.New("attached file not found")
+		}
+		return "!parse excel", nil
+	case data.Name == "doctrine" && subcommand == "fit":
+		if attachmentURL(data) == "" {
+			return "", errors.New("attached file not found")
+		}
+		return fmt.Sprintf("!doctrine fit %s", values["doctrine"]), nil
+	case data.Name == "doctrine" && subcommand == "show":
+		return fmt.Sprintf("!doctrine show %s", values["doctrine"]), nil
+	case data.Name == "doctrine" && subcommand == "sync":
+		return "!doctrine sync", nil
+	case data.Name == "doctrine" && subcommand == "match":
+		strategy := values["strategy"]
+		switch {
+		case strategy == MatchFuzzy && values["threshold"] != "":
+			strategy = fmt.Sprintf("%s %s", strategy, values["threshold"])
+		case strategy == MatchRegex && values["pattern"] != "":
+			strategy = fmt.Sprintf("%s %s", strategy, values["pattern"])
+		}
+		return fmt.Sprintf("!doctrine match %s = %s", values["doctrine"], strategy), nil
+	case data.Name == "doctrine" && subcommand == "location":
+		return fmt.Sprintf("!doctrine location %s = %s", values["doctrine"], values["locations"]), nil
+	case data.Name == "doctrine" && subcommand == "group":
+		return fmt.Sprintf("!doctrine group %s = %s", values["doctrine"], values["group"]), nil
+	case data.Name == "alias" && (subcommand == "add" || subcommand == "remove"):
+		return fmt.Sprintf("!alias %s %s = %s", subcommand, values["doctrine"], values["title"]), nil
+	case data.Name == "match":
+		return fmt.Sprintf("!match %s", values["title"]), nil
+	case data.Name == "location" && subcommand == "add":
+		return fmt.Sprintf("!location add %s = %s", values["name"], values["locations"]), nil
+	case data.Name == "location" && subcommand == "remove":
+		return fmt.Sprintf("!location remove %s", values["name"]), nil
+	case data.Name == "perm" && subcommand == "set":
+		return fmt.Sprintf("!perm set %s = %s", values["command"], values["roles"]), nil
+	case data.Name == "perm" && subcommand == "reset":
+		return fmt.Sprintf("!perm reset %s", values["command"]), nil
+	case data.Name == "channel" && subcommand == "set":
+		return strings.Join(strings.Fields(fmt.Sprintf("!channel set %s %s <#%s>", values["type"], values["group"], values["channel"])), " "), nil
+	case data.Name == "channel" && subcommand == "reset":
+		return strings.TrimSpace(fmt.Sprintf("!channel reset %s %s", values["type"], values["group"])), nil
+	case (data.Name == "location" || data.Name == "perm" || data.Name == "channel") && subcommand == "list":
+		return fmt.Sprintf("!%s list", data.Name), nil
+	}
+	return "", errors.Errorf("unknown command: %s", data.Name)
+}
+
+// attachmentURL returns URL of the file attached to the command or its
+// subcommand, empty if there is none.
+func attachmentURL(data interactionData) string {
+	options := data.Options
+	if len(options) == 1 && options[0].Type == optionSubCommand {
+		options = options[0].Options
+	}
+	for _, option := range options {
+		if option.Type != optionAttachment {
+			continue
+		}
+		return data.Resolved.Attachments[optionValue(option)].URL
+	}
+	return ""
+}
+
+// optionValue returns option value as string, strings are unquoted.
+func optionValue(option interactionOption) string {
+	var value string
+	err := json.Unmarshal(option.Value, &value)
+	if err != nil {
+		return strings.TrimSpace(string(option.Value))
+	}
+	return strings.TrimSpace(value)
+}
+
+// attachmentClient downloads slash command attachments.
+var attachmentClient = &http.Client{Timeout: 10 * time.Second}
+
+// Maximum size of attached file, bulk insert is small text.
+const maxAttachmentSize = 1 << 20
+
+func downloadAttachment(url string) (string, error) {
+	resp, err := attachmentClient.Get(url)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to download attached file")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unable to download attached file: %s", resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxAttachmentSize))
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read attached file")
+	}
+	return string(content), nil
+}
+
+// autocomplete answers focused doctrine option with required doctrines
+// whose name contains what was typed.
+func (b *quartermasterBot) autocomplete(i interaction) {
+	option, ok := focusedOption(i.Data.Options)
+	if !ok || option.Name != "doctrine" {
+		return
+	}
+	typed := strings.ToLower(optionValue(option))
+
+	doctrines, err := b.repository.ReadAll()
+	if err != nil {
+		b.log.Errorw("error reading required doctrines for autocomplete", "error", err)
+	}
+	var names []string
+	for _, doctrine := range doctrines {
+		if strings.Contains(strings.ToLower(doctrine.Name), typed) {
+			names = append(names, doctrine.Name)
+		}
+	}
+	sort.Strings(names)
+
+	var choices = []commandOptionChoice{}
+	for _, name := range names {
+		if len(choices) == maxAutocompleteChoices {
+			break
+		}
+		choices = append(choices, commandOptionChoice{Name: name, Value: name})
+	}
+	_ = b.respondInteraction(i, responseAutocomplete, map[string]interface{}{
+		"choices": choices,
+	})
+}
+
+func focusedOption(options []interactionOption) (interactionOption, bool) {
+	for _, option := range options {
+		if option.Focused {
+			return option, true
+		}
+		if focused, ok := focusedOption(option.Options); ok {
+			return focused, true
+		}
+	}
+	return interactionOption{}, false
+}
+
+func (b *quartermasterBot) respondInteraction(i interaction, responseType int, data map[string]interface{}) error {
+	endpoint := discordgo.EndpointAPI + "interactions/" + i.ID + "/" + i.Token + "/callback"
+	_, err := b.discord.RequestWithBucketID("POST", endpoint, map[string]interface{}{
+		"type": responseType,
+		"data": data,
+	}, discordgo.EndpointAPI+"interactions/")
+	if err != nil {
+		b.log.Errorw("error responding to interaction", "error", err, "command", i.Data.Name)
+	}
+	return errors.Wrap(err, "unable to respond to interaction")
+}
+
+// editResponse replaces content of the interaction answer and returns it.
+func (b *quartermasterBot) editResponse(i interaction, content string) (*discordgo.Message, error) {
+	user, err := b.discord.User("@me")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read bot user")
+	}
+	endpoint := discordgo.EndpointWebhookToken(user.ID, i.Token) + "/messages/@original"
+	body, err := b.discord.RequestWithBucketID("PATCH", endpoint, map[string]interface{}{
+		"content": content,
+	}, discordgo.EndpointWebhooks)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to edit interaction response")
+	}
+	var message discordgo.Message
+	err = json.Unmarshal(body, &message)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode interaction response")
+	}
+	return &message, nil
+}
+
+// originalResponse returns the message the interaction was answered with.
+func (b *quartermasterBot) originalResponse(i interaction) (*discordgo.Message, error) {
+	user, err := b.discord.User("@me")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read bot user")
+	}
+	endpoint := discordgo.EndpointWebhookToken(user.ID, i.Token) + "/messages/@original"
+	body, err := b.discord.RequestWithBucketID("GET", endpoint, nil, discordgo.EndpointWebhooks)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read interaction response")
+	}
+	var message discordgo.Message
+	err = json.Unmarshal(body, &message)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode interaction response")
+	}
+	return &message, nil
+}
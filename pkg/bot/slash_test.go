@@ -0,0 +1,361 @@
+package bot
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"go.uber.org/zap"
+)
+
+// option returns interaction option with JSON encoded value.
+func option(name string, optionType int, value interface{}) interactionOption {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	return interactionOption{Name: name, Type: optionType, Value: data}
+}
+
+func subcommand(name string, options ...interactionOption) interactionOption {
+	return interactionOption{Name: name, Type: optionSubCommand, Options: options}
+}
+
+func TestCommandContent(t *testing.T) {
+	withAttachment := interactionData{
+		Name:    "parse-excel",
+		Options: []interactionOption{option("file", optionAttachment, "123")},
+	}
+	withAttachment.Resolved.Attachments = map[string]struct {
+		URL string `json:"url"`
+	}{"123": {URL: "https://cdn.discordapp.com/attachments/1/2/doctrines.txt"}}
+	fitAttachment := interactionData{Name: "doctrine", Options: []interactionOption{subcommand("fit",
+		option("doctrine", optionString, "Shield Heron"),
+		option("file", optionAttachment, "123"),
+	)}}
+	fitAttachment.Resolved.Attachments = withAttachment.Resolved.Attachments
+
+	tests := []struct {
+		name    string
+		data    interactionData
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "help",
+			data: interactionData{Name: "help"},
+			want: "!help",
+		},
+		{
+			name: "report missing",
+			data: interactionData{Name: "report", Options: []interactionOption{subcommand("missing")}},
+			want: "!report",
+		},
+		{
+			name: "report full",
+			data: interactionData{Name: "report", Options: []interactionOption{subcommand("full")}},
+			want: "!report full",
+		},
+		{
+			name: "stock",
+			data: interactionData{Name: "stock"},
+			want: "!stock",
+		},
+		{
+			name: "require list",
+			data: interactionData{Name: "require", Options: []interactionOption{subcommand("list")}},
+			want: "!require list",
+		},
+		{
+			name: "require set",
+			data: interactionData{Name: "require", Options: []interactionOption{subcommand("set",
+				option("count", optionInteger, 10),
+				option("contracted_on", optionString, "Corporation"),
+				option("doctrine", optionString, " Shield Heron "),
+			)}},
+			want: "!require 10 Corporation Shield Heron",
+		},
+		{
+			name: "require set at location",
+			data: interactionData{Name: "require", Options: []interactionOption{subcommand("set",
+				option("count", optionInteger, 5),
+				option("contracted_on", optionString, "Alliance"),
+				option("doctrine", optionString, "Heron"),
+				option("location", optionString, "@Forward"),
+			)}},
+			want: "!require 5 Alliance @Forward Heron",
+		},
+		{
+			name: "price fetch",
+			data: interactionData{Name: "price", Options: []interactionOption{subcommand("fetch")}},
+			want: "!price fetch",
+		},
+		{
+			name: "price set",
+			data: interactionData{Name: "price", Options: []interactionOption{subcommand("set",
+				option("price", optionInteger, 150000000),
+				option("doctrine", optionString, "Svipul"),
+			)}},
+			want: "!price set 150000000 Svipul",
+		},
+		{
+			name: "leaderboard",
+			data: interactionData{Name: "leaderboard"},
+			want: "!leaderboard",
+		},
+		{
+			name: "leaderboard with dates",
+			data: interactionData{Name: "leaderboard", Options: []interactionOption{
+				option("start", optionString, "2023-01-01"),
+				option("end", optionString, "2023-02-01"),
+			}},
+			want: "!leaderboard 2023-01-01 2023-02-01",
+		},
+		{
+			name: "leaderboard with start only",
+			data: interactionData{Name: "leaderboard", Options: []interactionOption{
+				option("start", optionString, "2023-01-01"),
+			}},
+			wantErr: true,
+		},
+		{
+			name: "migrate",
+			data: interactionData{Name: "migrate", Options: []interactionOption{
+				option("from", optionString, "v1"),
+				option("to", optionString, "v2"),
+			}},
+			want: "!migrate v1 v2",
+		},
+		{
+			name: "parse excel",
+			data: withAttachment,
+			want: "!parse excel",
+		},
+		{
+			name: "doctrine fit",
+			data: fitAttachment,
+			want: "!doctrine fit Shield Heron",
+		},
+		{
+			name: "doctrine fit without attachment",
+			data: interactionData{Name: "doctrine", Options: []interactionOption{subcommand("fit",
+				option("doctrine", optionString, "Shield Heron"),
+				option("file", optionAttachment, "456"),
+			)}},
+			wantErr: true,
+		},
+		{
+			name: "doctrine show",
+			data: interactionData{Name: "doctrine", Options: []interactionOption{subcommand("show",
+				option("doctrine", optionString, "Shield Heron"),
+			)}},
+			want: "!doctrine show Shield Heron",
+		},
+		{
+			name: "doctrine sync",
+			data: interactionData{Name: "doctrine", Options: []interactionOption{subcommand("sync")}},
+			want: "!doctrine sync",
+		},
+		{
+			name: "doctrine match fuzzy",
+			data: interactionData{Name: "doctrine", Options: []interactionOption{subcommand("match",
+				option("doctrine", optionString, "Shield Heron"),
+				option("strategy", optionString, "fuzzy"),
+				option("threshold", optionNumber, 0.9),
+			)}},
+			want: "!doctrine match Shield Heron = fuzzy 0.9",
+		},
+		{
+			name: "doctrine match regex",
+			data: interactionData{Name: "doctrine", Options: []interactionOption{subcommand("match",
+				option("doctrine", optionString, "Shield Heron"),
+				option("strategy", optionString, "regex"),
+				option("pattern", optionString, "^{doctrine}( v[0-9]+)?$"),
+			)}},
+			want: "!doctrine match Shield Heron = regex ^{doctrine}( v[0-9]+)?$",
+		},
+		{
+			name: "doctrine location",
+			data: interactionData{Name: "doctrine", Options: []interactionOption{subcommand("location",
+				option("doctrine", optionString, "Shield Heron"),
+				option("locations", optionString, "60003760 1022734985679"),
+			)}},
+			want: "!doctrine location Shield Heron = 60003760 1022734985679",
+		},
+		{
+			name: "doctrine group",
+			data: interactionData{Name: "doctrine", Options: []interactionOption{subcommand("group",
+				option("doctrine", optionString, "Revelation"),
+				option("group", optionString, "capitals"),
+			)}},
+			want: "!doctrine group Revelation = capitals",
+		},
+		{
+			name: "alias add",
+			data: interactionData{Name: "alias", Options: []interactionOption{subcommand("add",
+				option("doctrine", optionString, "Shield Heron"),
+				option("title", optionString, "Heron (shield)"),
+			)}},
+			want: "!alias add Shield Heron = Heron (shield)",
+		},
+		{
+			name: "alias remove",
+			data: interactionData{Name: "alias", Options: []interactionOption{subcommand("remove",
+				option("doctrine", optionString, "Shield Heron"),
+				option("title", optionString, "Heron (shield)"),
+			)}},
+			want: "!alias remove Shield Heron = Heron (shield)",
+		},
+		{
+			name: "match",
+			data: interactionData{Name: "match", Options: []interactionOption{option("title", optionString, "Heron shield v2")}},
+			want: "!match Heron shield v2",
+		},
+		{
+			name: "location add",
+			data: interactionData{Name: "location", Options: []interactionOption{subcommand("add",
+				option("name", optionString, "Forward"),
+				option("locations", optionString, "1022734985679"),
+			)}},
+			want: "!location add Forward = 1022734985679",
+		},
+		{
+			name: "location remove",
+			data: interactionData{Name: "location", Options: []interactionOption{subcommand("remove",
+				option("name", optionString, "Forward"),
+			)}},
+			want: "!location remove Forward",
+		},
+		{
+			name: "location list",
+			data: interactionData{Name: "location", Options: []interactionOption{subcommand("list")}},
+			want: "!location list",
+		},
+		{
+			name: "perm set",
+			data: interactionData{Name: "perm", Options: []interactionOption{subcommand("set",
+				option("command", optionString, "parse excel"),
+				option("roles", optionString, "<@&123> 456"),
+			)}},
+			want: "!perm set parse excel = <@&123> 456",
+		},
+		{
+			name: "perm reset",
+			data: interactionData{Name: "perm", Options: []interactionOption{subcommand("reset",
+				option("command", optionString, "require"),
+			)}},
+			want: "!perm reset require",
+		},
+		{
+			name: "perm list",
+			data: interactionData{Name: "perm", Options: []interactionOption{subcommand("list")}},
+			want: "!perm list",
+		},
+		{
+			name: "channel set",
+			data: interactionData{Name: "channel", Options: []interactionOption{subcommand("set",
+				option("type", optionString, "low_stock"),
+				option("channel", optionChannel, "789"),
+			)}},
+			want: "!channel set low_stock <#789>",
+		},
+		{
+			name: "channel set group",
+			data: interactionData{Name: "channel", Options: []interactionOption{subcommand("set",
+				option("type", optionString, "low_stock"),
+				option("channel", optionChannel, "789"),
+				option("group", optionString, "heavy capitals"),
+			)}},
+			want: "!channel set low_stock heavy capitals <#789>",
+		},
+		{
+			name: "channel reset",
+			data: interactionData{Name: "channel", Options: []interactionOption{subcommand("reset",
+				option("type", optionString, "events"),
+			)}},
+			want: "!channel reset events",
+		},
+		{
+			name: "channel list",
+			data: interactionData{Name: "channel", Options: []interactionOption{subcommand("list")}},
+			want: "!channel list",
+		},
+		{
+			name:    "parse excel without attachment",
+			data:    interactionData{Name: "parse-excel", Options: []interactionOption{option("file", optionAttachment, "456")}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown",
+			data:    interactionData{Name: "unknown"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := commandContent(test.data)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// roundTripFunc is http.RoundTripper calling the function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestSlashMigrateKeepsSpaces(t *testing.T) {
+	session, err := discordgo.New("Bot test")
+	if err != nil {
+		t.Fatalf("unable to create session: %+v", err)
+	}
+	// Every message is sent as message 1.
+	session.Client = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"id": "1", "channel_id": "10"}`)),
+			Request:    r,
+		}, nil
+	})}
+	b := &quartermasterBot{
+		log:               zap.NewNop().Sugar(),
+		discord:           session,
+		pendingMigrations: new(sync.Map),
+	}
+
+	handler := b.slashHandler(interactionData{Name: "migrate", Options: []interactionOption{
+		option("from", optionString, "Shield Heron v1"),
+		option("to", optionString, "Shield Heron v2"),
+	}})
+	if handler == nil {
+		t.Fatalf("/migrate has no slash handler")
+	}
+	handler(session, &discordgo.MessageCreate{Message: &discordgo.Message{ID: "5", ChannelID: "10"}})
+
+	value, ok := b.pendingMigrations.Load("1")
+	if !ok {
+		t.Fatalf("migration was not requested")
+	}
+	got := value.(migration)
+	if got.From != "Shield Heron v1" || got.To != "Shield Heron v2" {
+		t.Errorf("got migration %q -> %q, want \"Shield Heron v1\" -> \"Shield Heron v2\"", got.From, got.To)
+	}
+}
@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/token"
+
+	"github.com/antihax/goesi/esi"
+	"github.com/bwmarrin/discordgo"
+)
+
+// contractSnapshot is contracts loaded at one time, shared by all handlers
+// so that each command does not download contracts from ESI again.
+type contractSnapshot struct {
+	contracts []esi.GetCorporationsCorporationIdContracts200Ok
+	fetched   time.Time // When the contracts were loaded.
+	expires   time.Time // When ESI has new data, from the Expires header.
+
+	invalidated bool // Refresh was requested, for example by !price fetch.
+}
+
+// stale reports whether the snapshot should be refreshed.
+func (s contractSnapshot) stale(now time.Time, checkInterval time.Duration) bool {
+	if s.fetched.IsZero() || s.invalidated {
+		return true
+	}
+	if !now.Before(s.fetched.Add(checkInterval)) {
+		return true
+	}
+	return !s.expires.IsZero() && now.After(s.expires)
+}
+
+// contracts returns contracts snapshot, it is refreshed every checkInterval
+// or when ESI data expires. Simultaneous refreshes are collapsed into one,
+// callers wait for the one refreshing.
+func (b *quartermasterBot) contracts() (contractSnapshot, error) {
+	b.snapshotLock.Lock()
+	defer b.snapshotLock.Unlock()
+
+	if !b.snapshot.stale(time.Now(), b.checkInterval) {
+		return b.snapshot, nil
+	}
+
+	contracts, expires, err := b.loadContracts()
+	if err != nil {
+		// With revoked login keep serving the old contracts, messages
+		// show the bot is degraded and how old the data is.
+		if token.IsRevoked(err) && !b.snapshot.fetched.IsZero() {
+			b.setTokenError(err)
+			return b.snapshot, nil
+		}
+		return contractSnapshot{}, err
+	}
+	b.snapshot = contractSnapshot{
+		contracts: contracts,
+		fetched:   time.Now(),
+		expires:   expires,
+	}
+	b.snapshotTimeLock.Lock()
+	b.snapshotFetched = b.snapshot.fetched
+	b.snapshotTimeLock.Unlock()
+	return b.snapshot, nil
+}
+
+// invalidateSnapshot makes the next contracts() call load contracts from ESI,
+// the current snapshot is served again only when loading fails.
+func (b *quartermasterBot) invalidateSnapshot() {
+	b.snapshotLock.Lock()
+	defer b.snapshotLock.Unlock()
+
+	b.snapshot.invalidated = true
+}
+
+// snapshotTime returns when the current contracts snapshot was loaded. It
+// does not wait for the snapshot being refreshed.
+func (b *quartermasterBot) snapshotTime() time.Time {
+	b.snapshotTimeLock.RLock()
+	defer b.snapshotTimeLock.RUnlock()
+
+	return b.snapshotFetched
+}
+
+// addDataAge adds how old the contracts data is to the messages footer.
+func (b *quartermasterBot) addDataAge(messages []*discordgo.MessageEmbed) []*discordgo.MessageEmbed {
+	text := dataAge(b.snapshotTime(), time.Now())
+	if degraded := b.degradedText(); degraded != "" {
+		text = fmt.Sprintf("%s, %s", text, degraded)
+	}
+	for _, message := range messages {
+		message.Footer = &discordgo.MessageEmbedFooter{Text: text}
+	}
+	return messages
+}
+
+// dataAge returns text like "data as of 3 min ago".
+func dataAge(fetched, now time.Time) string {
+	age := now.Sub(fetched)
+	switch {
+	case fetched.IsZero():
+		return "no data yet"
+	case age < time.Minute:
+		return "data as of just now"
+	case age < time.Hour:
+		return fmt.Sprintf("data as of %d min ago", int(age.Minutes()))
+	}
+	return fmt.Sprintf("data as of %dh %dmin ago", int(age.Hours()), int(age.Minutes())%60)
+}
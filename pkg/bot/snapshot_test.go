@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lunemec/eve-quartermaster/pkg/esifake"
+)
+
+func TestInvalidateSnapshot(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetContracts(testCorporationID, testContracts(2))
+
+	b := newTestBot(t, server, everyone(t))
+	snapshot, err := b.contracts()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(snapshot.contracts) != 2 {
+		t.Fatalf("got %d contracts, want 2", len(snapshot.contracts))
+	}
+
+	server.SetContracts(testCorporationID, testContracts(3))
+	snapshot, err = b.contracts()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(snapshot.contracts) != 2 {
+		t.Fatalf("got %d contracts before check interval, want the snapshot of 2", len(snapshot.contracts))
+	}
+
+	b.invalidateSnapshot()
+	snapshot, err = b.contracts()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(snapshot.contracts) != 3 {
+		t.Fatalf("got %d contracts after invalidation, want 3", len(snapshot.contracts))
+	}
+}
+
+func TestSnapshotTimeDuringRefresh(t *testing.T) {
+	server := esifake.New()
+	defer server.Close()
+	server.SetContracts(testCorporationID, testContracts(2))
+
+	b := newTestBot(t, server, everyone(t))
+	_, err := b.contracts()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// Refresh in progress holds the snapshot lock.
+	b.snapshotLock.Lock()
+	defer b.snapshotLock.Unlock()
+
+	done := make(chan time.Time)
+	go func() {
+		done <- b.snapshotTime()
+	}()
+	select {
+	case fetched := <-done:
+		if fetched.IsZero() {
+			t.Errorf("got zero snapshot time")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("snapshotTime waits for the snapshot refresh")
+	}
+}
@@ -0,0 +1,167 @@
+package bot
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/antihax/goesi"
+	"github.com/antihax/goesi/esi"
+	"github.com/antihax/goesi/optional"
+	"github.com/pkg/errors"
+)
+
+// ContractSource is where the bot reads corporation contracts and other
+// data of the logged in character from.
+type ContractSource interface {
+	// ContractsPage returns one page of corporation contracts, pages
+	// are numbered from 1.
+	ContractsPage(ctx context.Context, corporationID, page int32) ([]esi.GetCorporationsCorporationIdContracts200Ok, PageInfo, error)
+	// ContractItems returns items of the corporation contract.
+	ContractItems(ctx context.Context, corporationID, contractID int32) ([]esi.GetCorporationsCorporationIdContractsContractIdItems200Ok, error)
+	// CorporationMembers returns character IDs of corporation members.
+	CorporationMembers(ctx context.Context, corporationID int32) ([]int32, error)
+	// Fittings returns personal fittings saved in-game by the character.
+	Fittings(ctx context.Context, characterID int32) ([]esi.GetCharactersCharacterIdFittings200Ok, error)
+}
+
+// PageInfo is paging and caching information of a paged response.
+type PageInfo struct {
+	Pages   int       // How many pages there are.
+	Expires time.Time // When the data expires, zero if unknown.
+}
+
+// UniverseSource resolves IDs to names and names to IDs.
+type UniverseSource interface {
+	// Names returns names of characters, corporations, types etc. The whole
+	// call fails when any of the IDs is invalid.
+	Names(ctx context.Context, ids []int32) ([]esi.PostUniverseNames200Ok, error)
+	// StructureName returns name of player structure.
+	StructureName(ctx context.Context, structureID int64) (string, error)
+	// IDs returns IDs of characters, types etc. by their exact names.
+	IDs(ctx context.Context, names []string) (esi.PostUniverseIdsOk, error)
+}
+
+// esiError is failed ESI call with ESI error limit remaining after it.
+type esiError struct {
+	error
+	errorLimitRemain int
+}
+
+func (e esiError) Unwrap() error {
+	return e.error
+}
+
+// withErrorLimit adds ESI error limit of the failed response to the error.
+func withErrorLimit(err error, resp *http.Response) error {
+	if resp == nil {
+		return err
+	}
+	remain, convErr := strconv.Atoi(resp.Header.Get("X-ESI-Error-Limit-Remain"))
+	if convErr != nil {
+		return err
+	}
+	return esiError{error: err, errorLimitRemain: remain}
+}
+
+// errorLimitRemain returns ESI error limit remaining after the failed call,
+// false if unknown.
+func errorLimitRemain(err error) (int, bool) {
+	var esiErr esiError
+	if errors.As(err, &esiErr) {
+		return esiErr.errorLimitRemain, true
+	}
+	return 0, false
+}
+
+// ESISource reads contracts and names from EVE ESI.
+type ESISource struct {
+	esi *goesi.APIClient
+}
+
+// NewESISource returns ContractSource and UniverseSource backed by the ESI client.
+func NewESISource(esi *goesi.APIClient) ESISource {
+	return ESISource{esi: esi}
+}
+
+// ContractsPage implements ContractSource.
+func (s ESISource) ContractsPage(
+	ctx context.Context,
+	corporationID, page int32,
+) ([]esi.GetCorporationsCorporationIdContracts200Ok, PageInfo, error) {
+	contracts, resp, err := s.esi.ESI.ContractsApi.GetCorporationsCorporationIdContracts(
+		ctx,
+		corporationID,
+		&esi.GetCorporationsCorporationIdContractsOpts{
+			Page: optional.NewInt32(page),
+		},
+	)
+	if err != nil {
+		return nil, PageInfo{}, errors.Wrapf(err, "error calling ESI API for page %d", page)
+	}
+	pages, err := strconv.Atoi(resp.Header.Get("X-Pages"))
+	if err != nil {
+		return nil, PageInfo{}, errors.Wrap(err, "error converting X-Pages to integer")
+	}
+	// Unknown or invalid Expires is zero time.
+	expires, _ := http.ParseTime(resp.Header.Get("Expires"))
+	return contracts, PageInfo{Pages: pages, Expires: expires}, nil
+}
+
+// ContractItems implements ContractSource.
+func (s ESISource) ContractItems(
+	ctx context.Context,
+	corporationID, contractID int32,
+) ([]esi.GetCorporationsCorporationIdContractsContractIdItems200Ok, error) {
+	items, _, err := s.esi.ESI.ContractsApi.GetCorporationsCorporationIdContractsContractIdItems(ctx, contractID, corporationID, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error calling ESI API for contract items: %d", contractID)
+	}
+	return items, nil
+}
+
+// CorporationMembers implements ContractSource.
+func (s ESISource) CorporationMembers(ctx context.Context, corporationID int32) ([]int32, error) {
+	members, _, err := s.esi.ESI.CorporationApi.GetCorporationsCorporationIdMembers(ctx, corporationID, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error calling ESI API for corporation members")
+	}
+	return members, nil
+}
+
+// Fittings implements ContractSource.
+func (s ESISource) Fittings(ctx context.Context, characterID int32) ([]esi.GetCharactersCharacterIdFittings200Ok, error) {
+	fittings, _, err := s.esi.ESI.FittingsApi.GetCharactersCharacterIdFittings(ctx, characterID, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error calling ESI API for fittings")
+	}
+	return fittings, nil
+}
+
+// Names implements UniverseSource.
+func (s ESISource) Names(ctx context.Context, ids []int32) ([]esi.PostUniverseNames200Ok, error) {
+	names, resp, err := s.esi.ESI.UniverseApi.PostUniverseNames(ctx, ids, nil)
+	if err != nil {
+		return nil, withErrorLimit(errors.Wrap(err, "error calling ESI API for names"), resp)
+	}
+	return names, nil
+}
+
+// StructureName implements UniverseSource.
+func (s ESISource) StructureName(ctx context.Context, structureID int64) (string, error) {
+	structure, _, err := s.esi.ESI.UniverseApi.GetUniverseStructuresStructureId(ctx, structureID, nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "error calling ESI API for structure: %d", structureID)
+	}
+	return structure.Name, nil
+}
+
+// IDs implements UniverseSource.
+func (s ESISource) IDs(ctx context.Context, names []string) (esi.PostUniverseIdsOk, error) {
+	ids, _, err := s.esi.ESI.UniverseApi.PostUniverseIds(ctx, names, nil)
+	if err != nil {
+		return esi.PostUniverseIdsOk{}, errors.Wrap(err, "error calling ESI API for IDs")
+	}
+	return ids, nil
+}
@@ -13,22 +13,21 @@ import (
 func (b *quartermasterBot) stockHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 	if m.Content == "!stock" {
 		b.log.Infow("Responding to !stock command", "channel_id", m.ChannelID)
-		allContracts, err := b.loadContracts()
-
+		snapshot, err := b.contracts()
 		if err != nil {
 			b.log.Errorw("error loading ESI contracts", "error", err)
 			b.sendError(err, m.ChannelID)
 			return
 		}
 		corporationContracts, allianceContracts := b.filterAndGroupContracts(
-			allContracts,
+			snapshot.contracts,
 			statusOutstanding,
 			typeItemExchange,
 			true,
 		)
 		gotCorporationDoctrines := doctrinesAvailable(corporationContracts)
 		gotAllianceDoctrines := doctrinesAvailable(allianceContracts)
-		stockMessages := stockMessage(gotCorporationDoctrines, gotAllianceDoctrines)
+		stockMessages := b.addDataAge(stockMessage(gotCorporationDoctrines, gotAllianceDoctrines))
 		for _, message := range stockMessages {
 			_, err = b.discord.ChannelMessageSendEmbed(
 				m.ChannelID,
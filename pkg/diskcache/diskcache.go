@@ -0,0 +1,159 @@
+// Package diskcache provides HTTP cache stored as files in a directory,
+// compatible with httpcache.Cache.
+package diskcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// evictTo is how much of maxBytes is kept after eviction, so that we don't
+// evict on every Set.
+const evictTo = 0.9
+
+type logger interface {
+	Errorw(string, ...interface{})
+}
+
+// Cache stores each response in its own file named by hash of the key.
+type Cache struct {
+	dir      string
+	log      logger
+	maxBytes int64
+
+	lock sync.Mutex
+	size int64
+}
+
+// New returns cache stored in the directory, which is created if it does
+// not exist. When the cache is larger than maxBytes (0 for no limit),
+// the oldest responses are evicted.
+func New(log logger, dir string, maxBytes int64) (*Cache, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create cache directory: %s", dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read cache directory: %s", dir)
+	}
+
+	var size int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		size += info.Size()
+	}
+
+	return &Cache{
+		dir:      dir,
+		log:      log,
+		maxBytes: maxBytes,
+		size:     size,
+	}, nil
+}
+
+func (c *Cache) Get(key string) ([]byte, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.log.Errorw("error reading HTTP cache", "error", err, "key", key)
+		}
+		return nil, false
+	}
+	return data, true
+}
+
+func (c *Cache) Set(key string, responseBytes []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	path := c.path(key)
+	c.size -= fileSize(path)
+	// Write to temporary file first so readers never see partial response.
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, responseBytes, 0600)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		c.log.Errorw("error saving HTTP cache", "error", err, "key", key)
+		os.Remove(tmp)
+		c.size += fileSize(path)
+		return
+	}
+	c.size += int64(len(responseBytes))
+
+	if c.maxBytes > 0 && c.size > c.maxBytes {
+		c.evict()
+	}
+}
+
+func (c *Cache) Delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	path := c.path(key)
+	size := fileSize(path)
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		c.log.Errorw("error deleting HTTP cache", "error", err, "key", key)
+		return
+	}
+	c.size -= size
+}
+
+// evict removes the oldest responses until the cache is under evictTo of
+// maxBytes, c.lock must be held.
+func (c *Cache) evict() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		c.log.Errorw("error evicting HTTP cache", "error", err)
+		return
+	}
+
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().Before(infos[j].ModTime())
+	})
+
+	for _, info := range infos {
+		if float64(c.size) <= float64(c.maxBytes)*evictTo {
+			return
+		}
+		err = os.Remove(filepath.Join(c.dir, info.Name()))
+		if err != nil {
+			c.log.Errorw("error evicting HTTP cache", "error", err, "file", info.Name())
+			continue
+		}
+		c.size -= info.Size()
+	}
+}
+
+func (c *Cache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(hash[:]))
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
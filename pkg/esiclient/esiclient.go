@@ -0,0 +1,261 @@
+// Package esiclient provides EVE ESI client that retries failed requests,
+// respects ESI error limit and does not call ESI during daily downtime.
+package esiclient
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/antihax/goesi"
+	"github.com/gregjones/httpcache"
+	"github.com/pkg/errors"
+)
+
+const userAgent = "EVE Quartermaster (lu.nemec@gmail.com)"
+
+// ErrDowntime is returned for requests made during daily ESI downtime.
+var ErrDowntime = errors.New("EVE is in daily downtime, try again later")
+
+// statusErrorLimited is returned by ESI when error limit was reached.
+const statusErrorLimited = 420
+
+type logger interface {
+	Infow(string, ...interface{})
+	Errorw(string, ...interface{})
+}
+
+// New returns ESI API client using the http client for requests.
+func New(log logger, client *http.Client) *goesi.APIClient {
+	return goesi.NewAPIClient(NewHTTPClient(log, client), userAgent)
+}
+
+// NewHTTPClient returns http client with retrying Transport. Client timeout
+// is used for each attempt instead of the whole request with retries.
+func NewHTTPClient(log logger, client *http.Client) *http.Client {
+	transport := NewTransport(log, client.Transport)
+	if client.Timeout != 0 {
+		transport.AttemptTimeout = client.Timeout
+	}
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: client.CheckRedirect,
+		Jar:           client.Jar,
+	}
+}
+
+// Transport retries requests failed by 5xx responses or timeouts with
+// jittered exponential backoff. It waits when ESI error limit is low and
+// refuses requests during daily downtime.
+type Transport struct {
+	MaxRetries     int           // How many times to retry failed request.
+	BaseDelay      time.Duration // First retry delay, doubled with each retry.
+	MaxDelay       time.Duration // Maximum retry delay.
+	AttemptTimeout time.Duration // Timeout of each attempt, 0 for no timeout.
+	MinErrorLimit  int           // Wait for error limit reset when less errors remain.
+
+	DowntimeStart    time.Duration // Daily downtime start since midnight UTC.
+	DowntimeDuration time.Duration // How long the downtime is.
+
+	log  logger
+	next http.RoundTripper
+
+	lock             sync.Mutex
+	errorLimitRemain int
+	errorLimitReset  time.Time
+}
+
+// NewTransport returns Transport with defaults suitable for ESI, next is
+// used to make the requests (http.DefaultTransport if nil).
+func NewTransport(log logger, next http.RoundTripper) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{
+		MaxRetries:       3,
+		BaseDelay:        500 * time.Millisecond,
+		MaxDelay:         10 * time.Second,
+		AttemptTimeout:   10 * time.Second,
+		MinErrorLimit:    10,
+		DowntimeStart:    11 * time.Hour,
+		DowntimeDuration: 15 * time.Minute,
+		log:              log,
+		next:             next,
+		errorLimitRemain: -1,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.inDowntime(time.Now()) {
+		return nil, ErrDowntime
+	}
+	// Requests with body can be retried only if the body can be read again.
+	canRetry := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		err := t.waitForErrorLimit(req.Context())
+		if err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to read request body for retry")
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := t.roundTrip(attemptReq)
+		if resp != nil {
+			t.updateErrorLimit(resp)
+		}
+		if !canRetry || attempt >= t.MaxRetries || !t.shouldRetry(req.Context(), resp, err) {
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		t.log.Infow("Retrying failed ESI request",
+			"url", req.URL.String(),
+			"attempt", attempt+1,
+			"delay", delay,
+			"error", err,
+			"status", statusCode(resp),
+		)
+		if resp != nil {
+			// Drain the body so the connection can be reused.
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		err = sleep(req.Context(), delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// roundTrip makes one attempt with the attempt timeout.
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.AttemptTimeout == 0 {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.AttemptTimeout)
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Body is read after RoundTrip returns, cancel the context when it is closed.
+	resp.Body = cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (t *Transport) shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	// Request was cancelled by the caller, not by attempt timeout.
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return true
+		}
+		return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == statusErrorLimited
+}
+
+// backoff returns jittered delay before the retry.
+func (t *Transport) backoff(attempt int) time.Duration {
+	delay := t.BaseDelay << attempt
+	if delay > t.MaxDelay || delay <= 0 {
+		delay = t.MaxDelay
+	}
+	// Full jitter, so retries of concurrent requests are spread out.
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// updateErrorLimit remembers error limit from ESI response headers. Cached
+// responses have headers of the time they were fetched, they are skipped.
+func (t *Transport) updateErrorLimit(resp *http.Response) {
+	if resp.Header.Get(httpcache.XFromCache) != "" {
+		return
+	}
+	remain, err := strconv.Atoi(resp.Header.Get("X-ESI-Error-Limit-Remain"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.Atoi(resp.Header.Get("X-ESI-Error-Limit-Reset"))
+	if err != nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.errorLimitRemain = remain
+	t.errorLimitReset = time.Now().Add(time.Duration(reset) * time.Second)
+	if resp.StatusCode == statusErrorLimited {
+		t.errorLimitRemain = 0
+	}
+}
+
+// waitForErrorLimit waits until error limit resets if there are too few
+// errors remaining, so that we don't get banned from ESI.
+func (t *Transport) waitForErrorLimit(ctx context.Context) error {
+	t.lock.Lock()
+	var (
+		low   = t.errorLimitRemain >= 0 && t.errorLimitRemain < t.MinErrorLimit
+		delay = time.Until(t.errorLimitReset)
+	)
+	t.lock.Unlock()
+	if !low || delay <= 0 {
+		return nil
+	}
+
+	t.log.Infow("ESI error limit is low, waiting for reset", "delay", delay)
+	return sleep(ctx, delay)
+}
+
+// inDowntime reports whether the time is in daily ESI downtime.
+func (t *Transport) inDowntime(now time.Time) bool {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	sinceMidnight := now.Sub(midnight)
+	return sinceMidnight >= t.DowntimeStart && sinceMidnight < t.DowntimeStart+t.DowntimeDuration
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func statusCode(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
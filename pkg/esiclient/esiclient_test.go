@@ -0,0 +1,62 @@
+package esiclient
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+	"go.uber.org/zap"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestErrorLimitSkipsCachedResponses(t *testing.T) {
+	var fromCache bool
+	transport := NewTransport(zap.NewNop().Sugar(), roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		header := make(http.Header)
+		header.Set("X-ESI-Error-Limit-Remain", "100")
+		header.Set("X-ESI-Error-Limit-Reset", "60")
+		if fromCache {
+			// Cached response from the time the error limit was low.
+			header.Set("X-ESI-Error-Limit-Remain", "1")
+			header.Set(httpcache.XFromCache, "1")
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     header,
+			Body:       io.NopCloser(strings.NewReader("{}")),
+			Request:    req,
+		}, nil
+	}))
+	// Tests must not depend on the time of day.
+	transport.DowntimeDuration = 0
+
+	request := func() {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, "https://esi.evetech.net/latest/status/", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		resp.Body.Close()
+	}
+
+	request()
+	if transport.errorLimitRemain != 100 {
+		t.Fatalf("got error limit %d, want 100", transport.errorLimitRemain)
+	}
+	fromCache = true
+	request()
+	if transport.errorLimitRemain != 100 {
+		t.Errorf("got error limit %d from cached response, want 100", transport.errorLimitRemain)
+	}
+}
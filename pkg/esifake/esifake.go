@@ -0,0 +1,369 @@
+// Package esifake provides fake EVE ESI server for running the bot without
+// network access. It serves scripted corporation contracts with paging
+// headers, contract items, corporation members, character fittings and names, and can be told
+// to fail requests with error responses.
+//
+// Point the bot at the server with --esi_url:
+//
+//	server := esifake.New()
+//	defer server.Close()
+//	server.SetContracts(corporationID, contracts)
+//	// quartermaster run --esi_url server.URL ...
+package esifake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/antihax/goesi/esi"
+)
+
+// DefaultPageSize is how many contracts ESI returns per page.
+const DefaultPageSize = 1000
+
+// DefaultErrorLimit is how many errors ESI allows in its error limit window.
+const DefaultErrorLimit = 100
+
+// Name categories of SetName.
+const (
+	CategoryCharacter     = "character"
+	CategoryCorporation   = "corporation"
+	CategoryAlliance      = "alliance"
+	CategoryInventoryType = "inventory_type"
+	CategoryStation       = "station"
+)
+
+// Server is fake ESI server, safe for concurrent use.
+type Server struct {
+	// URL of the server, use as ESI base path.
+	URL string
+
+	server *httptest.Server
+
+	lock       sync.Mutex
+	pageSize   int
+	expires    time.Duration
+	latency    time.Duration
+	contracts  map[int32][]esi.GetCorporationsCorporationIdContracts200Ok
+	items      map[int32][]esi.GetCorporationsCorporationIdContractsContractIdItems200Ok
+	members    map[int32][]int32
+	fittings   map[int32][]esi.GetCharactersCharacterIdFittings200Ok
+	names      map[int32]esi.PostUniverseNames200Ok
+	structures map[int64]string
+	failures   map[string][]int
+	errorLimit int
+	requests   []string
+}
+
+// New starts fake ESI server on a local port, close it with Close.
+func New() *Server {
+	s := &Server{
+		pageSize:   DefaultPageSize,
+		expires:    5 * time.Minute,
+		contracts:  make(map[int32][]esi.GetCorporationsCorporationIdContracts200Ok),
+		items:      make(map[int32][]esi.GetCorporationsCorporationIdContractsContractIdItems200Ok),
+		members:    make(map[int32][]int32),
+		fittings:   make(map[int32][]esi.GetCharactersCharacterIdFittings200Ok),
+		names:      make(map[int32]esi.PostUniverseNames200Ok),
+		structures: make(map[int64]string),
+		failures:   make(map[string][]int),
+		errorLimit: DefaultErrorLimit,
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetPageSize sets how many contracts are served per page.
+func (s *Server) SetPageSize(pageSize int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if pageSize < 1 {
+		pageSize = 1
+	}
+	s.pageSize = pageSize
+}
+
+// SetExpires sets for how long served responses are valid (Expires header).
+func (s *Server) SetExpires(expires time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.expires = expires
+}
+
+// SetErrorLimit sets how many errors remain until ESI error limit is reached,
+// each error response lowers it.
+func (s *Server) SetErrorLimit(remain int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.errorLimit = remain
+}
+
+// SetLatency sets how long each request takes, like ESI over network.
+func (s *Server) SetLatency(latency time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.latency = latency
+}
+
+// SetContracts replaces contracts of the corporation.
+func (s *Server) SetContracts(corporationID int32, contracts []esi.GetCorporationsCorporationIdContracts200Ok) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.contracts[corporationID] = contracts
+}
+
+// SetContractItems replaces items of the contract.
+func (s *Server) SetContractItems(contractID int32, items []esi.GetCorporationsCorporationIdContractsContractIdItems200Ok) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.items[contractID] = items
+}
+
+// SetMembers replaces character IDs of corporation members.
+func (s *Server) SetMembers(corporationID int32, members []int32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.members[corporationID] = members
+}
+
+// SetFittings replaces personal fittings of the character.
+func (s *Server) SetFittings(characterID int32, fittings []esi.GetCharactersCharacterIdFittings200Ok) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fittings[characterID] = fittings
+}
+
+// SetName sets name of character, type etc. ID, category is one of
+// Category constants.
+func (s *Server) SetName(id int32, name, category string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.names[id] = esi.PostUniverseNames200Ok{Id: id, Name: name, Category: category}
+}
+
+// SetStructure sets name of player structure.
+func (s *Server) SetStructure(structureID int64, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.structures[structureID] = name
+}
+
+// FailNext makes the next count requests of the path (without query, for
+// example "/v1/corporations/1/contracts/") fail with the HTTP status.
+func (s *Server) FailNext(path string, status, count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := 0; i < count; i++ {
+		s.failures[path] = append(s.failures[path], status)
+	}
+}
+
+// Requests returns method and URL of all requests served so far,
+// for example "GET /v1/corporations/1/contracts/?page=2".
+func (s *Server) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// Requests wait concurrently, only serving them is serialized.
+	s.lock.Lock()
+	latency := s.latency
+	s.lock.Unlock()
+	time.Sleep(latency)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.requests = append(s.requests, fmt.Sprintf("%s %s", r.Method, r.URL.RequestURI()))
+	if failures := s.failures[r.URL.Path]; len(failures) != 0 {
+		s.failures[r.URL.Path] = failures[1:]
+		s.writeError(w, failures[0], fmt.Sprintf("scripted failure %d", failures[0]))
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[1] == "corporations" && parts[3] == "contracts":
+		s.serveContracts(w, r, parts[2])
+	case r.Method == http.MethodGet && len(parts) == 6 && parts[1] == "corporations" && parts[3] == "contracts" && parts[5] == "items":
+		s.serveContractItems(w, parts[4])
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[1] == "corporations" && parts[3] == "members":
+		s.serveMembers(w, parts[2])
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[1] == "characters" && parts[3] == "fittings":
+		s.serveFittings(w, parts[2])
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[1] == "universe" && parts[2] == "names":
+		s.serveNames(w, r)
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[1] == "universe" && parts[2] == "ids":
+		s.serveIDs(w, r)
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[1] == "universe" && parts[2] == "structures":
+		s.serveStructure(w, parts[3])
+	default:
+		s.writeError(w, http.StatusNotFound, "Requested page does not exist!")
+	}
+}
+
+func (s *Server) serveContracts(w http.ResponseWriter, r *http.Request, corporationID string) {
+	id, err := strconv.ParseInt(corporationID, 10, 32)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid corporation_id")
+		return
+	}
+	page := 1
+	if value := r.URL.Query().Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			s.writeError(w, http.StatusBadRequest, "invalid page")
+			return
+		}
+	}
+
+	contracts := s.contracts[int32(id)]
+	pages := (len(contracts) + s.pageSize - 1) / s.pageSize
+	if pages == 0 {
+		pages = 1
+	}
+	if page > pages {
+		s.writeError(w, http.StatusNotFound, "Requested page does not exist!")
+		return
+	}
+	start := (page - 1) * s.pageSize
+	end := start + s.pageSize
+	if end > len(contracts) {
+		end = len(contracts)
+	}
+
+	w.Header().Set("X-Pages", strconv.Itoa(pages))
+	s.writeJSON(w, append([]esi.GetCorporationsCorporationIdContracts200Ok{}, contracts[start:end]...))
+}
+
+func (s *Server) serveContractItems(w http.ResponseWriter, contractID string) {
+	id, err := strconv.ParseInt(contractID, 10, 32)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid contract_id")
+		return
+	}
+	items, ok := s.items[int32(id)]
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "Contract not found!")
+		return
+	}
+	s.writeJSON(w, items)
+}
+
+func (s *Server) serveMembers(w http.ResponseWriter, corporationID string) {
+	id, err := strconv.ParseInt(corporationID, 10, 32)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid corporation_id")
+		return
+	}
+	s.writeJSON(w, append([]int32{}, s.members[int32(id)]...))
+}
+
+func (s *Server) serveFittings(w http.ResponseWriter, characterID string) {
+	id, err := strconv.ParseInt(characterID, 10, 32)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid character_id")
+		return
+	}
+	s.writeJSON(w, append([]esi.GetCharactersCharacterIdFittings200Ok{}, s.fittings[int32(id)]...))
+}
+
+func (s *Server) serveNames(w http.ResponseWriter, r *http.Request) {
+	var ids []int32
+	err := json.NewDecoder(r.Body).Decode(&ids)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid ids")
+		return
+	}
+	var out = []esi.PostUniverseNames200Ok{}
+	for _, id := range ids {
+		name, ok := s.names[id]
+		if !ok {
+			// Like ESI, one invalid ID fails the whole request.
+			s.writeError(w, http.StatusNotFound, "Ensure all IDs are valid before resolving.")
+			return
+		}
+		out = append(out, name)
+	}
+	s.writeJSON(w, out)
+}
+
+func (s *Server) serveIDs(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	err := json.NewDecoder(r.Body).Decode(&names)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid names")
+		return
+	}
+	var out esi.PostUniverseIdsOk
+	for _, name := range names {
+		for _, known := range s.names {
+			if !strings.EqualFold(known.Name, name) {
+				continue
+			}
+			switch known.Category {
+			case CategoryCharacter:
+				out.Characters = append(out.Characters, esi.PostUniverseIdsCharacter{Id: known.Id, Name: known.Name})
+			case CategoryCorporation:
+				out.Corporations = append(out.Corporations, esi.PostUniverseIdsCorporation{Id: known.Id, Name: known.Name})
+			case CategoryAlliance:
+				out.Alliances = append(out.Alliances, esi.PostUniverseIdsAlliance{Id: known.Id, Name: known.Name})
+			case CategoryInventoryType:
+				out.InventoryTypes = append(out.InventoryTypes, esi.PostUniverseIdsInventoryType{Id: known.Id, Name: known.Name})
+			case CategoryStation:
+				out.Stations = append(out.Stations, esi.PostUniverseIdsStation{Id: known.Id, Name: known.Name})
+			}
+		}
+	}
+	s.writeJSON(w, out)
+}
+
+func (s *Server) serveStructure(w http.ResponseWriter, structureID string) {
+	id, err := strconv.ParseInt(structureID, 10, 64)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, "invalid structure_id")
+		return
+	}
+	name, ok := s.structures[id]
+	if !ok {
+		s.writeError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+	s.writeJSON(w, esi.GetUniverseStructuresStructureIdOk{Name: name})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if s.expires > 0 {
+		w.Header().Set("Expires", time.Now().Add(s.expires).UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes ESI error response, with error limit headers ESI
+// sends with every error. Each error lowers the error limit.
+func (s *Server) writeError(w http.ResponseWriter, status int, message string) {
+	if s.errorLimit > 0 {
+		s.errorLimit--
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("X-ESI-Error-Limit-Remain", strconv.Itoa(s.errorLimit))
+	w.Header().Set("X-ESI-Error-Limit-Reset", "60")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -2,7 +2,9 @@ package repository
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 	"io"
 	"time"
 
@@ -13,16 +15,57 @@ import (
 type BBoltRepository interface {
 	Repository
 	PriceHistory
+	Locations
+	Permissions
+	Channels
+	Contracts
+	Events
+	Names
 	io.Closer
+
+	// HTTPCache returns HTTP cache stored in the same database.
+	HTTPCache(log Logger, maxBytes int64) (HTTPCache, error)
+	// Namespace returns repository stored in the same database with its
+	// own buckets, for one tenant of multi-corporation bot. Close of the
+	// returned repository closes the shared database.
+	Namespace(name string) (BBoltRepository, error)
+	// Namespaces returns names of namespaces in the database.
+	Namespaces() ([]string, error)
+	// CopyToNamespace copies top level buckets to the namespace when there
+	// are no namespaces yet, so the first tenant keeps data of the bot run
+	// without tenants. Returns false when nothing was copied.
+	CopyToNamespace(name string) (bool, error)
 }
 
 type bboltRepository struct {
 	db *bolt.DB
+	// namespace is name of bucket in tenants bucket with repository
+	// buckets, nil for top level buckets.
+	namespace []byte
 }
 
 var (
 	doctrinesBucket    = []byte("doctrines")
 	priceHistoryBucket = []byte("price_history")
+	locationsBucket    = []byte("locations")
+	contractsBucket    = []byte("contracts")
+	eventsBucket       = []byte("events")
+	namesBucket        = []byte("names")
+	permissionsBucket  = []byte("permissions")
+	channelsBucket     = []byte("channels")
+	tenantsBucket      = []byte("tenants")
+
+	// namespaceBuckets are the buckets of each namespace.
+	namespaceBuckets = [][]byte{
+		doctrinesBucket,
+		priceHistoryBucket,
+		locationsBucket,
+		contractsBucket,
+		eventsBucket,
+		namesBucket,
+		permissionsBucket,
+		channelsBucket,
+	}
 
 	timeFormat = time.RFC3339
 )
@@ -52,6 +95,66 @@ func NewBBoltRepository(databaseFile string) (BBoltRepository, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(locationsBucket)
+		if err != nil {
+			return errors.Wrap(err, "unable to create locations bucket")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(contractsBucket)
+		if err != nil {
+			return errors.Wrap(err, "unable to create contracts bucket")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(eventsBucket)
+		if err != nil {
+			return errors.Wrap(err, "unable to create events bucket")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(namesBucket)
+		if err != nil {
+			return errors.Wrap(err, "unable to create names bucket")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(permissionsBucket)
+		if err != nil {
+			return errors.Wrap(err, "unable to create permissions bucket")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(channelsBucket)
+		if err != nil {
+			return errors.Wrap(err, "unable to create channels bucket")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
 	return &bboltRepository{
 		db: db,
@@ -62,11 +165,127 @@ func (r *bboltRepository) Close() error {
 	return r.db.Close()
 }
 
+func (r *bboltRepository) Namespace(name string) (BBoltRepository, error) {
+	if name == "" {
+		return nil, errors.New("namespace name can't be empty")
+	}
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		tenants, err := tx.CreateBucketIfNotExists(tenantsBucket)
+		if err != nil {
+			return errors.Wrap(err, "unable to create tenants bucket")
+		}
+		tenant, err := tenants.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return errors.Wrapf(err, "unable to create tenant bucket: %s", name)
+		}
+		for _, bucket := range namespaceBuckets {
+			_, err := tenant.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return errors.Wrapf(err, "unable to create %s bucket for tenant: %s", bucket, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &bboltRepository{
+		db:        r.db,
+		namespace: []byte(name),
+	}, nil
+}
+
+func (r *bboltRepository) Namespaces() ([]string, error) {
+	var out []string
+	err := r.db.View(func(tx *bolt.Tx) error {
+		tenants := tx.Bucket(tenantsBucket)
+		if tenants == nil {
+			return nil
+		}
+		return tenants.ForEach(func(k, v []byte) error {
+			// Namespaces are nested buckets with nil value.
+			if v == nil {
+				out = append(out, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read namespaces")
+	}
+	return out, nil
+}
+
+func (r *bboltRepository) CopyToNamespace(name string) (bool, error) {
+	if name == "" {
+		return false, errors.New("namespace name can't be empty")
+	}
+	var copied bool
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(tenantsBucket) != nil {
+			return nil
+		}
+		tenants, err := tx.CreateBucket(tenantsBucket)
+		if err != nil {
+			return errors.Wrap(err, "unable to create tenants bucket")
+		}
+		tenant, err := tenants.CreateBucket([]byte(name))
+		if err != nil {
+			return errors.Wrapf(err, "unable to create tenant bucket: %s", name)
+		}
+		for _, bucket := range namespaceBuckets {
+			src := tx.Bucket(bucket)
+			if src == nil {
+				continue
+			}
+			dst, err := tenant.CreateBucket(bucket)
+			if err != nil {
+				return errors.Wrapf(err, "unable to create %s bucket for tenant: %s", bucket, name)
+			}
+			err = copyBucket(src, dst)
+			if err != nil {
+				return errors.Wrapf(err, "unable to copy %s bucket to tenant: %s", bucket, name)
+			}
+		}
+		copied = true
+		return nil
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return copied, nil
+}
+
+// copyBucket copies keys and nested buckets of src to dst.
+func copyBucket(src, dst *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		// Nested bucket has nil value.
+		if v == nil {
+			nested, err := dst.CreateBucketIfNotExists(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(src.Bucket(k), nested)
+		}
+		// Data is valid only during the transaction, it may be remapped
+		// when dst grows.
+		return dst.Put(append([]byte{}, k...), append([]byte{}, v...))
+	})
+}
+
+// bucket returns repository bucket of the namespace.
+func (r *bboltRepository) bucket(tx *bolt.Tx, name []byte) *bolt.Bucket {
+	if r.namespace == nil {
+		return tx.Bucket(name)
+	}
+	return tx.Bucket(tenantsBucket).Bucket(r.namespace).Bucket(name)
+}
+
 func (r *bboltRepository) ReadAll() ([]Doctrine, error) {
 	var out []Doctrine
 
 	err := r.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(doctrinesBucket)
+		b := r.bucket(tx, doctrinesBucket)
 
 		return b.ForEach(func(k, v []byte) error {
 			var doctrine Doctrine
@@ -88,7 +307,7 @@ func (r *bboltRepository) ReadAll() ([]Doctrine, error) {
 
 func (r *bboltRepository) WriteAll(requireStock []Doctrine) error {
 	err := r.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(doctrinesBucket)
+		b := r.bucket(tx, doctrinesBucket)
 		// We save by name each doctrine we want for later cleanup
 		// of leftovers.
 		requiredDoctrines := make(map[string]struct{})
@@ -135,7 +354,7 @@ func (r *bboltRepository) WriteAll(requireStock []Doctrine) error {
 func (r *bboltRepository) Get(doctrineName string) (Doctrine, error) {
 	var doctrine Doctrine
 	err := r.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(doctrinesBucket)
+		b := r.bucket(tx, doctrinesBucket)
 
 		data := b.Get([]byte(doctrineName))
 		if data == nil {
@@ -153,24 +372,8 @@ func (r *bboltRepository) Get(doctrineName string) (Doctrine, error) {
 }
 
 func (r *bboltRepository) Set(doctrineName string, doctrine Doctrine) error {
-	// Setting requireStock to 0 means we want to delete the doctrine.
-	if doctrine.RequireStock == 0 {
-		err := r.db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket(doctrinesBucket)
-			err := b.Delete([]byte(doctrineName))
-			if err != nil {
-				return errors.Wrapf(err, "unable to delete doctrine: %+v", doctrineName)
-			}
-			return nil
-		})
-		if err != nil {
-			return errors.Wrap(err, "unable to Set doctrine to 0")
-		}
-		return nil
-	}
-
 	err := r.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(doctrinesBucket)
+		b := r.bucket(tx, doctrinesBucket)
 		data, err := json.Marshal(&doctrine)
 		if err != nil {
 			return errors.Wrapf(err, "unable to marshal doctrine: %+v", doctrine)
@@ -187,9 +390,24 @@ func (r *bboltRepository) Set(doctrineName string, doctrine Doctrine) error {
 	return nil
 }
 
+func (r *bboltRepository) Delete(doctrineName string) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, doctrinesBucket)
+		err := b.Delete([]byte(doctrineName))
+		if err != nil {
+			return errors.Wrapf(err, "unable to delete doctrine: %+v", doctrineName)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to Delete doctrine")
+	}
+	return nil
+}
+
 func (r *bboltRepository) RecordPrice(pricedata PriceData) error {
 	err := r.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(priceHistoryBucket)
+		b := r.bucket(tx, priceHistoryBucket)
 
 		doctrineBucket, err := b.CreateBucketIfNotExists([]byte(pricedata.DoctrineName))
 		if err != nil {
@@ -216,7 +434,7 @@ func (r *bboltRepository) RecordPrice(pricedata PriceData) error {
 
 func (r *bboltRepository) WriteAllPrices(pricesdata []PriceData) error {
 	err := r.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(priceHistoryBucket)
+		b := r.bucket(tx, priceHistoryBucket)
 
 		for _, pricedata := range pricesdata {
 			doctrineBucket, err := b.CreateBucketIfNotExists([]byte(pricedata.DoctrineName))
@@ -247,7 +465,7 @@ func (r *bboltRepository) SeekPrices(start time.Time, end time.Time) ([]PriceDat
 	var out []PriceData
 
 	err := r.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(priceHistoryBucket)
+		b := r.bucket(tx, priceHistoryBucket)
 
 		err := b.ForEach(func(k, _ []byte) error {
 			doctrineBucket := b.Bucket(k)
@@ -281,7 +499,7 @@ func (r *bboltRepository) Prices() ([]PriceData, error) {
 	var out []PriceData
 
 	err := r.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(priceHistoryBucket)
+		b := r.bucket(tx, priceHistoryBucket)
 
 		err := b.ForEach(func(k, _ []byte) error {
 			doctrineBucket := b.Bucket(k)
@@ -315,7 +533,7 @@ func (r *bboltRepository) NPricesForDoctrine(doctrineName string, n int) ([]Pric
 	var out []PriceData
 
 	err := r.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(priceHistoryBucket)
+		b := r.bucket(tx, priceHistoryBucket)
 		doctrineBucket := b.Bucket([]byte(doctrineName))
 		if doctrineBucket == nil {
 			return nil
@@ -345,3 +563,498 @@ func (r *bboltRepository) NPricesForDoctrine(doctrineName string, n int) ([]Pric
 
 	return out, nil
 }
+
+// SetPrices sets prices of the doctrines in one transaction, so doctrines
+// changed meanwhile by commands keep their changes. Doctrines that no longer
+// exist are skipped.
+func (r *bboltRepository) SetPrices(prices map[string]DoctrinePrice) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, doctrinesBucket)
+
+		for doctrineName, price := range prices {
+			data := b.Get([]byte(doctrineName))
+			if data == nil {
+				continue
+			}
+			var doctrine Doctrine
+			err := json.Unmarshal(data, &doctrine)
+			if err != nil {
+				return errors.Wrapf(err, "error unmarshaling doctrine: %s", doctrineName)
+			}
+			doctrine.Price = price
+			data, err = json.Marshal(&doctrine)
+			if err != nil {
+				return errors.Wrapf(err, "unable to marshal doctrine: %+v", doctrine)
+			}
+			err = b.Put([]byte(doctrineName), data)
+			if err != nil {
+				return errors.Wrapf(err, "unable to Put doctrine: %+v", doctrine)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to set doctrine prices")
+	}
+	return nil
+}
+
+func (r *bboltRepository) ReadAllLocations() ([]Location, error) {
+	var out []Location
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, locationsBucket)
+
+		return b.ForEach(func(k, v []byte) error {
+			var location Location
+			err := json.Unmarshal(v, &location)
+			if err != nil {
+				return errors.Wrap(err, "unable to unmarshal location")
+			}
+			out = append(out, location)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading locations")
+	}
+
+	return out, nil
+}
+
+func (r *bboltRepository) GetLocation(name string) (Location, error) {
+	var location Location
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, locationsBucket)
+
+		data := b.Get([]byte(name))
+		if data == nil {
+			return ErrLocationNotFound
+		}
+		err := json.Unmarshal(data, &location)
+		if err != nil {
+			return errors.Wrapf(err, "error unmarshaling location: %+v", data)
+		}
+
+		return nil
+	})
+
+	return location, err
+}
+
+func (r *bboltRepository) SetLocation(location Location) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, locationsBucket)
+		data, err := json.Marshal(&location)
+		if err != nil {
+			return errors.Wrapf(err, "unable to marshal location: %+v", location)
+		}
+		err = b.Put([]byte(location.Name), data)
+		if err != nil {
+			return errors.Wrapf(err, "unable to Put location: %+v", location)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to Set location")
+	}
+	return nil
+}
+
+func (r *bboltRepository) DeleteLocation(name string) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, locationsBucket)
+		err := b.Delete([]byte(name))
+		if err != nil {
+			return errors.Wrapf(err, "unable to delete location: %+v", name)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to Delete location")
+	}
+	return nil
+}
+
+func (r *bboltRepository) ReadAllPermissions() ([]Permission, error) {
+	var out []Permission
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, permissionsBucket)
+
+		return b.ForEach(func(k, v []byte) error {
+			var permission Permission
+			err := json.Unmarshal(v, &permission)
+			if err != nil {
+				return errors.Wrap(err, "unable to unmarshal permission")
+			}
+			out = append(out, permission)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading permissions")
+	}
+
+	return out, nil
+}
+
+func (r *bboltRepository) SetPermission(permission Permission) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, permissionsBucket)
+		data, err := json.Marshal(&permission)
+		if err != nil {
+			return errors.Wrapf(err, "unable to marshal permission: %+v", permission)
+		}
+		err = b.Put([]byte(permission.Command), data)
+		if err != nil {
+			return errors.Wrapf(err, "unable to Put permission: %+v", permission)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to Set permission")
+	}
+	return nil
+}
+
+func (r *bboltRepository) DeletePermission(command string) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, permissionsBucket)
+		err := b.Delete([]byte(command))
+		if err != nil {
+			return errors.Wrapf(err, "unable to delete permission: %+v", command)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to Delete permission")
+	}
+	return nil
+}
+
+func (r *bboltRepository) ReadAllChannels() ([]Channel, error) {
+	var out []Channel
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, channelsBucket)
+
+		return b.ForEach(func(k, v []byte) error {
+			var channel Channel
+			err := json.Unmarshal(v, &channel)
+			if err != nil {
+				return errors.Wrap(err, "unable to unmarshal channel")
+			}
+			out = append(out, channel)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading channels")
+	}
+
+	return out, nil
+}
+
+func (r *bboltRepository) SetChannel(channel Channel) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, channelsBucket)
+		data, err := json.Marshal(&channel)
+		if err != nil {
+			return errors.Wrapf(err, "unable to marshal channel: %+v", channel)
+		}
+		err = b.Put(channelKey(channel.MessageType, channel.Group), data)
+		if err != nil {
+			return errors.Wrapf(err, "unable to Put channel: %+v", channel)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to Set channel")
+	}
+	return nil
+}
+
+func (r *bboltRepository) DeleteChannel(messageType, group string) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, channelsBucket)
+		err := b.Delete(channelKey(messageType, group))
+		if err != nil {
+			return errors.Wrapf(err, "unable to delete channel: %s %s", messageType, group)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to Delete channel")
+	}
+	return nil
+}
+
+// channelKey is key of channel of the message type and doctrine group.
+func channelKey(messageType, group string) []byte {
+	if group == "" {
+		return []byte(messageType)
+	}
+	return []byte(messageType + "/" + group)
+}
+
+// ledgerUpdatedKey is key of the last ledger update timestamp in contracts
+// bucket, contract keys are 4 bytes long so it does not collide with them.
+var ledgerUpdatedKey = []byte("ledger_updated")
+
+// UpsertContracts saves the contracts, contracts that already exist are
+// updated and their status change is recorded with the timestamp.
+func (r *bboltRepository) UpsertContracts(contracts []Contract, timestamp time.Time) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, contractsBucket)
+
+		err := b.Put(ledgerUpdatedKey, []byte(timestamp.UTC().Format(time.RFC3339Nano)))
+		if err != nil {
+			return errors.Wrap(err, "unable to Put ledger update timestamp")
+		}
+		for _, contract := range contracts {
+			key := contractKey(contract.ContractID)
+
+			var saved Contract
+			data := b.Get(key)
+			if data != nil {
+				err := json.Unmarshal(data, &saved)
+				if err != nil {
+					return errors.Wrapf(err, "error unmarshaling contract: %d", contract.ContractID)
+				}
+			}
+			contract.StatusHistory = saved.StatusHistory
+			if len(saved.StatusHistory) == 0 || saved.Status != contract.Status {
+				contract.StatusHistory = append(contract.StatusHistory, ContractStatus{
+					Status:    contract.Status,
+					Timestamp: timestamp,
+				})
+			}
+
+			data, err := json.Marshal(&contract)
+			if err != nil {
+				return errors.Wrapf(err, "unable to marshal contract: %+v", contract)
+			}
+			err = b.Put(key, data)
+			if err != nil {
+				return errors.Wrapf(err, "unable to Put contract: %+v", contract)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to Upsert contracts")
+	}
+	return nil
+}
+
+func (r *bboltRepository) ReadAllContracts() ([]Contract, error) {
+	var out []Contract
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, contractsBucket)
+
+		return b.ForEach(func(k, v []byte) error {
+			if bytes.Equal(k, ledgerUpdatedKey) {
+				return nil
+			}
+			var contract Contract
+			err := json.Unmarshal(v, &contract)
+			if err != nil {
+				return errors.Wrap(err, "unable to unmarshal contract")
+			}
+			out = append(out, contract)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading contracts")
+	}
+
+	return out, nil
+}
+
+func (r *bboltRepository) LedgerUpdated() (time.Time, error) {
+	var ledgerUpdated time.Time
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := r.bucket(tx, contractsBucket).Get(ledgerUpdatedKey)
+		if data == nil {
+			return nil
+		}
+		var err error
+		ledgerUpdated, err = time.Parse(time.RFC3339Nano, string(data))
+		return errors.Wrapf(err, "error parsing ledger update timestamp: %s", string(data))
+	})
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "unable to read ledger update timestamp")
+	}
+	return ledgerUpdated, nil
+}
+
+// SeekContracts returns contracts issued between start and end.
+func (r *bboltRepository) SeekContracts(start time.Time, end time.Time) ([]Contract, error) {
+	contracts, err := r.ReadAllContracts()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Contract
+	for _, contract := range contracts {
+		if contract.DateIssued.Before(start) || !contract.DateIssued.Before(end) {
+			continue
+		}
+		out = append(out, contract)
+	}
+	return out, nil
+}
+
+// contractKey returns big endian contract ID so contracts are sorted by ID.
+func contractKey(contractID int32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(contractID))
+	return key
+}
+
+func (r *bboltRepository) RecordEvents(events []ContractEvent) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, eventsBucket)
+
+		for _, event := range events {
+			// Key starts with the timestamp so events can be seeked by time.
+			key := fmt.Sprintf("%s/%d/%s", event.Timestamp.UTC().Format(timeFormat), event.ContractID, event.Type)
+			data, err := json.Marshal(event)
+			if err != nil {
+				return errors.Wrapf(err, "unable to encode event: %+v", event)
+			}
+			err = b.Put([]byte(key), data)
+			if err != nil {
+				return errors.Wrapf(err, "error saving event: %s %+v", key, event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to record events")
+	}
+	return nil
+}
+
+func (r *bboltRepository) SeekEvents(start time.Time, end time.Time) ([]ContractEvent, error) {
+	var out []ContractEvent
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var (
+			c   = r.bucket(tx, eventsBucket).Cursor()
+			min = []byte(start.UTC().Format(timeFormat))
+			// Keys continue after the timestamp, "/" is the separator.
+			max = []byte(end.UTC().Format(timeFormat) + "/~")
+		)
+		for k, data := c.Seek(min); k != nil && bytes.Compare(k, max) <= 0; k, data = c.Next() {
+			var event ContractEvent
+			err := json.Unmarshal(data, &event)
+			if err != nil {
+				return errors.Wrapf(err, "error unmarshaling event: %+v", string(data))
+			}
+			out = append(out, event)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read events")
+	}
+
+	return out, nil
+}
+
+// lastPublishedKey is key of the last published event timestamp in events
+// bucket, it sorts after all event keys so event seeks do not reach it.
+var lastPublishedKey = []byte("last_published")
+
+func (r *bboltRepository) LastPublished() (time.Time, error) {
+	var lastPublished time.Time
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := r.bucket(tx, eventsBucket).Get(lastPublishedKey)
+		if data == nil {
+			return nil
+		}
+		var err error
+		lastPublished, err = time.Parse(time.RFC3339Nano, string(data))
+		return errors.Wrapf(err, "error parsing last published event timestamp: %s", string(data))
+	})
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "unable to read last published event timestamp")
+	}
+	return lastPublished, nil
+}
+
+func (r *bboltRepository) SetLastPublished(timestamp time.Time) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		return r.bucket(tx, eventsBucket).Put(lastPublishedKey, []byte(timestamp.UTC().Format(time.RFC3339Nano)))
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to save last published event timestamp")
+	}
+	return nil
+}
+
+// GetNames returns saved names of the IDs, IDs without saved name are left out.
+func (r *bboltRepository) GetNames(ids []int64) (map[int64]Name, error) {
+	var out = make(map[int64]Name)
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, namesBucket)
+
+		for _, id := range ids {
+			data := b.Get(nameKey(id))
+			if data == nil {
+				continue
+			}
+			var name Name
+			err := json.Unmarshal(data, &name)
+			if err != nil {
+				return errors.Wrapf(err, "error unmarshaling name: %+v", string(data))
+			}
+			out[id] = name
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read names")
+	}
+
+	return out, nil
+}
+
+func (r *bboltRepository) SetNames(names []Name) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := r.bucket(tx, namesBucket)
+
+		for _, name := range names {
+			data, err := json.Marshal(name)
+			if err != nil {
+				return errors.Wrapf(err, "unable to encode name: %+v", name)
+			}
+			err = b.Put(nameKey(name.ID), data)
+			if err != nil {
+				return errors.Wrapf(err, "error saving name: %+v", name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to save names")
+	}
+	return nil
+}
+
+func nameKey(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
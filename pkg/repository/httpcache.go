@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+var httpCacheBucket = []byte("http_cache")
+
+// HTTPCache stores HTTP responses, it is compatible with httpcache.Cache.
+type HTTPCache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, responseBytes []byte)
+	Delete(key string)
+}
+
+// Logger logs cache errors, which can't be returned through HTTPCache.
+type Logger interface {
+	Errorw(string, ...interface{})
+}
+
+type bboltHTTPCache struct {
+	db       *bolt.DB
+	log      Logger
+	maxBytes int64
+
+	lock sync.Mutex
+	size int64
+}
+
+// evictTo is how much of maxBytes is kept after eviction, so that we don't
+// evict on every Set.
+const evictTo = 0.9
+
+// HTTPCache returns HTTP cache stored in the bbolt database. When the cache is
+// larger than maxBytes (0 for no limit), the oldest responses are evicted.
+func (r *bboltRepository) HTTPCache(log Logger, maxBytes int64) (HTTPCache, error) {
+	var size int64
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(httpCacheBucket)
+		if err != nil {
+			return errors.Wrap(err, "unable to create http_cache bucket")
+		}
+		return b.ForEach(func(k, v []byte) error {
+			size += int64(len(v))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open HTTP cache")
+	}
+
+	return &bboltHTTPCache{
+		db:       r.db,
+		log:      log,
+		maxBytes: maxBytes,
+		size:     size,
+	}, nil
+}
+
+func (c *bboltHTTPCache) Get(key string) ([]byte, bool) {
+	var out []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(httpCacheBucket).Get([]byte(key))
+		// First 8 bytes is when the response was stored.
+		if len(data) < 8 {
+			return nil
+		}
+		// Data is valid only during the transaction.
+		out = append([]byte{}, data[8:]...)
+		return nil
+	})
+	if err != nil {
+		c.log.Errorw("error reading HTTP cache", "error", err, "key", key)
+		return nil, false
+	}
+	return out, out != nil
+}
+
+func (c *bboltHTTPCache) Set(key string, responseBytes []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	value := make([]byte, 8, 8+len(responseBytes))
+	binary.BigEndian.PutUint64(value, uint64(time.Now().UnixNano()))
+	value = append(value, responseBytes...)
+
+	// Size is updated only when the transaction is committed.
+	var sizeChange int64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(httpCacheBucket)
+		sizeChange = int64(len(value)) - int64(len(b.Get([]byte(key))))
+		return b.Put([]byte(key), value)
+	})
+	if err != nil {
+		c.log.Errorw("error saving HTTP cache", "error", err, "key", key)
+		return
+	}
+	c.size += sizeChange
+	if c.maxBytes > 0 && c.size > c.maxBytes {
+		c.evict()
+	}
+}
+
+func (c *bboltHTTPCache) Delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var deleted int64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(httpCacheBucket)
+		deleted = int64(len(b.Get([]byte(key))))
+		return b.Delete([]byte(key))
+	})
+	if err != nil {
+		c.log.Errorw("error deleting HTTP cache", "error", err, "key", key)
+		return
+	}
+	c.size -= deleted
+}
+
+// evict removes the oldest responses until the cache is under evictTo of
+// maxBytes, c.lock must be held.
+func (c *bboltHTTPCache) evict() {
+	type entry struct {
+		key    []byte
+		stored uint64
+		size   int64
+	}
+
+	var size int64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		var (
+			b       = tx.Bucket(httpCacheBucket)
+			entries []entry
+		)
+		size = c.size
+		err := b.ForEach(func(k, v []byte) error {
+			var stored uint64
+			if len(v) >= 8 {
+				stored = binary.BigEndian.Uint64(v)
+			}
+			entries = append(entries, entry{key: append([]byte{}, k...), stored: stored, size: int64(len(v))})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].stored < entries[j].stored
+		})
+
+		for _, entry := range entries {
+			if float64(size) <= float64(c.maxBytes)*evictTo {
+				break
+			}
+			err = b.Delete(entry.key)
+			if err != nil {
+				return err
+			}
+			size -= entry.size
+		}
+		return nil
+	})
+	if err != nil {
+		c.log.Errorw("error evicting HTTP cache", "error", err)
+		return
+	}
+	c.size = size
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+type testLogger struct {
+	errors int
+}
+
+func (l *testLogger) Errorw(string, ...interface{}) {
+	l.errors++
+}
+
+func newTestHTTPCache(t *testing.T, maxBytes int64) (BBoltRepository, *bboltHTTPCache, *testLogger) {
+	t.Helper()
+
+	repo, err := NewBBoltRepository(filepath.Join(t.TempDir(), "repository.db"))
+	if err != nil {
+		t.Fatalf("unable to open repository: %+v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	log := &testLogger{}
+	cache, err := repo.HTTPCache(log, maxBytes)
+	if err != nil {
+		t.Fatalf("unable to open HTTP cache: %+v", err)
+	}
+	return repo, cache.(*bboltHTTPCache), log
+}
+
+func TestHTTPCacheSize(t *testing.T) {
+	_, cache, _ := newTestHTTPCache(t, 0)
+
+	cache.Set("a", make([]byte, 100))
+	cache.Set("b", make([]byte, 50))
+	// Replaced response counts only once.
+	cache.Set("a", make([]byte, 10))
+	if want := int64(8 + 10 + 8 + 50); cache.size != want {
+		t.Fatalf("got size %d, want %d", cache.size, want)
+	}
+	cache.Delete("b")
+	cache.Delete("missing")
+	if want := int64(8 + 10); cache.size != want {
+		t.Fatalf("got size %d after delete, want %d", cache.size, want)
+	}
+}
+
+func TestHTTPCacheEvict(t *testing.T) {
+	_, cache, _ := newTestHTTPCache(t, 300)
+
+	for _, key := range []string{"a", "b", "c"} {
+		cache.Set(key, make([]byte, 92))
+	}
+	// Fourth response is over the limit, the oldest are evicted to 90%.
+	cache.Set("d", make([]byte, 92))
+	if cache.size > 270 {
+		t.Fatalf("got size %d after eviction, want at most 270", cache.size)
+	}
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("oldest response was not evicted")
+	}
+	if _, ok := cache.Get("d"); !ok {
+		t.Errorf("newest response was evicted")
+	}
+}
+
+func TestHTTPCacheFailedCommit(t *testing.T) {
+	repo, cache, log := newTestHTTPCache(t, 0)
+
+	cache.Set("a", make([]byte, 100))
+	size := cache.size
+	err := repo.Close()
+	if err != nil {
+		t.Fatalf("unable to close repository: %+v", err)
+	}
+
+	cache.Set("b", make([]byte, 100))
+	cache.Delete("a")
+	if cache.size != size {
+		t.Fatalf("got size %d after failed commits, want %d", cache.size, size)
+	}
+	if log.errors != 2 {
+		t.Errorf("got %d errors logged, want 2", log.errors)
+	}
+}
@@ -15,6 +15,7 @@ type Repository interface {
 	WriteAll([]Doctrine) error
 	Get(string) (Doctrine, error)
 	Set(string, Doctrine) error
+	Delete(string) error
 }
 
 type ContractedOn string
@@ -25,10 +26,98 @@ const (
 )
 
 type Doctrine struct {
-	Name         string        `json:"name"`           // Name of the doctrine.
-	RequireStock int           `json:"require_stock"`  // How many to have on contract.
-	ContractedOn ContractedOn  `json:"contracted_on"`  // Alliance/Corporation contract.
-	Price        DoctrinePrice `json:"doctrine_price"` // Price details.
+	Name         string         `json:"name"`                // Name of the doctrine.
+	RequireStock int            `json:"require_stock"`       // How many to have on contract.
+	ContractedOn ContractedOn   `json:"contracted_on"`       // Alliance/Corporation contract.
+	Price        DoctrinePrice  `json:"doctrine_price"`      // Price details.
+	Fit          *Fit           `json:"fit,omitempty"`       // Expected contents of the contract, nil if unknown.
+	Aliases      []string       `json:"aliases,omitempty"`   // Additional accepted contract titles.
+	Match        *MatchStrategy `json:"match,omitempty"`     // Contract title matching, nil to use the global one.
+	Locations    []int64        `json:"locations,omitempty"` // Station or structure IDs where contracts count, empty for global staging.
+	Group        string         `json:"group,omitempty"`     // Doctrine group, messages about it can go to the group channels.
+
+	LocationStock map[string]int `json:"location_stock,omitempty"` // Named location -> how many to have on contract there.
+}
+
+// Required reports whether there is anything required to be on contract.
+func (d Doctrine) Required() bool {
+	return d.RequireStock > 0 || len(d.LocationStock) > 0
+}
+
+// TotalRequired returns how many are required on contract at the staging
+// and at all named locations.
+func (d Doctrine) TotalRequired() int {
+	total := d.RequireStock
+	for _, stock := range d.LocationStock {
+		total += stock
+	}
+	return total
+}
+
+// HasSettings reports whether the doctrine has anything besides requirements
+// worth keeping, like the fit or aliases. Doctrines without them are deleted
+// when nothing is required.
+func (d Doctrine) HasSettings() bool {
+	return d.Fit != nil || len(d.Aliases) != 0 || d.Match != nil ||
+		len(d.Locations) != 0 || d.Group != "" || d.Price.Buy != 0
+}
+
+// MergeRequirements returns the doctrines to write when the required doctrine
+// list is replaced by bulk import. Only stock count and contract type of the
+// existing doctrines are updated, their fits, aliases, matching, locations
+// and groups are kept. Existing doctrines missing from the list are no longer
+// required, they are kept only when they have such settings.
+func MergeRequirements(existing, required []Doctrine) []Doctrine {
+	var (
+		out        []Doctrine
+		byName     = make(map[string]Doctrine)
+		isRequired = make(map[string]struct{})
+	)
+	for _, doctrine := range existing {
+		byName[doctrine.Name] = doctrine
+	}
+	for _, doctrine := range required {
+		isRequired[doctrine.Name] = struct{}{}
+		old, ok := byName[doctrine.Name]
+		if !ok {
+			out = append(out, doctrine)
+			continue
+		}
+		old.RequireStock = doctrine.RequireStock
+		old.ContractedOn = doctrine.ContractedOn
+		out = append(out, old)
+	}
+	for _, doctrine := range existing {
+		if _, ok := isRequired[doctrine.Name]; ok || !doctrine.HasSettings() {
+			continue
+		}
+		doctrine.RequireStock = 0
+		doctrine.LocationStock = nil
+		out = append(out, doctrine)
+	}
+	return out
+}
+
+// MatchStrategy is how contract titles are matched to the doctrine.
+type MatchStrategy struct {
+	Strategy  string  `json:"strategy"`            // exact, tokens, fuzzy, regex or default.
+	Threshold float64 `json:"threshold,omitempty"` // Similarity threshold for fuzzy strategy.
+	Pattern   string  `json:"pattern,omitempty"`   // Pattern for regex strategy.
+}
+
+// Fit is list of items expected to be in doctrine contract.
+type Fit struct {
+	Hull  FitItem   `json:"hull"`  // Ship hull.
+	Items []FitItem `json:"items"` // Modules, drones, charges etc.
+	EFT   string    `json:"eft"`   // Fit in EFT format to be shown to the users.
+
+	FittingID int32 `json:"fitting_id,omitempty"` // ID of in-game fitting this fit was imported from.
+}
+
+type FitItem struct {
+	TypeID   int32  `json:"type_id"`  // EVE type ID.
+	Name     string `json:"name"`     // Name of the type, used for display.
+	Quantity int    `json:"quantity"` // How many of this type.
 }
 
 type DoctrinePrice struct {
@@ -42,6 +131,9 @@ type PriceHistory interface {
 	Prices() ([]PriceData, error)
 	NPricesForDoctrine(doctrineName string, n int) ([]PriceData, error)
 	WriteAllPrices([]PriceData) error
+	// SetPrices sets doctrine name -> price of existing doctrines at once,
+	// other doctrine fields are kept as saved.
+	SetPrices(map[string]DoctrinePrice) error
 }
 
 type PriceData struct {
@@ -52,7 +144,151 @@ type PriceData struct {
 	Price        uint64    `json:"price"`
 }
 
-var ErrNotFound = errors.New("doctrine not found")
+// Locations is named location storage, named locations can have
+// their own doctrine requirements.
+type Locations interface {
+	ReadAllLocations() ([]Location, error)
+	GetLocation(string) (Location, error)
+	SetLocation(Location) error
+	DeleteLocation(string) error
+}
+
+// Location is named group of stations or structures, for example "Forward".
+type Location struct {
+	Name string  `json:"name"`
+	IDs  []int64 `json:"ids"` // Station or structure IDs.
+}
+
+// Permissions is storage of Discord roles allowed to run commands, set by
+// !perm they replace roles from the configuration.
+type Permissions interface {
+	ReadAllPermissions() ([]Permission, error)
+	SetPermission(Permission) error
+	DeletePermission(command string) error
+}
+
+// Permission is list of Discord roles allowed to run the command.
+type Permission struct {
+	Command string   `json:"command"`
+	RoleIDs []string `json:"role_ids"` // Empty for everyone.
+}
+
+// Channels is storage of Discord channels for bot messages set by !channel,
+// they replace channels from the configuration.
+type Channels interface {
+	ReadAllChannels() ([]Channel, error)
+	SetChannel(Channel) error
+	DeleteChannel(messageType, group string) error
+}
+
+// Channel is Discord channel where messages of the type are sent.
+type Channel struct {
+	MessageType string `json:"message_type"`
+	Group       string `json:"group,omitempty"` // Doctrine group, empty for all doctrines.
+	ChannelID   string `json:"channel_id"`
+}
+
+// Contracts is ledger of all contracts ever seen, it keeps contracts after
+// ESI stops returning them.
+type Contracts interface {
+	// UpsertContracts saves the contracts and the timestamp as the time
+	// of the last ledger update.
+	UpsertContracts(contracts []Contract, timestamp time.Time) error
+	ReadAllContracts() ([]Contract, error)
+	SeekContracts(start time.Time, end time.Time) ([]Contract, error)
+	// LedgerUpdated returns time of the last ledger update, zero if the
+	// ledger was not updated yet.
+	LedgerUpdated() (time.Time, error)
+}
+
+// Contract is contract as returned by ESI with history of its status changes.
+type Contract struct {
+	ContractID          int32     `json:"contract_id"`
+	Type                string    `json:"type"`
+	Status              string    `json:"status"`
+	Title               string    `json:"title"`
+	Availability        string    `json:"availability"`
+	ForCorporation      bool      `json:"for_corporation"`
+	IssuerID            int32     `json:"issuer_id"`
+	IssuerCorporationID int32     `json:"issuer_corporation_id"`
+	AssigneeID          int32     `json:"assignee_id"`
+	AcceptorID          int32     `json:"acceptor_id"`
+	StartLocationID     int64     `json:"start_location_id"`
+	EndLocationID       int64     `json:"end_location_id"`
+	Price               float64   `json:"price"`
+	Reward              float64   `json:"reward"`
+	Collateral          float64   `json:"collateral"`
+	Buyout              float64   `json:"buyout"`
+	Volume              float64   `json:"volume"`
+	DaysToComplete      int32     `json:"days_to_complete"`
+	DateIssued          time.Time `json:"date_issued"`
+	DateExpired         time.Time `json:"date_expired"`
+	DateAccepted        time.Time `json:"date_accepted"`
+	DateCompleted       time.Time `json:"date_completed"`
+
+	StatusHistory []ContractStatus `json:"status_history"` // Status changes, oldest first.
+}
+
+// ContractStatus is status the contract had since the timestamp.
+type ContractStatus struct {
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Events is storage of contract lifecycle events.
+type Events interface {
+	RecordEvents([]ContractEvent) error
+	SeekEvents(start time.Time, end time.Time) ([]ContractEvent, error)
+	// LastPublished returns timestamp of the last event sent to event
+	// sinks, zero if none was sent yet.
+	LastPublished() (time.Time, error)
+	SetLastPublished(time.Time) error
+}
+
+type ContractEventType string
+
+const (
+	ContractCreated  ContractEventType = "contract_created"
+	ContractAccepted ContractEventType = "contract_accepted"
+	ContractExpired  ContractEventType = "contract_expired"
+	ContractDeleted  ContractEventType = "contract_deleted"
+	PriceChanged     ContractEventType = "price_changed"
+)
+
+// ContractEvent is change of a contract found between two contract snapshots.
+type ContractEvent struct {
+	Type       ContractEventType `json:"type"`
+	Timestamp  time.Time         `json:"timestamp"` // When the change was found.
+	ContractID int32             `json:"contract_id"`
+	Title      string            `json:"title"`
+	IssuerID   int32             `json:"issuer_id"`
+	AcceptorID int32             `json:"acceptor_id,omitempty"`
+	Price      float64           `json:"price"`
+	OldPrice   float64           `json:"old_price,omitempty"` // Price before the change, for PriceChanged.
+
+	Doctrine string `json:"doctrine,omitempty"` // Doctrine the contract counts toward, empty if none.
+	Location string `json:"location,omitempty"` // Named requirement location of the contract, empty for staging.
+	InStock  int    `json:"in_stock"`           // Doctrine stock at the location after the change.
+	Required int    `json:"required"`           // Doctrine required stock at the location.
+}
+
+// Names is cache of resolved EVE ID names.
+type Names interface {
+	GetNames(ids []int64) (map[int64]Name, error)
+	SetNames([]Name) error
+}
+
+// Name is name of character, corporation, type, station, structure etc.
+type Name struct {
+	ID       int64     `json:"id"`
+	Name     string    `json:"name"`
+	Resolved time.Time `json:"resolved"` // When the name was resolved, names can change.
+}
+
+var (
+	ErrNotFound         = errors.New("doctrine not found")
+	ErrLocationNotFound = errors.New("location not found")
+)
 
 // deprecated: jsonRepository must be migrated to bbolt repository.
 type jsonRepository struct {
@@ -138,30 +374,32 @@ func (r *jsonRepository) Set(doctrineName string, doctrine Doctrine) error {
 	if err != nil {
 		return errors.Wrap(err, "error reading current saved doctrines")
 	}
-	var (
-		found     bool
-		remove    bool
-		removeIdx int
-	)
-
-	if doctrine.RequireStock == 0 {
-		remove = true
-	}
 
+	var found bool
 	for i, savedDoctrine := range updatedDoctrines {
 		if savedDoctrine.Name == doctrineName {
 			found = true
-			removeIdx = i
-
 			updatedDoctrines[i] = doctrine
 			break
 		}
 	}
-	if remove {
-		updatedDoctrines = append(updatedDoctrines[:removeIdx], updatedDoctrines[removeIdx+1:]...)
-	}
-	if !found && !remove {
+	if !found {
 		updatedDoctrines = append(updatedDoctrines, doctrine)
 	}
 	return r.WriteAll(updatedDoctrines)
 }
+
+func (r *jsonRepository) Delete(doctrineName string) error {
+	savedDoctrines, err := r.ReadAll()
+	if err != nil {
+		return errors.Wrap(err, "error reading current saved doctrines")
+	}
+
+	var updatedDoctrines []Doctrine
+	for _, savedDoctrine := range savedDoctrines {
+		if savedDoctrine.Name != doctrineName {
+			updatedDoctrines = append(updatedDoctrines, savedDoctrine)
+		}
+	}
+	return r.WriteAll(updatedDoctrines)
+}
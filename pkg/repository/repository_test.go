@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeRequirements(t *testing.T) {
+	fit := &Fit{Hull: FitItem{TypeID: 34562, Name: "Svipul", Quantity: 1}}
+	existing := []Doctrine{
+		{
+			Name:          "Svipul",
+			RequireStock:  5,
+			ContractedOn:  Corporation,
+			Fit:           fit,
+			Aliases:       []string{"svip"},
+			Match:         &MatchStrategy{Strategy: "exact"},
+			Locations:     []int64{60003760},
+			Group:         "tackle",
+			LocationStock: map[string]int{"home": 2},
+		},
+		{Name: "Hurricane", RequireStock: 3, ContractedOn: Corporation, Group: "bc"},
+		{Name: "Ferox", RequireStock: 2, ContractedOn: Corporation},
+	}
+	required := []Doctrine{
+		{Name: "Svipul", RequireStock: 10, ContractedOn: Alliance},
+		{Name: "Heron", RequireStock: 1, ContractedOn: Corporation},
+	}
+
+	want := []Doctrine{
+		{
+			Name:          "Svipul",
+			RequireStock:  10,
+			ContractedOn:  Alliance,
+			Fit:           fit,
+			Aliases:       []string{"svip"},
+			Match:         &MatchStrategy{Strategy: "exact"},
+			Locations:     []int64{60003760},
+			Group:         "tackle",
+			LocationStock: map[string]int{"home": 2},
+		},
+		{Name: "Heron", RequireStock: 1, ContractedOn: Corporation},
+		// Not in the list, kept for its group but no longer required.
+		{Name: "Hurricane", ContractedOn: Corporation, Group: "bc"},
+	}
+	got := MergeRequirements(existing, required)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestCopyToNamespace(t *testing.T) {
+	repo, err := NewBBoltRepository(filepath.Join(t.TempDir(), "repository.db"))
+	if err != nil {
+		t.Fatalf("unable to open repository: %+v", err)
+	}
+	defer repo.Close()
+	doctrine := Doctrine{Name: "Svipul", RequireStock: 5, ContractedOn: Corporation}
+	err = repo.Set(doctrine.Name, doctrine)
+	if err != nil {
+		t.Fatalf("unable to set doctrine: %+v", err)
+	}
+	published := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = repo.SetLastPublished(published)
+	if err != nil {
+		t.Fatalf("unable to set last published: %+v", err)
+	}
+
+	copied, err := repo.CopyToNamespace("corp-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if !copied {
+		t.Fatalf("expected top level buckets to be copied")
+	}
+	tenant, err := repo.Namespace("corp-a")
+	if err != nil {
+		t.Fatalf("unable to open namespace: %+v", err)
+	}
+	doctrines, err := tenant.ReadAll()
+	if err != nil {
+		t.Fatalf("unable to read doctrines: %+v", err)
+	}
+	if !reflect.DeepEqual(doctrines, []Doctrine{doctrine}) {
+		t.Errorf("got doctrines %+v, want %+v", doctrines, []Doctrine{doctrine})
+	}
+	got, err := tenant.LastPublished()
+	if err != nil {
+		t.Fatalf("unable to read last published: %+v", err)
+	}
+	if !got.Equal(published) {
+		t.Errorf("got last published %s, want %s", got, published)
+	}
+
+	// Namespaces exist, nothing is copied to other tenants.
+	copied, err = repo.CopyToNamespace("corp-b")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if copied {
+		t.Errorf("expected nothing to be copied when namespaces exist")
+	}
+}
+
+func TestHasSettings(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		doctrine Doctrine
+		want     bool
+	}{
+		{name: "requirements only", doctrine: Doctrine{Name: "Svipul", RequireStock: 5, LocationStock: map[string]int{"home": 1}}},
+		{name: "fit", doctrine: Doctrine{Fit: &Fit{}}, want: true},
+		{name: "aliases", doctrine: Doctrine{Aliases: []string{"svip"}}, want: true},
+		{name: "match", doctrine: Doctrine{Match: &MatchStrategy{Strategy: "exact"}}, want: true},
+		{name: "locations", doctrine: Doctrine{Locations: []int64{60003760}}, want: true},
+		{name: "group", doctrine: Doctrine{Group: "tackle"}, want: true},
+		{name: "price", doctrine: Doctrine{Price: DoctrinePrice{Buy: 1}}, want: true},
+	} {
+		if got := test.doctrine.HasSettings(); got != test.want {
+			t.Errorf("%s: got %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLedgerUpdated(t *testing.T) {
+	repo, err := NewBBoltRepository(filepath.Join(t.TempDir(), "repository.db"))
+	if err != nil {
+		t.Fatalf("unable to open repository: %+v", err)
+	}
+	defer repo.Close()
+
+	updated, err := repo.LedgerUpdated()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if !updated.IsZero() {
+		t.Errorf("got ledger update time %s of empty ledger, want zero", updated)
+	}
+
+	timestamp := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	err = repo.UpsertContracts([]Contract{{ContractID: 1, Status: "outstanding"}}, timestamp)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	updated, err = repo.LedgerUpdated()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if !updated.Equal(timestamp) {
+		t.Errorf("got ledger update time %s, want %s", updated, timestamp)
+	}
+	contracts, err := repo.ReadAllContracts()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(contracts) != 1 || contracts[0].ContractID != 1 {
+		t.Errorf("got contracts %+v, want contract 1 only", contracts)
+	}
+}
+
+func TestSetPrices(t *testing.T) {
+	repo, err := NewBBoltRepository(filepath.Join(t.TempDir(), "repository.db"))
+	if err != nil {
+		t.Fatalf("unable to open repository: %+v", err)
+	}
+	defer repo.Close()
+	err = repo.Set("Svipul", Doctrine{Name: "Svipul", RequireStock: 5, ContractedOn: Corporation})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	// Changed by a command after prices were computed.
+	changed := Doctrine{Name: "Svipul", RequireStock: 8, ContractedOn: Corporation, Aliases: []string{"svip"}}
+	err = repo.Set("Svipul", changed)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	price := DoctrinePrice{Buy: 150000000, Timestamp: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)}
+	err = repo.SetPrices(map[string]DoctrinePrice{
+		"Svipul": price,
+		// Deleted meanwhile.
+		"Heron": {Buy: 20000000},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	doctrines, err := repo.ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	changed.Price = price
+	want := []Doctrine{changed}
+	if !reflect.DeepEqual(doctrines, want) {
+		t.Errorf("got %+v, want %+v", doctrines, want)
+	}
+}
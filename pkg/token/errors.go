@@ -0,0 +1,53 @@
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// OAuth2 error codes of token refresh that won't go away without logging in
+// again: refresh token was revoked or expired, or the EVE application was
+// deleted or its secret changed.
+var revokedCodes = map[string]struct{}{
+	"invalid_grant":       {},
+	"invalid_token":       {},
+	"invalid_client":      {},
+	"unauthorized_client": {},
+}
+
+// ErrorCode returns OAuth2 error code of failed token refresh, for example
+// "invalid_grant", and false if the error is not token refresh failure.
+func ErrorCode(err error) (string, bool) {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return "", false
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(retrieveErr.Body, &body)
+	return body.Error, true
+}
+
+// IsRevoked reports whether the error is token refresh failure that
+// requires logging in again, as opposed to network or EVE SSO outage.
+func IsRevoked(err error) bool {
+	code, ok := ErrorCode(err)
+	if !ok {
+		return false
+	}
+	if _, ok := revokedCodes[code]; ok {
+		return true
+	}
+	// EVE SSO rejects bad credentials with 400 or 401 even without error code.
+	if code == "" {
+		var retrieveErr *oauth2.RetrieveError
+		_ = errors.As(err, &retrieveErr)
+		return retrieveErr.Response != nil &&
+			(retrieveErr.Response.StatusCode == http.StatusBadRequest || retrieveErr.Response.StatusCode == http.StatusUnauthorized)
+	}
+	return false
+}
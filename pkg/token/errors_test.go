@@ -0,0 +1,77 @@
+package token
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+func retrieveError(status int, body string) *oauth2.RetrieveError {
+	return &oauth2.RetrieveError{
+		Response: &http.Response{StatusCode: status},
+		Body:     []byte(body),
+	}
+}
+
+func TestIsRevoked(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "invalid_grant",
+			err:  retrieveError(http.StatusBadRequest, `{"error": "invalid_grant", "error_description": "Invalid refresh token."}`),
+			want: true,
+		},
+		{
+			name: "invalid_client",
+			err:  retrieveError(http.StatusUnauthorized, `{"error": "invalid_client"}`),
+			want: true,
+		},
+		{
+			name: "invalid_grant wrapped",
+			err:  errors.Wrap(retrieveError(http.StatusBadRequest, `{"error": "invalid_grant"}`), "error refreshing token"),
+			want: true,
+		},
+		{
+			name: "bad request without error code",
+			err:  retrieveError(http.StatusBadRequest, `Bad Request`),
+			want: true,
+		},
+		{
+			name: "other OAuth error",
+			err:  retrieveError(http.StatusBadRequest, `{"error": "invalid_request"}`),
+			want: false,
+		},
+		{
+			name: "other OAuth error wrapped",
+			err:  errors.Wrap(retrieveError(http.StatusServiceUnavailable, `{"error": "temporarily_unavailable"}`), "error refreshing token"),
+			want: false,
+		},
+		{
+			name: "EVE SSO outage",
+			err:  retrieveError(http.StatusBadGateway, `<html>Bad Gateway</html>`),
+			want: false,
+		},
+		{
+			name: "network error",
+			err:  errors.Wrap(errors.New("connection refused"), "error refreshing token"),
+			want: false,
+		},
+		{
+			name: "nil",
+			err:  nil,
+			want: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsRevoked(test.err); got != test.want {
+				t.Errorf("IsRevoked(%v) = %t, want %t", test.err, got, test.want)
+			}
+		})
+	}
+}
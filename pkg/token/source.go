@@ -2,6 +2,7 @@ package token
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/antihax/goesi"
 	"github.com/pkg/errors"
@@ -23,6 +24,11 @@ type logger interface {
 type source struct {
 	sso     *goesi.SSOAuthenticator
 	storage Storage
+
+	// Token is called by concurrent ESI requests, the token is read from
+	// storage once and written only when it is refreshed.
+	lock  sync.Mutex
+	token *oauth2.Token
 }
 
 // NewSource returns new token source from storage.
@@ -35,30 +41,38 @@ func NewSource(log logger, client *http.Client, storage Storage, secretKey []byt
 }
 
 func (s *source) Token() (*oauth2.Token, error) {
-	ts, err := s.TokenSource()
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to read token")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.token == nil {
+		token, err := s.storage.Read()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read token")
+		}
+		s.token = &token
 	}
-	newToken, err := ts.Token()
+	// Token is refreshed only when it expired.
+	newToken, err := s.sso.TokenSource(s.token).Token()
 	if err != nil {
 		return nil, errors.Wrapf(err, "error getting token")
 	}
 
-	// Save token.
-	err = s.storage.Write(*newToken)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to save refreshed token")
+	// Save refreshed token.
+	if newToken.AccessToken != s.token.AccessToken || newToken.RefreshToken != s.token.RefreshToken {
+		err = s.storage.Write(*newToken)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to save refreshed token")
+		}
+		s.token = newToken
 	}
 
-	return newToken, nil
+	token := *s.token
+	return &token, nil
 }
 
+// TokenSource returns the source itself, so refreshed token is saved.
 func (s *source) TokenSource() (oauth2.TokenSource, error) {
-	token, err := s.storage.Read()
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to read token")
-	}
-	return s.sso.TokenSource(&token), nil
+	return s, nil
 }
 
 func (s *source) Verify() (*goesi.VerifyResponse, error) {
@@ -0,0 +1,115 @@
+package token
+
+import (
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+type countingStorage struct {
+	lock   sync.Mutex
+	token  oauth2.Token
+	reads  int
+	writes int
+}
+
+func (s *countingStorage) Read() (oauth2.Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reads++
+	return s.token, nil
+}
+
+func (s *countingStorage) Write(token oauth2.Token) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.writes++
+	s.token = token
+	return nil
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Infow(string, ...interface{})  {}
+func (nopLogger) Errorw(string, ...interface{}) {}
+
+func validToken() oauth2.Token {
+	return oauth2.Token{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(time.Hour),
+	}
+}
+
+func TestSourceConcurrentToken(t *testing.T) {
+	storage := &countingStorage{token: validToken()}
+	source := NewSource(nopLogger{}, http.DefaultClient, storage, nil, "client", "secret", "http://localhost/callback", nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := source.Token()
+			if err != nil {
+				t.Errorf("unexpected error: %+v", err)
+				return
+			}
+			if token.AccessToken != "access" {
+				t.Errorf("got access token %s, want access", token.AccessToken)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Valid token is read once and never written.
+	if storage.reads != 1 || storage.writes != 0 {
+		t.Errorf("got %d reads and %d writes, want 1 read and no writes", storage.reads, storage.writes)
+	}
+}
+
+func TestFileStorageConcurrentReadWrite(t *testing.T) {
+	storage := NewFileStorage(filepath.Join(t.TempDir(), "auth.bin"))
+	err := storage.Write(validToken())
+	if err != nil {
+		t.Fatalf("unable to write token: %+v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			err := storage.Write(validToken())
+			if err != nil {
+				t.Errorf("unable to write token: %+v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// The file is never read half written.
+			token, err := storage.Read()
+			if err != nil {
+				t.Errorf("unable to read token: %+v", err)
+				return
+			}
+			if token.RefreshToken != "refresh" {
+				t.Errorf("got refresh token %s, want refresh", token.RefreshToken)
+			}
+		}()
+	}
+	wg.Wait()
+
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(storage.(*fileStorage).filename), "*.tmp"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
@@ -3,6 +3,7 @@ package token
 import (
 	"encoding/gob"
 	"os"
+	"path/filepath"
 
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
@@ -41,13 +42,26 @@ func (fs *fileStorage) Read() (oauth2.Token, error) {
 	return out, nil
 }
 
-// Write truncates the file and replaces it with supplied token.
+// Write replaces the file with supplied token. The token is written to
+// temporary file renamed over the file, so the file is never read half
+// written.
 func (fs *fileStorage) Write(token oauth2.Token) error {
-	f, err := os.Create(fs.filename)
+	f, err := os.CreateTemp(filepath.Dir(fs.filename), filepath.Base(fs.filename)+".*.tmp")
 	if err != nil {
-		return errors.Wrapf(err, "unable to create file: %s", fs.filename)
+		return errors.Wrapf(err, "unable to create temporary file for: %s", fs.filename)
 	}
-	defer f.Close()
+	// Does nothing once the file is renamed.
+	defer os.Remove(f.Name())
+
 	enc := gob.NewEncoder(f)
-	return errors.Wrap(enc.Encode(token), "error encoding auth file")
+	err = enc.Encode(token)
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "error encoding auth file")
+	}
+	err = f.Close()
+	if err != nil {
+		return errors.Wrapf(err, "unable to write file: %s", f.Name())
+	}
+	return errors.Wrapf(os.Rename(f.Name(), fs.filename), "unable to replace file: %s", fs.filename)
 }